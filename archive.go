@@ -0,0 +1,630 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+// dockerArchiveEntry is an image in the manifest.json written by docker save.
+type dockerArchiveEntry struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// The annotations image layouts use to name the images in their index.
+const (
+	imageNameAnnotation = "io.containerd.image.name"
+	refNameAnnotation   = "org.opencontainers.image.ref.name"
+)
+
+// SaveImages writes the images to output as a tar archive which is both an
+// OCI image layout and what docker save writes, so it can be read by
+// docker load as well as LoadImages. The layers are rebuilt from their
+// snapshots as uncompressed tarballs, which gives the saved images
+// different digests than the ones in the registry.
+func SaveImages(images []string, output string) error {
+	if output == "-" && isTerminal(os.Stdout) {
+		return fmt.Errorf("refusing to write a tar archive to a terminal, use -o or redirect stdout")
+	}
+	lock, err := LockStore(false)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	w := os.Stdout
+	if output != "-" {
+		if w, err = os.Create(output); err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+	s := &imageSaver{tw: tar.NewWriter(w), blobs: map[string]bool{}, layers: map[string]Layer{}}
+	var index []Manifest
+	var entries []dockerArchiveEntry
+	for _, image := range images {
+		ref, err := registry.ParseReference(image)
+		if err != nil {
+			return err
+		}
+		desc, entry, err := s.save(ref)
+		if err != nil {
+			return fmt.Errorf("failed to save %s: %w", image, err)
+		}
+		index = append(index, desc)
+		entries = append(entries, entry)
+	}
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"oci-layout", map[string]string{"imageLayoutVersion": "1.0.0"}},
+		{"index.json", map[string]any{"schemaVersion": 2, "mediaType": registry.MediaTypeOCIIndex, "manifests": index}},
+		{"manifest.json", entries},
+	}
+	for _, f := range files {
+		data, err := json.Marshal(f.v)
+		if err != nil {
+			return err
+		}
+		if err := s.writeFile(f.name, data); err != nil {
+			return err
+		}
+	}
+	if err := s.tw.Close(); err != nil {
+		return err
+	}
+	if output == "-" {
+		return nil
+	}
+	return w.Close()
+}
+
+type imageSaver struct {
+	tw *tar.Writer
+	// blobs are the digests which have already been written
+	blobs map[string]bool
+	// layers are the descriptors of the snapshots which have been written
+	layers map[string]Layer
+}
+
+// save adds the blobs of the image and returns its entries for the
+// index.json and manifest.json.
+func (s *imageSaver) save(ref Reference) (Manifest, dockerArchiveEntry, error) {
+	dirs, err := FetchImageSnapshots(context.Background(), ref, PullMissing)
+	if err != nil {
+		return Manifest{}, dockerArchiveEntry{}, err
+	}
+	m, _, err := readLocalImage(ref)
+	if err != nil {
+		return Manifest{}, dockerArchiveEntry{}, err
+	}
+	// the index entry has to say what the image is for, not what this
+	// process pulls for
+	rec, err := ReadImageRecord(ref)
+	if err != nil {
+		return Manifest{}, dockerArchiveEntry{}, err
+	}
+	platform := registry.HostPlatform()
+	if rec.Platform != "" {
+		if platform, err = registry.ParsePlatform(rec.Platform); err != nil {
+			return Manifest{}, dockerArchiveEntry{}, err
+		}
+	}
+	p, err := BlobPath(m.Config.Digest)
+	if err != nil {
+		return Manifest{}, dockerArchiveEntry{}, err
+	}
+	configData, err := os.ReadFile(p)
+	if err != nil {
+		return Manifest{}, dockerArchiveEntry{}, err
+	}
+	saved := ImageManifest{MediaType: registry.MediaTypeOCIManifest}
+	var entry dockerArchiveEntry
+	var diffIDs []string
+	for _, dir := range dirs {
+		layer, err := s.saveLayer(dir)
+		if err != nil {
+			return Manifest{}, dockerArchiveEntry{}, err
+		}
+		saved.Layers = append(saved.Layers, layer)
+		entry.Layers = append(entry.Layers, blobName(layer.Digest))
+		// the layers are uncompressed, so their digests are the diff ids
+		diffIDs = append(diffIDs, layer.Digest)
+	}
+	// everything but the diff ids, like the history, is kept as it was
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(configData, &config); err != nil {
+		return Manifest{}, dockerArchiveEntry{}, err
+	}
+	if config["rootfs"], err = json.Marshal(map[string]any{"type": "layers", "diff_ids": diffIDs}); err != nil {
+		return Manifest{}, dockerArchiveEntry{}, err
+	}
+	if configData, err = json.Marshal(config); err != nil {
+		return Manifest{}, dockerArchiveEntry{}, err
+	}
+	saved.Config, err = s.writeBlob(registry.MediaTypeOCIConfig, configData)
+	if err != nil {
+		return Manifest{}, dockerArchiveEntry{}, err
+	}
+	entry.Config = blobName(saved.Config.Digest)
+	manifestData, err := json.Marshal(struct {
+		SchemaVersion int `json:"schemaVersion"`
+		ImageManifest
+	}{2, saved})
+	if err != nil {
+		return Manifest{}, dockerArchiveEntry{}, err
+	}
+	desc, err := s.writeBlob(registry.MediaTypeOCIManifest, manifestData)
+	if err != nil {
+		return Manifest{}, dockerArchiveEntry{}, err
+	}
+	name := ref.Registry
+	if name == registry.DockerHub {
+		name = "docker.io"
+	}
+	name += "/" + ref.Path()
+	annotations := map[string]string{}
+	if ref.Digest == "" {
+		annotations[imageNameAnnotation] = name + ":" + ref.Tag
+		annotations[refNameAnnotation] = ref.Tag
+		entry.RepoTags = []string{ref.String()}
+	} else {
+		annotations[imageNameAnnotation] = name + "@" + ref.Digest
+	}
+	return Manifest{
+		Annotations: annotations,
+		Digest:      desc.Digest,
+		MediaType:   desc.MediaType,
+		Platform:    platform,
+		Size:        desc.Size,
+	}, entry, nil
+}
+
+// saveLayer writes the snapshot as an uncompressed layer tarball. It's
+// written to a temporary file first, since its size has to be known
+// before it can be added to the archive.
+func (s *imageSaver) saveLayer(dir string) (Layer, error) {
+	if layer, ok := s.layers[dir]; ok {
+		return layer, nil
+	}
+	tmp, err := os.CreateTemp("", "layer-")
+	if err != nil {
+		return Layer{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	h := sha256.New()
+	bw := bufio.NewWriter(io.MultiWriter(tmp, h))
+	if err := WriteTar(bw, dir, ExportOptions{Whiteouts: true}); err != nil {
+		return Layer{}, err
+	}
+	if err := bw.Flush(); err != nil {
+		return Layer{}, err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Layer{}, err
+	}
+	layer := Layer{MediaType: registry.MediaTypeOCILayer, Digest: fmt.Sprintf("sha256:%x", h.Sum(nil)), Size: int(size)}
+	if !s.blobs[layer.Digest] {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return Layer{}, err
+		}
+		if err := s.tw.WriteHeader(&tar.Header{Name: blobName(layer.Digest), Typeflag: tar.TypeReg, Mode: 0644, Size: size}); err != nil {
+			return Layer{}, err
+		}
+		if _, err := io.Copy(s.tw, tmp); err != nil {
+			return Layer{}, err
+		}
+		s.blobs[layer.Digest] = true
+	}
+	s.layers[dir] = layer
+	return layer, nil
+}
+
+// writeBlob adds the blob unless it's already in the archive.
+func (s *imageSaver) writeBlob(mediaType string, data []byte) (Layer, error) {
+	desc := Layer{MediaType: mediaType, Digest: fmt.Sprintf("sha256:%x", sha256.Sum256(data)), Size: len(data)}
+	if s.blobs[desc.Digest] {
+		return desc, nil
+	}
+	s.blobs[desc.Digest] = true
+	return desc, s.writeFile(blobName(desc.Digest), data)
+}
+
+func (s *imageSaver) writeFile(name string, data []byte) error {
+	if err := s.tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))}); err != nil {
+		return err
+	}
+	_, err := s.tw.Write(data)
+	return err
+}
+
+// blobName returns the path of a blob in an image layout.
+func blobName(digest string) string {
+	algo, hex, _ := strings.Cut(digest, ":")
+	return "blobs/" + algo + "/" + hex
+}
+
+// LoadImages adds the images in an archive written by SaveImages or docker
+// save to the local cache and returns their references. Both OCI image
+// layouts and the older docker save format without one are understood.
+func LoadImages(input string) ([]Reference, error) {
+	lock, err := LockStore(false)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+	r := os.Stdin
+	if input != "-" {
+		if r, err = os.Open(input); err != nil {
+			return nil, err
+		}
+		defer r.Close()
+	}
+	root, err := DataRoot()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	// the manifests can be anywhere in the archive, so it's unpacked
+	// before the images are read out of it
+	dir, err := os.MkdirTemp(root, ".load-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := unpackArchive(r, dir); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, "index.json")); err == nil {
+		return loadImageLayout(dir)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "manifest.json")); err == nil {
+		return loadDockerArchive(dir)
+	}
+	return nil, fmt.Errorf("not an image archive, it has neither an index.json nor a manifest.json")
+}
+
+// unpackArchive writes the files and symlinks in the archive to dir. Blobs
+// named by their digest are checked against it.
+func unpackArchive(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid image archive: %w", err)
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid image archive: bad path %q", hdr.Name)
+		}
+		// symlinks in the archive are followed when the files are read
+		// back, so parent directories are resolved as if dir was the root
+		p, err := resolveInRoot(dir, name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeSymlink:
+		default:
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		// later entries replace earlier ones, without following them if
+		// they're symlinks
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeSymlink {
+			// older docker versions link layers which are used more than once
+			rel, err := filepath.Rel(dir, filepath.Dir(p))
+			if err != nil {
+				return err
+			}
+			if filepath.IsAbs(hdr.Linkname) || !filepath.IsLocal(filepath.Join(rel, hdr.Linkname)) {
+				return fmt.Errorf("invalid image archive: %s links outside of the archive", hdr.Name)
+			}
+			if err := os.Symlink(hdr.Linkname, p); err != nil {
+				return err
+			}
+			continue
+		}
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0644)
+		if err != nil {
+			return err
+		}
+		var h hash.Hash
+		w := io.Writer(f)
+		hex, isBlob := strings.CutPrefix(filepath.ToSlash(name), "blobs/sha256/")
+		if isBlob {
+			h = sha256.New()
+			w = io.MultiWriter(f, h)
+		}
+		_, err = io.Copy(w, tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		if isBlob && fmt.Sprintf("%x", h.Sum(nil)) != hex {
+			return fmt.Errorf("invalid image archive: %s doesn't match its digest", hdr.Name)
+		}
+	}
+}
+
+// loadImageLayout loads the images named in an OCI image layout's index.
+func loadImageLayout(dir string) ([]Reference, error) {
+	var index struct {
+		Manifests []Manifest `json:"manifests"`
+	}
+	if err := readJSONFile(filepath.Join(dir, "index.json"), &index); err != nil {
+		return nil, err
+	}
+	var refs []Reference
+	for _, desc := range index.Manifests {
+		name := desc.Annotations[imageNameAnnotation]
+		// the ref name is often just the tag, which doesn't say which
+		// repository the image is from
+		if ref := desc.Annotations[refNameAnnotation]; name == "" && strings.ContainsAny(ref, "/:") {
+			name = ref
+		}
+		if name == "" {
+			log.Printf("WARNING: skipping image %s, it doesn't have a name", desc.Digest)
+			continue
+		}
+		ref, err := registry.ParseReference(name)
+		if err != nil {
+			return nil, err
+		}
+		data, err := readLayoutBlob(dir, desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		mediaType, err := registry.ManifestMediaType(data)
+		if err != nil {
+			return nil, err
+		}
+		if registry.IsIndex(mediaType) {
+			var nested struct {
+				Manifests []Manifest `json:"manifests"`
+			}
+			if err := json.Unmarshal(data, &nested); err != nil {
+				return nil, err
+			}
+			m, ok := registry.FindManifest(nested.Manifests, pullPlatform)
+			if !ok {
+				return nil, fmt.Errorf("%s: no manifest for %s", name, pullPlatform)
+			}
+			if data, err = readLayoutBlob(dir, m.Digest); err != nil {
+				return nil, err
+			}
+		}
+		blobPath := func(digest string) string {
+			return filepath.Join(dir, filepath.FromSlash(blobName(digest)))
+		}
+		if err := importImage(ref, data, blobPath); err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", name, err)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// loadDockerArchive loads the images in the manifest.json of an archive
+// written by docker save before it switched to image layouts. There are
+// no manifests in those, so one is made up from the config and layers.
+func loadDockerArchive(dir string) ([]Reference, error) {
+	var entries []dockerArchiveEntry
+	if err := readJSONFile(filepath.Join(dir, "manifest.json"), &entries); err != nil {
+		return nil, err
+	}
+	var refs []Reference
+	for _, entry := range entries {
+		if len(entry.RepoTags) == 0 {
+			log.Printf("WARNING: skipping image %s, it doesn't have a tag", entry.Config)
+			continue
+		}
+		files := map[string]string{}
+		m := ImageManifest{MediaType: registry.MediaTypeOCIManifest}
+		var err error
+		if m.Config, err = archiveDescriptor(dir, entry.Config, registry.MediaTypeDockerConfig); err != nil {
+			return nil, err
+		}
+		files[m.Config.Digest] = entry.Config
+		for _, name := range entry.Layers {
+			layer, err := archiveDescriptor(dir, name, "")
+			if err != nil {
+				return nil, err
+			}
+			m.Layers = append(m.Layers, layer)
+			files[layer.Digest] = name
+		}
+		data, err := json.Marshal(struct {
+			SchemaVersion int `json:"schemaVersion"`
+			ImageManifest
+		}{2, m})
+		if err != nil {
+			return nil, err
+		}
+		blobPath := func(digest string) string {
+			return filepath.Join(dir, filepath.FromSlash(files[digest]))
+		}
+		for _, tag := range entry.RepoTags {
+			ref, err := registry.ParseReference(tag)
+			if err != nil {
+				return nil, err
+			}
+			if err := importImage(ref, data, blobPath); err != nil {
+				return nil, fmt.Errorf("failed to load %s: %w", tag, err)
+			}
+			refs = append(refs, ref)
+		}
+	}
+	return refs, nil
+}
+
+// archiveDescriptor hashes a file in the archive. Layers get the media
+// type of their compression when mediaType is empty.
+func archiveDescriptor(dir, name, mediaType string) (Layer, error) {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return Layer{}, fmt.Errorf("invalid image archive: bad path %q", name)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return Layer{}, fmt.Errorf("invalid image archive: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return Layer{}, err
+	}
+	if mediaType == "" {
+		var magic [4]byte
+		f.ReadAt(magic[:], 0)
+		switch {
+		case bytes.HasPrefix(magic[:], []byte{0x1f, 0x8b}):
+			mediaType = registry.MediaTypeOCILayerGzip
+		case bytes.Equal(magic[:], []byte{0x28, 0xb5, 0x2f, 0xfd}):
+			mediaType = registry.MediaTypeOCILayerZstd
+		default:
+			mediaType = registry.MediaTypeOCILayer
+		}
+	}
+	return Layer{MediaType: mediaType, Digest: fmt.Sprintf("sha256:%x", h.Sum(nil)), Size: int(size)}, nil
+}
+
+// importImage extracts the layers of the image into snapshots, stores its
+// manifest and config in the blob store and points the reference at it,
+// the same as if it had been pulled.
+func importImage(ref Reference, manifestData []byte, blobPath func(digest string) string) error {
+	m, err := registry.ParseImageManifest(manifestData)
+	if err != nil {
+		return err
+	}
+	// the digests are used in paths, and nothing in the archive is used
+	// before it's checked against them
+	for _, desc := range append([]Layer{m.Config}, m.Layers...) {
+		if !registry.IsDigest(desc.Digest) {
+			return fmt.Errorf("invalid digest: %q", desc.Digest)
+		}
+	}
+	configData, err := os.ReadFile(blobPath(m.Config.Digest))
+	if err != nil {
+		return err
+	}
+	if err := registry.VerifyDigest(configData, m.Config.Digest); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	var config struct {
+		ImageConfig
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+		Variant      string `json:"variant"`
+	}
+	if err := json.Unmarshal(configData, &config); err != nil {
+		return err
+	}
+	platform := registry.HostPlatform()
+	if config.OS != "" && config.Architecture != "" {
+		s := config.OS + "/" + config.Architecture
+		if config.Variant != "" {
+			s += "/" + config.Variant
+		}
+		if platform, err = registry.ParsePlatform(s); err != nil {
+			return err
+		}
+	}
+	dirs, err := imageSnapshotDirs(m, config.ImageConfig)
+	if err != nil {
+		return err
+	}
+	for i, layer := range m.Layers {
+		if err := importSnapshot(blobPath(layer.Digest), config.RootFS.DiffIDs[i], dirs[i]); err != nil {
+			return fmt.Errorf("failed to extract layer %s: %w", layer.Digest, err)
+		}
+	}
+	manifestDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifestData))
+	if err := storeBlob(manifestDigest, manifestData); err != nil {
+		return err
+	}
+	if err := storeBlob(m.Config.Digest, configData); err != nil {
+		return err
+	}
+	return WriteImageRecord(ref, ImageRecord{Manifest: manifestDigest, PulledAt: time.Now(), Platform: platform.String()})
+}
+
+// importSnapshot extracts the layer file into the snapshot dir unless
+// it already exists. The layer has to match the diff id in the archive.
+func importSnapshot(layer, diffID, dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	lock, err := LockFile(dir+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	f, err := os.Open(layer)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return createSnapshot(dir, f, diffID)
+}
+
+// readLayoutBlob reads a blob from an image layout and checks its digest.
+func readLayoutBlob(dir, digest string) ([]byte, error) {
+	if !registry.IsDigest(digest) {
+		return nil, fmt.Errorf("invalid digest: %q", digest)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(blobName(digest))))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image archive: %w", err)
+	}
+	return data, registry.VerifyDigest(data, digest)
+}
+
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+func TestSaveLoadImages(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ref, err := registry.ParseReference("alpine:3.19")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffID := "sha256:" + strings.Repeat("1", 64)
+	config, _ := json.Marshal(map[string]any{
+		"architecture": pullPlatform.Architecture,
+		"os":           pullPlatform.OS,
+		"config":       map[string]any{"Cmd": []string{"/bin/sh"}},
+		"rootfs":       map[string]any{"type": "layers", "diff_ids": []string{diffID}},
+	})
+	configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(config))
+	manifest, _ := json.Marshal(ImageManifest{
+		Config: Layer{MediaType: registry.MediaTypeOCIConfig, Digest: configDigest},
+		Layers: []Layer{{MediaType: registry.MediaTypeOCILayerGzip, Digest: "sha256:" + strings.Repeat("2", 64)}},
+	})
+	manifestDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+	for digest, data := range map[string][]byte{configDigest: config, manifestDigest: manifest} {
+		if err := storeBlob(digest, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := WriteImageRecord(ref, ImageRecord{Manifest: manifestDigest, Platform: pullPlatform.String()}); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := SnapshotDir(diffID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "etc", "hostname"), []byte("saved"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	whiteout, err := createWhiteout(filepath.Join(dir, "etc", "motd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(t.TempDir(), "image.tar")
+	if err := SaveImages([]string{"alpine:3.19"}, archive); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("HOME", t.TempDir())
+	refs, err := LoadImages(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0] != ref {
+		t.Fatalf("unexpected images: %v", refs)
+	}
+	dirs, err := LocalImageSnapshots(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 {
+		t.Fatalf("unexpected snapshots: %v", dirs)
+	}
+	if data, err := os.ReadFile(filepath.Join(dirs[0], "etc", "hostname")); err != nil || string(data) != "saved" {
+		t.Errorf("unexpected contents: %q %v", data, err)
+	}
+	if info, err := os.Lstat(filepath.Join(dirs[0], "etc", "motd")); whiteout && (err != nil || !isWhiteout(info)) {
+		t.Errorf("expected the whiteout to be kept: %v", err)
+	}
+	loaded, err := ReadImageConfig(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Config.Cmd) != 1 || loaded.Config.Cmd[0] != "/bin/sh" {
+		t.Errorf("expected the config to be kept, got %+v", loaded.Config)
+	}
+}
+
+func TestUnpackArchiveSymlinks(t *testing.T) {
+	host := filepath.Join(t.TempDir(), "host")
+	if err := os.WriteFile(host, []byte("host file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		entries []tar.Header
+	}{
+		{"absolute link", []tar.Header{
+			{Name: "evil", Typeflag: tar.TypeSymlink, Linkname: host},
+			{Name: "evil", Typeflag: tar.TypeReg, Size: 4},
+		}},
+		{"escaping link", []tar.Header{
+			{Name: "evil", Typeflag: tar.TypeSymlink, Linkname: "../../../../../../" + host},
+		}},
+		{"escaping through a linked dir", []tar.Header{
+			{Name: "d", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "d/e/evil", Typeflag: tar.TypeSymlink, Linkname: "../.."},
+		}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, hdr := range tt.entries {
+			tw.WriteHeader(&hdr)
+			if hdr.Size > 0 {
+				tw.Write([]byte("evil"))
+			}
+		}
+		tw.Close()
+		if err := unpackArchive(&buf, t.TempDir()); err == nil {
+			t.Errorf("%s: expected the archive to be rejected", tt.name)
+		}
+		if data, _ := os.ReadFile(host); string(data) != "host file" {
+			t.Fatalf("%s: the host file was overwritten", tt.name)
+		}
+	}
+	// a regular file replaces a local symlink instead of writing through it
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "target", Typeflag: tar.TypeReg, Size: 4})
+	tw.Write([]byte("keep"))
+	tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "target"})
+	tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeReg, Size: 4})
+	tw.Write([]byte("new!"))
+	tw.Close()
+	if err := unpackArchive(&buf, dir); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "target")); string(data) != "keep" {
+		t.Errorf("the link's target was overwritten: %q", data)
+	}
+}
+
+func TestImportImageDiffID(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	layer := buildLayer(t, []*tar.Header{{Name: "file", Typeflag: tar.TypeReg, Mode: 0644}}, map[string]string{"file": "evil"}).Bytes()
+	layerDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+	// the archive claims the layer is another image's
+	diffID := "sha256:" + strings.Repeat("1", 64)
+	config, _ := json.Marshal(map[string]any{"rootfs": map[string]any{"type": "layers", "diff_ids": []string{diffID}}})
+	configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(config))
+	manifest, _ := json.Marshal(ImageManifest{
+		Config: Layer{MediaType: registry.MediaTypeOCIConfig, Digest: configDigest},
+		Layers: []Layer{{MediaType: registry.MediaTypeOCILayerGzip, Digest: layerDigest}},
+	})
+	blobs := t.TempDir()
+	for digest, data := range map[string][]byte{configDigest: config, layerDigest: layer} {
+		if err := os.WriteFile(filepath.Join(blobs, digest[7:]), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ref, _ := registry.ParseReference("evil:latest")
+	err := importImage(ref, manifest, func(digest string) string { return filepath.Join(blobs, digest[7:]) })
+	if err == nil || !strings.Contains(err.Error(), "diff id mismatch") {
+		t.Fatalf("expected a diff id mismatch, got %v", err)
+	}
+	dir, err := SnapshotDir(diffID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected no snapshot for the claimed diff id, got %v", err)
+	}
+}
+
+func TestImportImageDigests(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	config := []byte(`{"rootfs":{"type":"layers","diff_ids":[]}}`)
+	configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(config))
+	tests := []struct {
+		name     string
+		manifest ImageManifest
+		config   string
+		err      string
+	}{
+		{
+			name: "escaping layer digest",
+			manifest: ImageManifest{
+				Config: Layer{MediaType: registry.MediaTypeOCIConfig, Digest: configDigest},
+				Layers: []Layer{{MediaType: registry.MediaTypeOCILayerGzip, Digest: "sha256:../../../../etc/passwd"}},
+			},
+			config: string(config),
+			err:    "invalid digest",
+		},
+		{
+			name:     "escaping config digest",
+			manifest: ImageManifest{Config: Layer{MediaType: registry.MediaTypeOCIConfig, Digest: "sha256:../config"}},
+			config:   string(config),
+			err:      "invalid digest",
+		},
+		{
+			name:     "tampered config",
+			manifest: ImageManifest{Config: Layer{MediaType: registry.MediaTypeOCIConfig, Digest: configDigest}},
+			config:   `{"rootfs":{"type":"layers","diff_ids":["sha256:` + strings.Repeat("1", 64) + `"]}}`,
+			err:      "digest mismatch",
+		},
+	}
+	for _, tt := range tests {
+		manifest, _ := json.Marshal(tt.manifest)
+		ref, _ := registry.ParseReference("evil:latest")
+		var opened []string
+		err := importImage(ref, manifest, func(digest string) string {
+			opened = append(opened, digest)
+			p := filepath.Join(t.TempDir(), "blob")
+			os.WriteFile(p, []byte(tt.config), 0644)
+			return p
+		})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: expected %q, got %v", tt.name, tt.err, err)
+		}
+		if tt.err == "invalid digest" && len(opened) > 0 {
+			t.Errorf("%s: opened %q before checking the digests", tt.name, opened)
+		}
+	}
+}
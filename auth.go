@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+// dockerHubServer is the key docker uses for Docker Hub credentials.
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// credentialsServer returns the key the registry's credentials are stored under.
+func credentialsServer(host string) string {
+	if host == registry.DockerHub {
+		return dockerHubServer
+	}
+	return host
+}
+
+// authConfig is the credentials file, in the same format as the auths
+// section of docker's config.json so the files can be copied between them.
+type authConfig struct {
+	Auths map[string]authEntry `json:"auths"`
+}
+
+type authEntry struct {
+	// Auth is base64 encoded username:password.
+	Auth string `json:"auth"`
+	// IdentityToken is an OAuth refresh token which docker login stores
+	// instead of the password for some registries.
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// dockerConfig is the part of docker's config.json with credentials. When
+// a credential helper is configured, auths only has empty placeholders.
+type dockerConfig struct {
+	Auths       map[string]authEntry `json:"auths"`
+	CredsStore  string               `json:"credsStore"`
+	CredHelpers map[string]string    `json:"credHelpers"`
+}
+
+func authConfigPath() (string, error) {
+	root, err := DataRoot()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "auth.json"), nil
+}
+
+func readAuthConfig() (authConfig, error) {
+	config := authConfig{Auths: map[string]authEntry{}}
+	p, err := authConfigPath()
+	if err != nil {
+		return config, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("invalid %s: %w", p, err)
+	}
+	if config.Auths == nil {
+		config.Auths = map[string]authEntry{}
+	}
+	return config, nil
+}
+
+func writeAuthConfig(config authConfig) error {
+	p, err := authConfigPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(config, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// the file holds passwords, so it's only readable by the owner
+	return WriteFileAtomic(p, data, 0600)
+}
+
+// Credentials returns the stored username and password for the server.
+// Servers without a shittydocker login fall back to docker's config.json
+// and its credential helpers. The username is registry.IdentityTokenUser
+// when the password is an identity token.
+func Credentials(server string) (string, string, bool) {
+	config, err := readAuthConfig()
+	if err != nil {
+		return "", "", false
+	}
+	if entry, ok := config.Auths[server]; ok {
+		return entry.credentials()
+	}
+	return dockerCredentials(server)
+}
+
+// credentials decodes the entry's username and password.
+func (e authEntry) credentials() (string, string, bool) {
+	if e.IdentityToken != "" {
+		return registry.IdentityTokenUser, e.IdentityToken, true
+	}
+	data, err := base64.StdEncoding.DecodeString(e.Auth)
+	if err != nil {
+		return "", "", false
+	}
+	username, password, ok := strings.Cut(string(data), ":")
+	return username, password, ok
+}
+
+// dockerConfigPath returns the path of docker's config.json.
+func dockerConfigPath() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+// dockerCredentials looks up the server's credentials the same way docker
+// does: a per-registry credential helper, then the default credential store,
+// then the auths in config.json. Docker Hub and registries can be
+// written with or without a scheme, so keys are compared loosely.
+func dockerCredentials(server string) (string, string, bool) {
+	p, err := dockerConfigPath()
+	if err != nil {
+		return "", "", false
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return "", "", false
+	}
+	var config dockerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		log.Printf("WARNING: ignoring invalid %s: %v", p, err)
+		return "", "", false
+	}
+	helper := config.CredsStore
+	for key, name := range config.CredHelpers {
+		if sameServer(key, server) {
+			helper = name
+		}
+	}
+	if helper != "" {
+		return helperCredentials(helper, server)
+	}
+	for key, entry := range config.Auths {
+		if sameServer(key, server) {
+			return entry.credentials()
+		}
+	}
+	return "", "", false
+}
+
+// helperCredentials runs docker-credential-<helper> get, which reads the
+// server from stdin and writes the credentials as json.
+func helperCredentials(helper, server string) (string, string, bool) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// helpers report missing credentials on stdout and exit with an error
+		if !strings.Contains(string(out), "credentials not found") {
+			log.Printf("WARNING: docker-credential-%s: %v: %s", helper, err, strings.TrimSpace(stderr.String()+string(out)))
+		}
+		return "", "", false
+	}
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		log.Printf("WARNING: docker-credential-%s: invalid output: %v", helper, err)
+		return "", "", false
+	}
+	return creds.Username, creds.Secret, creds.Secret != ""
+}
+
+// sameServer reports whether two credential keys refer to the same registry.
+func sameServer(a, b string) bool {
+	if registry.IsDockerHub(a) || registry.IsDockerHub(b) {
+		return registry.IsDockerHub(a) && registry.IsDockerHub(b)
+	}
+	trim := func(s string) string {
+		s = strings.TrimPrefix(strings.TrimPrefix(s, "https://"), "http://")
+		host, _, _ := strings.Cut(s, "/")
+		return host
+	}
+	return trim(a) == trim(b)
+}
+
+// SaveCredentials stores the username and password for the server.
+func SaveCredentials(server, username, password string) error {
+	config, err := readAuthConfig()
+	if err != nil {
+		return err
+	}
+	config.Auths[server] = authEntry{
+		Auth: base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+	}
+	return writeAuthConfig(config)
+}
+
+// RemoveCredentials deletes the stored credentials for the server.
+// It reports whether there were any.
+func RemoveCredentials(server string) (bool, error) {
+	config, err := readAuthConfig()
+	if err != nil {
+		return false, err
+	}
+	if _, ok := config.Auths[server]; !ok {
+		return false, nil
+	}
+	delete(config.Auths, server)
+	return true, writeAuthConfig(config)
+}
+
+// VerifyCredentials checks the username and password against Docker Hub's
+// auth server, which is what docker login does.
+func VerifyCredentials(ctx context.Context, username, password string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://auth.docker.io/token?service=registry.docker.io&account="+url.QueryEscape(username), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(username, password)
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return fmt.Errorf("incorrect username or password")
+	case http.StatusTooManyRequests:
+		return registry.NewRateLimitError(res)
+	default:
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+}
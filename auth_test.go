@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+func TestCredentials(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	if _, _, ok := Credentials(dockerHubServer); ok {
+		t.Fatal("expected no credentials")
+	}
+	if err := SaveCredentials(dockerHubServer, "alice", "s3cr:et"); err != nil {
+		t.Fatal(err)
+	}
+	username, password, ok := Credentials(dockerHubServer)
+	if !ok || username != "alice" || password != "s3cr:et" {
+		t.Fatalf("unexpected credentials: %q %q %v", username, password, ok)
+	}
+	p, err := authConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("expected the credentials file to be private, got %v", perm)
+	}
+	if filepath.Base(p) != "auth.json" {
+		t.Errorf("unexpected path: %s", p)
+	}
+	removed, err := RemoveCredentials(dockerHubServer)
+	if err != nil || !removed {
+		t.Fatalf("failed to remove credentials: %v %v", removed, err)
+	}
+	if _, _, ok := Credentials(dockerHubServer); ok {
+		t.Fatal("expected credentials to be removed")
+	}
+}
+
+func TestDockerCredentials(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	config := `{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "Ym9iOmh1bnRlcjI="},
+			"https://ghcr.io": {"auth": "Ym9iOmdoY3I="},
+			"quay.io": {"identitytoken": "refresh"},
+			"example.com": {}
+		},
+		"credHelpers": {"example.com": "fake"}
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// the fake helper only knows about example.com
+	helper := "#!/bin/sh\nread server\n[ \"$server\" = example.com ] || { echo credentials not found in native keychain; exit 1; }\necho '{\"ServerURL\":\"example.com\",\"Username\":\"carol\",\"Secret\":\"helped\"}'\n"
+	if err := os.WriteFile(filepath.Join(dir, "docker-credential-fake"), []byte(helper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	tests := []struct {
+		server, username, password string
+	}{
+		{dockerHubServer, "bob", "hunter2"},
+		{"ghcr.io", "bob", "ghcr"},
+		{"quay.io", registry.IdentityTokenUser, "refresh"},
+		{"example.com", "carol", "helped"},
+	}
+	for _, tt := range tests {
+		username, password, ok := Credentials(tt.server)
+		if !ok || username != tt.username || password != tt.password {
+			t.Errorf("Credentials(%q) = %q %q %v, want %q %q", tt.server, username, password, ok, tt.username, tt.password)
+		}
+	}
+	if _, _, ok := Credentials("gcr.io"); ok {
+		t.Error("expected no credentials for gcr.io")
+	}
+	// shittydocker's own logins take precedence
+	if err := SaveCredentials("ghcr.io", "alice", "mine"); err != nil {
+		t.Fatal(err)
+	}
+	if username, _, _ := Credentials("ghcr.io"); username != "alice" {
+		t.Errorf("expected the shittydocker login to be used, got %q", username)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// TokenBucket limits throughput to Rate bytes per second, allowing
+// bursts of up to Burst bytes. It's safe for concurrent use so parallel
+// downloads share the same limit.
+type TokenBucket struct {
+	Rate  int64
+	Burst int64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full bucket with a burst size of a tenth of a
+// second's worth of data.
+func NewTokenBucket(rate int64) *TokenBucket {
+	burst := max(rate/10, 32*1024)
+	return &TokenBucket{Rate: rate, Burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// Wait blocks until n bytes may be transferred.
+func (b *TokenBucket) Wait(n int) {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*float64(b.Rate), float64(b.Burst))
+	b.last = now
+	// take the tokens now and sleep off the debt, so that concurrent
+	// callers queue up behind each other instead of all waking at once.
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / float64(b.Rate) * float64(time.Second))
+	}
+	b.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// LimitReader returns a reader which reads from r no faster than
+// the bucket allows.
+func (b *TokenBucket) LimitReader(r io.ReadCloser) io.ReadCloser {
+	return &limitedReader{ReadCloser: r, bucket: b}
+}
+
+type limitedReader struct {
+	io.ReadCloser
+	bucket *TokenBucket
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.bucket.Burst {
+		p = p[:r.bucket.Burst]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.bucket.Wait(n)
+	return n, err
+}
+
+// pullLimit throttles layer downloads when set with -pull-rate-limit.
+var pullLimit *TokenBucket
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	const rate = 1 << 20
+	b := NewTokenBucket(rate)
+	r := b.LimitReader(io.NopCloser(bytes.NewReader(make([]byte, rate/2))))
+	start := time.Now()
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != rate/2 {
+		t.Fatalf("expected %d bytes, got %d", rate/2, n)
+	}
+	// half a second of data minus the initial burst
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("reading took %s", elapsed)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// BindMount makes a host file or directory available in the container.
+type BindMount struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	ReadOnly    bool   `json:"readOnly,omitempty"`
+}
+
+// ParseBindMount parses a -v value like /srv/data:/data:ro.
+func ParseBindMount(s string) (BindMount, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return BindMount{}, fmt.Errorf("invalid volume %q: expected /host/path:/container/path[:ro]", s)
+	}
+	b := BindMount{Source: parts[0], Destination: parts[1]}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "ro":
+			b.ReadOnly = true
+		case "rw":
+		default:
+			return BindMount{}, fmt.Errorf("invalid volume %q: unknown option %q", s, parts[2])
+		}
+	}
+	if !filepath.IsAbs(b.Source) || !filepath.IsAbs(b.Destination) {
+		return BindMount{}, fmt.Errorf("invalid volume %q: paths must be absolute", s)
+	}
+	if _, err := os.Stat(b.Source); err != nil {
+		return BindMount{}, fmt.Errorf("invalid volume %q: %w", s, err)
+	}
+	b.Source = filepath.Clean(b.Source)
+	b.Destination = filepath.Clean(b.Destination)
+	return b, nil
+}
+
+// mountBind mounts b in the rootfs, creating the mount point if it's missing.
+// Symlinks in the destination are resolved inside of the rootfs, so an image
+// can't point the mount somewhere on the host.
+func mountBind(rootfs string, b BindMount) error {
+	target, err := resolveMountPoint(rootfs, b.Destination)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(b.Source)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_RDONLY, 0644)
+		if err != nil {
+			return err
+		}
+		f.Close()
+	}
+	if err := syscall.Mount(b.Source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to mount %s: %w", b.Destination, err)
+	}
+	if b.ReadOnly {
+		if err := remountReadOnly(target); err != nil {
+			return fmt.Errorf("failed to make %s read-only: %w", b.Destination, err)
+		}
+	}
+	return nil
+}
+
+// remountReadOnly makes a bind mount read-only. Bind mounts can only be made
+// read-only by remounting them, which has to keep the flags of the source
+// mount or it isn't permitted.
+func remountReadOnly(target string) error {
+	var fsInfo syscall.Statfs_t
+	if err := syscall.Statfs(target, &fsInfo); err != nil {
+		return err
+	}
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	for st, ms := range statfsMountFlags {
+		if uintptr(fsInfo.Flags)&st != 0 {
+			flags |= ms
+		}
+	}
+	return syscall.Mount("", target, "", flags, "")
+}
+
+// resolveMountPoint is resolveInRoot which also follows the last component,
+// since mounting on a symlink mounts on whatever it points to.
+func resolveMountPoint(rootfs, name string) (string, error) {
+	for hops := 0; hops <= 40; hops++ {
+		path, err := resolveInRoot(rootfs, name)
+		if err != nil {
+			return "", err
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			// not a symlink, or it doesn't exist yet
+			return path, nil
+		}
+		if !filepath.IsAbs(target) {
+			rel, _ := filepath.Rel(rootfs, filepath.Dir(path))
+			target = filepath.Join("/", rel, target)
+		}
+		name = target
+	}
+	return "", fmt.Errorf("%s: too many levels of symbolic links", name)
+}
+
+// statfsMountFlags maps the ST_ flags from statfs(2) to the mount flags.
+var statfsMountFlags = map[uintptr]uintptr{
+	0x2:    syscall.MS_NOSUID,
+	0x4:    syscall.MS_NODEV,
+	0x8:    syscall.MS_NOEXEC,
+	0x400:  syscall.MS_NOATIME,
+	0x800:  syscall.MS_NODIRATIME,
+	0x1000: syscall.MS_RELATIME,
+}
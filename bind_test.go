@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseBindMount(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		value string
+		want  BindMount
+	}{
+		{dir + ":/data", BindMount{Source: dir, Destination: "/data"}},
+		{dir + "/:/data/:ro", BindMount{Source: dir, Destination: "/data", ReadOnly: true}},
+		{dir + ":/data:rw", BindMount{Source: dir, Destination: "/data"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseBindMount(tt.value)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.value, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+	for _, value := range []string{
+		dir,
+		"data:/data",
+		dir + ":data",
+		dir + ":/data:rx",
+		filepath.Join(dir, "missing") + ":/data",
+	} {
+		if _, err := ParseBindMount(value); err == nil {
+			t.Errorf("%q: expected error", value)
+		}
+	}
+}
+
+func TestResolveMountPoint(t *testing.T) {
+	root := t.TempDir()
+	// an absolute symlink in the image has to stay inside of the rootfs
+	if err := os.Symlink("/etc", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := resolveMountPoint(root, "/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(root, "etc"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// as does a relative one which climbs past the root
+	if err := os.Mkdir(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../../outside", filepath.Join(root, "etc", "hosts")); err != nil {
+		t.Fatal(err)
+	}
+	got, err = resolveMountPoint(root, "/link/hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(root, "outside"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
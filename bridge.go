@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// The bridge network works like docker's default one. Containers get an
+// address on the bridge and reach the outside through NAT on the host.
+const bridgeName = "shitty0"
+
+var (
+	bridgeSubnet  = netip.MustParsePrefix("172.29.0.0/16")
+	bridgeGateway = netip.MustParseAddr("172.29.0.1")
+)
+
+// bridgeLeasePath returns the lock file which reserves the address.
+func bridgeLeasePath(addr netip.Addr) (string, error) {
+	root, err := DataRoot()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "networks", bridgeName, addr.String()+".lock"), nil
+}
+
+// LeaseBridgeAddress reserves an address on the bridge network for as long as
+// the lock is held. When addr isn't valid the first free one is picked. Leases
+// are locks so that the address is freed even if shittydocker crashes.
+func LeaseBridgeAddress(addr netip.Addr) (netip.Prefix, *FileLock, error) {
+	if addr.IsValid() {
+		if !bridgeSubnet.Contains(addr) || addr == bridgeGateway || addr == bridgeSubnet.Addr() {
+			return netip.Prefix{}, nil, fmt.Errorf("%s can't be used on the %s network %s", addr, bridgeName, bridgeSubnet)
+		}
+		lock, err := leaseBridgeAddress(addr)
+		if err != nil {
+			return netip.Prefix{}, nil, err
+		}
+		if lock == nil {
+			return netip.Prefix{}, nil, fmt.Errorf("%s is already in use", addr)
+		}
+		return netip.PrefixFrom(addr, bridgeSubnet.Bits()), lock, nil
+	}
+	for a := bridgeGateway.Next(); bridgeSubnet.Contains(a.Next()); a = a.Next() {
+		lock, err := leaseBridgeAddress(a)
+		if err != nil {
+			return netip.Prefix{}, nil, err
+		}
+		if lock != nil {
+			return netip.PrefixFrom(a, bridgeSubnet.Bits()), lock, nil
+		}
+	}
+	return netip.Prefix{}, nil, fmt.Errorf("no free addresses left on the %s network", bridgeName)
+}
+
+// leaseBridgeAddress returns nil if the address is taken.
+func leaseBridgeAddress(addr netip.Addr) (*FileLock, error) {
+	p, err := bridgeLeasePath(addr)
+	if err != nil {
+		return nil, err
+	}
+	lock, ok, err := TryLockFile(p)
+	if !ok {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// ensureBridge creates the bridge and sets up NAT for it unless that's
+// already been done, by an earlier container or since the last reboot.
+func ensureBridge() error {
+	p, err := bridgeLeasePath(bridgeGateway)
+	if err != nil {
+		return err
+	}
+	// the gateway's lease serializes the setup between containers
+	lock, err := LockFile(p, true)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if _, err := net.InterfaceByName(bridgeName); err != nil {
+		gateway := netip.PrefixFrom(bridgeGateway, bridgeSubnet.Bits())
+		steps := [][]string{
+			{"link", "add", bridgeName, "type", "bridge"},
+			{"addr", "add", gateway.String(), "dev", bridgeName},
+			{"link", "set", bridgeName, "up"},
+		}
+		for _, args := range steps {
+			if err := ipCommand("", args...); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0644); err != nil {
+		return fmt.Errorf("failed to enable ip forwarding: %w", err)
+	}
+	rules := [][]string{
+		{"-t", "nat", "POSTROUTING", "-s", bridgeSubnet.String(), "!", "-o", bridgeName, "-j", "MASQUERADE"},
+		// hosts with a default DROP policy for forwarding, like the ones running docker
+		{"-t", "filter", "FORWARD", "-i", bridgeName, "-j", "ACCEPT"},
+		{"-t", "filter", "FORWARD", "-o", bridgeName, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+	}
+	for _, rule := range rules {
+		if err := ensureIptablesRule(rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureIptablesRule appends the rule, given as table flag, chain and match,
+// unless the chain already has it.
+func ensureIptablesRule(rule []string) error {
+	table, chain, match := rule[:2], rule[2], rule[3:]
+	// NOTE: shelling out since netfilter isn't in the stdlib either
+	check := append(append(append([]string{}, table...), "-C", chain), match...)
+	if exec.Command("iptables", check...).Run() == nil {
+		return nil
+	}
+	add := append(append(append([]string{}, table...), "-A", chain), match...)
+	out, err := exec.Command("iptables", add...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("iptables %s: %v: %s", strings.Join(add, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// addVeth creates a veth pair with one end on the bridge
+// and the other, named peer, in the process's network namespace.
+func addVeth(link, peer string, pid int) error {
+	if err := ensureBridge(); err != nil {
+		return err
+	}
+	steps := [][]string{
+		{"link", "add", link, "type", "veth", "peer", "name", peer, "netns", fmt.Sprint(pid)},
+		{"link", "set", link, "master", bridgeName},
+		{"link", "set", link, "up"},
+	}
+	for _, args := range steps {
+		if err := ipCommand("", args...); err != nil {
+			ipCommand("", "link", "del", link)
+			return err
+		}
+	}
+	return nil
+}
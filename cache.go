@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+// DataRoot returns the directory shittydocker keeps its caches in.
+func DataRoot() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".shittydocker"), nil
+}
+
+// manifestCachePath returns the path of a cached manifest without an extension.
+// Digest references use the hex part so the path doesn't contain a colon.
+// Registries other than Docker Hub get their own directory.
+func manifestCachePath(repo Repository, reference string) (string, error) {
+	root, err := DataRoot()
+	if err != nil {
+		return "", err
+	}
+	if _, hex, ok := strings.Cut(reference, ":"); ok {
+		reference = hex
+	}
+	dir := filepath.Join(root, "manifests")
+	if repo.Registry != registry.DockerHub {
+		dir = filepath.Join(dir, repo.Registry)
+	}
+	return filepath.Join(dir, filepath.FromSlash(repo.Path()), reference), nil
+}
+
+// ReadCachedManifest returns a previously cached manifest and its ETag.
+func ReadCachedManifest(repo Repository, reference string) ([]byte, string, bool) {
+	p, err := manifestCachePath(repo, reference)
+	if err != nil {
+		return nil, "", false
+	}
+	etag, err := os.ReadFile(p + ".etag")
+	if err != nil {
+		return nil, "", false
+	}
+	data, err := os.ReadFile(p + ".json")
+	if err != nil {
+		return nil, "", false
+	}
+	return data, string(etag), true
+}
+
+// WriteCachedManifest caches a manifest along with its ETag.
+func WriteCachedManifest(repo Repository, reference, etag string, data []byte) error {
+	p, err := manifestCachePath(repo, reference)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// remove the old etag first so a partial write can't pair it with the wrong body
+	if err := os.Remove(p + ".etag"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := WriteFileAtomic(p+".json", data, 0644); err != nil {
+		return err
+	}
+	return WriteFileAtomic(p+".etag", []byte(etag), 0644)
+}
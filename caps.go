@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// capabilities are the numbers of the capabilities from linux/capability.h
+var capabilities = map[string]uint{
+	"CAP_CHOWN":              0,
+	"CAP_DAC_OVERRIDE":       1,
+	"CAP_DAC_READ_SEARCH":    2,
+	"CAP_FOWNER":             3,
+	"CAP_FSETID":             4,
+	"CAP_KILL":               5,
+	"CAP_SETGID":             6,
+	"CAP_SETUID":             7,
+	"CAP_SETPCAP":            8,
+	"CAP_LINUX_IMMUTABLE":    9,
+	"CAP_NET_BIND_SERVICE":   10,
+	"CAP_NET_BROADCAST":      11,
+	"CAP_NET_ADMIN":          12,
+	"CAP_NET_RAW":            13,
+	"CAP_IPC_LOCK":           14,
+	"CAP_IPC_OWNER":          15,
+	"CAP_SYS_MODULE":         16,
+	"CAP_SYS_RAWIO":          17,
+	"CAP_SYS_CHROOT":         18,
+	"CAP_SYS_PTRACE":         19,
+	"CAP_SYS_PACCT":          20,
+	"CAP_SYS_ADMIN":          21,
+	"CAP_SYS_BOOT":           22,
+	"CAP_SYS_NICE":           23,
+	"CAP_SYS_RESOURCE":       24,
+	"CAP_SYS_TIME":           25,
+	"CAP_SYS_TTY_CONFIG":     26,
+	"CAP_MKNOD":              27,
+	"CAP_LEASE":              28,
+	"CAP_AUDIT_WRITE":        29,
+	"CAP_AUDIT_CONTROL":      30,
+	"CAP_SETFCAP":            31,
+	"CAP_MAC_OVERRIDE":       32,
+	"CAP_MAC_ADMIN":          33,
+	"CAP_SYSLOG":             34,
+	"CAP_WAKE_ALARM":         35,
+	"CAP_BLOCK_SUSPEND":      36,
+	"CAP_AUDIT_READ":         37,
+	"CAP_PERFMON":            38,
+	"CAP_BPF":                39,
+	"CAP_CHECKPOINT_RESTORE": 40,
+}
+
+// ParseCapabilities returns the capabilities a container gets: docker's
+// default set with the -cap-add ones added and the -cap-drop ones removed.
+// Names are case insensitive, the CAP_ prefix is optional and ALL stands
+// for every capability, so -cap-drop ALL -cap-add NET_BIND_SERVICE leaves
+// only that one.
+func ParseCapabilities(add, drop []string) ([]string, error) {
+	set := map[string]bool{}
+	for _, c := range defaultCapabilities {
+		set[c] = true
+	}
+	for _, c := range drop {
+		if strings.EqualFold(c, "ALL") {
+			clear(set)
+		}
+	}
+	for _, c := range add {
+		if strings.EqualFold(c, "ALL") {
+			for name := range capabilities {
+				set[name] = true
+			}
+			continue
+		}
+		name, err := capabilityName(c)
+		if err != nil {
+			return nil, err
+		}
+		set[name] = true
+	}
+	for _, c := range drop {
+		if strings.EqualFold(c, "ALL") {
+			continue
+		}
+		name, err := capabilityName(c)
+		if err != nil {
+			return nil, err
+		}
+		delete(set, name)
+	}
+	caps := make([]string, 0, len(set))
+	for name := range set {
+		caps = append(caps, name)
+	}
+	sort.Slice(caps, func(i, j int) bool {
+		return capabilities[caps[i]] < capabilities[caps[j]]
+	})
+	return caps, nil
+}
+
+// capabilityName normalizes a capability name to the CAP_ form.
+func capabilityName(s string) (string, error) {
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "CAP_") {
+		name = "CAP_" + name
+	}
+	if _, ok := capabilities[name]; !ok {
+		return "", fmt.Errorf("unknown capability %q", s)
+	}
+	return name, nil
+}
+
+// dropBoundingSet removes every capability but caps from the bounding set,
+// which limits what the process and its children can ever gain, including
+// through setuid binaries and file capabilities.
+func dropBoundingSet(caps []string) error {
+	keep := map[uintptr]bool{}
+	for _, name := range caps {
+		keep[uintptr(capabilities[name])] = true
+	}
+	// reading past the last capability the kernel knows about fails
+	for c := uintptr(0); ; c++ {
+		in, _, errno := syscall.Syscall(syscall.SYS_PRCTL, syscall.PR_CAPBSET_READ, c, 0)
+		if errno != 0 {
+			return nil
+		}
+		if in == 0 || keep[c] {
+			continue
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_PRCTL, syscall.PR_CAPBSET_DROP, c, 0); errno != 0 {
+			return fmt.Errorf("failed to drop capability %d: %w", c, errno)
+		}
+	}
+}
+
+// linuxCapabilityVersion3 is _LINUX_CAPABILITY_VERSION_3, which uses two
+// capUserData structs for 64 capabilities.
+const linuxCapabilityVersion3 = 0x20080522
+
+type capUserHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capUserData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// setCapabilities makes caps the effective and permitted sets of the
+// process and clears the inheritable set, so nothing but the bounding
+// set is passed on through exec.
+func setCapabilities(caps []string) error {
+	var data [2]capUserData
+	for _, name := range caps {
+		c := capabilities[name]
+		data[c/32].effective |= 1 << (c % 32)
+		data[c/32].permitted |= 1 << (c % 32)
+	}
+	header := capUserHeader{version: linuxCapabilityVersion3}
+	_, _, errno := syscall.Syscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if errno != 0 {
+		return fmt.Errorf("capset: %w", errno)
+	}
+	return nil
+}
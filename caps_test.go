@@ -0,0 +1,42 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	tests := []struct {
+		add, drop []string
+		want      []string
+	}{
+		{nil, nil, defaultCapabilities},
+		{[]string{"net_admin"}, []string{"CAP_NET_RAW", "mknod"}, []string{
+			"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_FOWNER", "CAP_FSETID", "CAP_KILL", "CAP_SETGID", "CAP_SETUID",
+			"CAP_SETPCAP", "CAP_NET_BIND_SERVICE", "CAP_NET_ADMIN", "CAP_SYS_CHROOT", "CAP_AUDIT_WRITE", "CAP_SETFCAP",
+		}},
+		{[]string{"NET_BIND_SERVICE"}, []string{"ALL"}, []string{"CAP_NET_BIND_SERVICE"}},
+		{nil, []string{"all"}, []string{}},
+	}
+	for _, tt := range tests {
+		got, err := ParseCapabilities(tt.add, tt.drop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := slices.Clone(tt.want)
+		slices.SortFunc(want, func(a, b string) int { return int(capabilities[a]) - int(capabilities[b]) })
+		if !slices.Equal(got, want) {
+			t.Errorf("ParseCapabilities(%v, %v) = %v, want %v", tt.add, tt.drop, got, want)
+		}
+	}
+	all, err := ParseCapabilities([]string{"ALL"}, []string{"SYS_ADMIN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != len(capabilities)-1 || slices.Contains(all, "CAP_SYS_ADMIN") {
+		t.Errorf("expected every capability but CAP_SYS_ADMIN, got %v", all)
+	}
+	if _, err := ParseCapabilities([]string{"CAP_BOGUS"}, nil); err == nil {
+		t.Error("expected unknown capabilities to be rejected")
+	}
+}
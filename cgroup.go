@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// defaultCgroupParent is used when -cgroup-parent isn't specified.
+const defaultCgroupParent = "/shittydocker"
+
+// CgroupsAvailable reports whether the unified cgroup v2 hierarchy is mounted.
+func CgroupsAvailable() bool {
+	_, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers"))
+	return err == nil
+}
+
+// CgroupPath returns the container's cgroup path relative to the cgroup root.
+// A parent ending in .slice is expanded the way systemd does it, so
+// "machine-web.slice" is placed at "/machine.slice/machine-web.slice".
+func CgroupPath(parent, id string) (string, error) {
+	if parent == "" {
+		parent = defaultCgroupParent
+	}
+	if strings.HasSuffix(parent, ".slice") && !strings.Contains(parent, "/") {
+		expanded, err := expandSlice(parent)
+		if err != nil {
+			return "", err
+		}
+		parent = expanded
+	}
+	return path.Join("/", parent, id), nil
+}
+
+func expandSlice(slice string) (string, error) {
+	name := strings.TrimSuffix(slice, ".slice")
+	if name == "-" {
+		return "/", nil
+	}
+	if name == "" || strings.HasPrefix(name, "-") || strings.HasSuffix(name, "-") || strings.Contains(name, "--") {
+		return "", fmt.Errorf("invalid slice name: %q", slice)
+	}
+	var p, prefix string
+	for _, part := range strings.Split(name, "-") {
+		prefix += part
+		p += "/" + prefix + ".slice"
+		prefix += "-"
+	}
+	return p, nil
+}
+
+// Cgroup is a cgroup v2 directory.
+type Cgroup struct {
+	Path string
+}
+
+// CreateCgroup creates the cgroup at the path relative to the cgroup root
+// and enables the controllers on each of its ancestors.
+func CreateCgroup(p string, controllers []string) (*Cgroup, error) {
+	dir := filepath.Join(cgroupRoot, p)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	if len(controllers) > 0 {
+		var enable string
+		for _, c := range controllers {
+			enable += " +" + c
+		}
+		// every cgroup from the root down to the parent has to
+		// delegate the controllers to its children.
+		ancestor := cgroupRoot
+		for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+			subtree := filepath.Join(ancestor, "cgroup.subtree_control")
+			if err := os.WriteFile(subtree, []byte(strings.TrimSpace(enable)), 0644); err != nil {
+				return nil, fmt.Errorf("failed to enable controllers in %s: %w", ancestor, err)
+			}
+			ancestor = filepath.Join(ancestor, name)
+		}
+	}
+	return &Cgroup{Path: dir}, nil
+}
+
+// CgroupResources are the limits applied to a container's cgroup.
+// Zero values are left unset.
+type CgroupResources struct {
+	CPUShares uint64
+	// CPUQuota is the cpu time in microseconds the container
+	// can use in each CPUPeriod, across all of the cpus.
+	CPUQuota  int64
+	CPUPeriod uint64
+	// Memory is the hard limit, the container is OOM killed above it.
+	Memory int64
+	// MemoryReservation is a soft limit which is only enforced
+	// when the host is under memory pressure.
+	MemoryReservation int64
+	// MemorySwappiness [0-100] is a pointer because zero is meaningful.
+	MemorySwappiness *uint64
+	// PidsLimit is the maximum number of processes in the container.
+	PidsLimit int64
+}
+
+// cpuPeriod is the scheduling period used for -cpus, in microseconds.
+const cpuPeriod = 100000
+
+// ParseCPUs converts a number of cpus like 1.5 into a cpu quota
+// for the default period.
+func ParseCPUs(s string) (quota int64, period uint64, err error) {
+	cpus, err := strconv.ParseFloat(s, 64)
+	if err != nil || cpus <= 0 {
+		return 0, 0, fmt.Errorf("invalid number of cpus: %q", s)
+	}
+	quota = int64(math.Round(cpus * cpuPeriod))
+	// the kernel doesn't accept quotas below 1ms
+	if quota < 1000 {
+		return 0, 0, fmt.Errorf("invalid number of cpus: %q is below the minimum of 0.01", s)
+	}
+	return quota, cpuPeriod, nil
+}
+
+// Controllers returns the controllers needed to apply the resources.
+func (r CgroupResources) Controllers() []string {
+	var controllers []string
+	if r.CPUShares != 0 || r.CPUQuota != 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if r.Memory != 0 || r.MemoryReservation != 0 || r.MemorySwappiness != nil {
+		controllers = append(controllers, "memory")
+	}
+	if r.PidsLimit != 0 {
+		controllers = append(controllers, "pids")
+	}
+	return controllers
+}
+
+// Apply writes the resources to the cgroup's interface files.
+func (cg *Cgroup) Apply(r CgroupResources) error {
+	if r.CPUShares != 0 {
+		if err := cg.write("cpu.weight", fmt.Sprint(CPUSharesToWeight(r.CPUShares))); err != nil {
+			return err
+		}
+	}
+	if r.CPUQuota != 0 {
+		if err := cg.write("cpu.max", fmt.Sprintf("%d %d", r.CPUQuota, r.CPUPeriod)); err != nil {
+			return err
+		}
+	}
+	if r.Memory != 0 {
+		if err := cg.write("memory.max", fmt.Sprint(r.Memory)); err != nil {
+			return err
+		}
+	}
+	if r.PidsLimit != 0 {
+		if err := cg.write("pids.max", fmt.Sprint(r.PidsLimit)); err != nil {
+			return err
+		}
+	}
+	if r.MemoryReservation != 0 {
+		if err := cg.write("memory.low", fmt.Sprint(r.MemoryReservation)); err != nil {
+			return err
+		}
+	}
+	// cgroup v2 has no per-cgroup swappiness. The closest thing is
+	// preventing the container from using swap at all.
+	if r.MemorySwappiness != nil {
+		if *r.MemorySwappiness != 0 {
+			log.Printf("WARNING: cgroup v2 does not support memory swappiness, discarding -memory-swappiness=%d", *r.MemorySwappiness)
+		} else if err := cg.write("memory.swap.max", "0"); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			log.Printf("WARNING: swap accounting is not enabled, discarding -memory-swappiness=0")
+		}
+	}
+	return nil
+}
+
+func (cg *Cgroup) write(file, value string) error {
+	if err := os.WriteFile(filepath.Join(cg.Path, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to set %s: %w", file, err)
+	}
+	return nil
+}
+
+// CPUSharesToWeight converts cgroup v1 cpu shares [2-262144] to a
+// cgroup v2 cpu weight [1-10000] using the same formula as runc.
+func CPUSharesToWeight(shares uint64) uint64 {
+	shares = min(max(shares, 2), 262144)
+	return 1 + ((shares-2)*9999)/262142
+}
+
+// OOMKilled reports whether the OOM killer killed any process in the cgroup.
+// It's always false when the memory controller isn't enabled.
+func (cg *Cgroup) OOMKilled() bool {
+	data, err := os.ReadFile(filepath.Join(cg.Path, "memory.events"))
+	if err != nil {
+		return false
+	}
+	return parseOOMKills(string(data)) > 0
+}
+
+// parseOOMKills returns the oom_kill count from memory.events.
+func parseOOMKills(events string) int {
+	for _, line := range strings.Split(events, "\n") {
+		if v, ok := strings.CutPrefix(line, "oom_kill "); ok {
+			n, _ := strconv.Atoi(strings.TrimSpace(v))
+			return n
+		}
+	}
+	return 0
+}
+
+// Open returns the cgroup directory for use with SysProcAttr.CgroupFD.
+func (cg *Cgroup) Open() (*os.File, error) {
+	return os.Open(cg.Path)
+}
+
+// Remove deletes the cgroup. It must not contain any processes.
+func (cg *Cgroup) Remove() error {
+	err := os.Remove(cg.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import "testing"
+
+func TestCgroupPath(t *testing.T) {
+	tests := []struct {
+		parent string
+		want   string
+	}{
+		{"", "/shittydocker/abc"},
+		{"/custom", "/custom/abc"},
+		{"custom/nested", "/custom/nested/abc"},
+		{"-.slice", "/abc"},
+		{"machine.slice", "/machine.slice/abc"},
+		{"machine-web.slice", "/machine.slice/machine-web.slice/abc"},
+		{"a-b-c.slice", "/a.slice/a-b.slice/a-b-c.slice/abc"},
+	}
+	for _, tt := range tests {
+		got, err := CgroupPath(tt.parent, "abc")
+		if err != nil {
+			t.Fatalf("%q: %v", tt.parent, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.parent, got, tt.want)
+		}
+	}
+	for _, parent := range []string{"-a.slice", "a-.slice", "a--b.slice"} {
+		if _, err := CgroupPath(parent, "abc"); err == nil {
+			t.Errorf("%q: expected error", parent)
+		}
+	}
+}
+
+func TestCPUSharesToWeight(t *testing.T) {
+	tests := []struct {
+		shares, weight uint64
+	}{
+		{2, 1},
+		{1024, 39},
+		{262144, 10000},
+		{1, 1},
+		{1 << 20, 10000},
+	}
+	for _, tt := range tests {
+		if got := CPUSharesToWeight(tt.shares); got != tt.weight {
+			t.Errorf("%d: got %d, want %d", tt.shares, got, tt.weight)
+		}
+	}
+}
+
+func TestParseOOMKills(t *testing.T) {
+	events := "low 0\nhigh 0\nmax 12\noom 3\noom_kill 2\noom_group_kill 0\n"
+	if n := parseOOMKills(events); n != 2 {
+		t.Errorf("expected 2 oom kills, got %d", n)
+	}
+	if n := parseOOMKills("low 0\n"); n != 0 {
+		t.Errorf("expected no oom kills, got %d", n)
+	}
+}
+
+func TestParseCPUs(t *testing.T) {
+	tests := []struct {
+		cpus  string
+		quota int64
+	}{
+		{"1", 100000},
+		{"1.5", 150000},
+		{"0.25", 25000},
+		{"0.01", 1000},
+	}
+	for _, tt := range tests {
+		quota, period, err := ParseCPUs(tt.cpus)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.cpus, err)
+		}
+		if quota != tt.quota || period != cpuPeriod {
+			t.Errorf("%q: got %d %d, want %d %d", tt.cpus, quota, period, tt.quota, cpuPeriod)
+		}
+	}
+	for _, cpus := range []string{"", "0", "-1", "0.001", "two"} {
+		if _, _, err := ParseCPUs(cpus); err == nil {
+			t.Errorf("%q: expected error", cpus)
+		}
+	}
+}
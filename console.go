@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The console protocol is what attach sends to the console socket. Each
+// message is a type byte and a big endian uint16 length before the payload.
+// The console only replies with the raw output of the pty.
+const (
+	// consoleInput is typed into the container's terminal.
+	consoleInput = 'i'
+	// consoleResize carries the rows and columns of the attached terminal.
+	consoleResize = 'r'
+)
+
+// detachKeys are ctrl-p ctrl-q, which end attach without the container
+// seeing them, like docker.
+var detachKeys = []byte{0x10, 0x11}
+
+// ContainerConsolePath returns the path of the console socket in a
+// container's directory.
+func ContainerConsolePath(dir string) string {
+	return filepath.Join(dir, "console.sock")
+}
+
+// Console holds the pty of a container run with -d and -t, so the terminal
+// outlives the foreground process. The output goes to the container's logs,
+// and to the clients attached through the console socket.
+type Console struct {
+	master, slave *os.File
+	interactive   bool
+	path          string
+	ln            *net.UnixListener
+	logs          *io.PipeWriter
+	mu            sync.Mutex
+	clients       map[*consoleClient]bool
+	closed        bool
+	writers       sync.WaitGroup
+	done          chan struct{}
+}
+
+// consoleFlushTimeout is how long Close waits for the clients to get the
+// rest of the output.
+const consoleFlushTimeout = time.Second
+
+// consoleBacklog is how many reads of output an attached client can fall
+// behind by before it's disconnected, so a stalled client can't stop the pty
+// from being drained.
+const consoleBacklog = 256
+
+// consoleClient is a client attached to the console. Its output is written
+// by a goroutine of its own, from out.
+type consoleClient struct {
+	conn net.Conn
+	out  chan []byte
+}
+
+// writeOutput writes the client's output until out is closed.
+func (cl *consoleClient) writeOutput() {
+	defer cl.conn.Close()
+	for p := range cl.out {
+		if _, err := cl.conn.Write(p); err != nil {
+			break
+		}
+	}
+	// the client is gone, but the console still has to be able to close out
+	for range cl.out {
+	}
+}
+
+// OpenConsole makes a new pty the controlling terminal and stdio of cmd and
+// listens on the console socket at path. Input from attached clients is only
+// passed on when interactive is set. Start has to be called once the command
+// is running, and Close after it exits.
+func OpenConsole(cmd *exec.Cmd, interactive bool, path string) (*Console, error) {
+	master, slave, err := attachPty(cmd)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := listenUnix(path)
+	if err != nil {
+		master.Close()
+		slave.Close()
+		return nil, err
+	}
+	return &Console{
+		master:      master,
+		slave:       slave,
+		interactive: interactive,
+		path:        path,
+		ln:          ln,
+		clients:     map[*consoleClient]bool{},
+		done:        make(chan struct{}),
+	}, nil
+}
+
+// Start relays the output to logs and accepts clients on the console socket.
+// The pty's output is logged as stdout, because it can't be told apart.
+func (c *Console) Start(logs *LogWriter, logsDone *sync.WaitGroup) {
+	r, w := io.Pipe()
+	c.logs = w
+	logsDone.Add(1)
+	go func() {
+		defer logsDone.Done()
+		if err := logs.Copy("stdout", r); err != nil {
+			log.Printf("failed to write logs: %v", err)
+			r.CloseWithError(err)
+		}
+	}()
+	go func() {
+		defer close(c.done)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := c.master.Read(buf)
+			if n > 0 {
+				c.write(buf[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := c.ln.Accept()
+			if err != nil {
+				return
+			}
+			cl := &consoleClient{conn: conn, out: make(chan []byte, consoleBacklog)}
+			c.mu.Lock()
+			if c.closed {
+				c.mu.Unlock()
+				conn.Close()
+				return
+			}
+			c.clients[cl] = true
+			c.writers.Add(1)
+			c.mu.Unlock()
+			go func() {
+				defer c.writers.Done()
+				cl.writeOutput()
+			}()
+			go c.serve(cl)
+		}
+	}()
+}
+
+// write passes output on to the logs and queues it for every client,
+// disconnecting the clients which fell too far behind.
+func (c *Console) write(p []byte) {
+	c.logs.Write(p)
+	p = append([]byte(nil), p...)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for cl := range c.clients {
+		select {
+		case cl.out <- p:
+		default:
+			c.drop(cl)
+			cl.conn.Close()
+		}
+	}
+}
+
+// drop stops sending output to the client, its writer flushes what's
+// queued and closes the connection. It's called with c.mu held.
+func (c *Console) drop(cl *consoleClient) {
+	if c.clients[cl] {
+		delete(c.clients, cl)
+		close(cl.out)
+	}
+}
+
+// serve reads the messages of a client until it goes away.
+func (c *Console) serve(cl *consoleClient) {
+	defer func() {
+		c.mu.Lock()
+		c.drop(cl)
+		c.mu.Unlock()
+	}()
+	br := bufio.NewReader(cl.conn)
+	for {
+		kind, payload, err := readConsoleMessage(br)
+		if err != nil {
+			return
+		}
+		switch kind {
+		case consoleInput:
+			if c.interactive {
+				c.master.Write(payload)
+			}
+		case consoleResize:
+			if len(payload) == 4 {
+				SetWinsize(c.master, Winsize{
+					Rows: binary.BigEndian.Uint16(payload),
+					Cols: binary.BigEndian.Uint16(payload[2:]),
+				})
+			}
+		}
+	}
+}
+
+// Close waits for the remaining output, disconnects the clients and
+// removes the console socket.
+func (c *Console) Close() {
+	// like Terminal.Close, the copy ends once the container's gone
+	c.slave.Close()
+	c.ln.Close()
+	os.Remove(c.path)
+	if c.logs != nil {
+		<-c.done
+		c.logs.Close()
+	}
+	c.master.Close()
+	c.mu.Lock()
+	c.closed = true
+	for cl := range c.clients {
+		c.drop(cl)
+	}
+	c.mu.Unlock()
+	flushed := make(chan struct{})
+	go func() {
+		c.writers.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-time.After(consoleFlushTimeout):
+	}
+}
+
+// listenUnix listens on a unix socket. Socket paths are limited to 108
+// bytes, so longer ones are bound through a descriptor of their directory.
+func listenUnix(path string) (*net.UnixListener, error) {
+	var ln *net.UnixListener
+	err := withUnixAddr(path, func(addr *net.UnixAddr) error {
+		var err error
+		ln, err = net.ListenUnix("unix", addr)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the name might only have been valid while binding
+	ln.SetUnlinkOnClose(false)
+	return ln, nil
+}
+
+// dialUnix connects to a unix socket the same way listenUnix binds it.
+func dialUnix(path string) (*net.UnixConn, error) {
+	var conn *net.UnixConn
+	err := withUnixAddr(path, func(addr *net.UnixAddr) error {
+		var err error
+		conn, err = net.DialUnix("unix", nil, addr)
+		return err
+	})
+	return conn, err
+}
+
+func withUnixAddr(path string, f func(*net.UnixAddr) error) error {
+	if len(path) < len(syscall.RawSockaddrUnix{}.Path) {
+		return f(&net.UnixAddr{Name: path, Net: "unix"})
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	name := fmt.Sprintf("/proc/self/fd/%d/%s", dir.Fd(), filepath.Base(path))
+	return f(&net.UnixAddr{Name: name, Net: "unix"})
+}
+
+func readConsoleMessage(r io.Reader) (byte, []byte, error) {
+	var header [3]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[1:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+func writeConsoleMessage(w io.Writer, kind byte, payload []byte) error {
+	for {
+		n := min(len(payload), 0xffff)
+		msg := make([]byte, 3+n)
+		msg[0] = kind
+		binary.BigEndian.PutUint16(msg[1:], uint16(n))
+		copy(msg[3:], payload[:n])
+		if _, err := w.Write(msg); err != nil {
+			return err
+		}
+		if payload = payload[n:]; len(payload) == 0 {
+			return nil
+		}
+	}
+}
+
+// AttachConsole connects the local terminal to the console socket until the
+// container exits or the detach keys are typed, and reports which it was.
+// Stdin is only sent when interactive is set.
+func AttachConsole(path string, interactive bool) (detached bool, err error) {
+	conn, err := dialUnix(path)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	// writes come from the stdin and resize goroutines
+	var mu sync.Mutex
+	send := func(kind byte, payload []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return writeConsoleMessage(conn, kind, payload)
+	}
+	resize := func() {
+		if ws, err := GetWinsize(os.Stdin); err == nil {
+			payload := make([]byte, 4)
+			binary.BigEndian.PutUint16(payload, ws.Rows)
+			binary.BigEndian.PutUint16(payload[2:], ws.Cols)
+			send(consoleResize, payload)
+		}
+	}
+	resize()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGWINCH)
+	defer signal.Stop(sigs)
+	go func() {
+		for range sigs {
+			resize()
+		}
+	}()
+	if interactive && isTerminal(os.Stdin) {
+		if state, err := MakeRaw(os.Stdin); err == nil {
+			defer RestoreTerminal(os.Stdin, state)
+		}
+	}
+	detach := make(chan struct{})
+	if interactive {
+		go func() {
+			if copyConsoleInput(os.Stdin, send) {
+				close(detach)
+			}
+		}()
+	}
+	output := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(os.Stdout, conn)
+		output <- err
+	}()
+	select {
+	case <-detach:
+		return true, nil
+	case err := <-output:
+		return false, err
+	}
+}
+
+// copyConsoleInput sends stdin to the console until it's closed or the
+// detach keys are typed, which it reports. Input which could be the start of
+// the detach keys is held back until the next read shows it isn't.
+func copyConsoleInput(r io.Reader, send func(byte, []byte) error) bool {
+	buf := make([]byte, 4096)
+	var held int
+	for {
+		n, err := r.Read(buf[held:])
+		n += held
+		held = 0
+		var out []byte
+		for i := 0; i < n; i++ {
+			if buf[i] != detachKeys[0] {
+				out = append(out, buf[i])
+				continue
+			}
+			if i+1 == n {
+				held = 1
+				break
+			}
+			if buf[i+1] == detachKeys[1] {
+				return true
+			}
+			out = append(out, buf[i])
+		}
+		if err != nil && held > 0 {
+			// nothing's coming after it
+			out = append(out, detachKeys[0])
+			held = 0
+		}
+		if len(out) > 0 {
+			if send(consoleInput, out) != nil {
+				return false
+			}
+		}
+		if held > 0 {
+			buf[0] = detachKeys[0]
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
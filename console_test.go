@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestConsoleMessages(t *testing.T) {
+	var b bytes.Buffer
+	big := bytes.Repeat([]byte("x"), 0x10000)
+	if err := writeConsoleMessage(&b, consoleInput, big); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeConsoleMessage(&b, consoleResize, []byte{0, 24, 0, 80}); err != nil {
+		t.Fatal(err)
+	}
+	// the big input is split in two messages
+	var input []byte
+	for i := 0; i < 2; i++ {
+		kind, payload, err := readConsoleMessage(&b)
+		if err != nil || kind != consoleInput {
+			t.Fatalf("unexpected message: %c, %v", kind, err)
+		}
+		input = append(input, payload...)
+	}
+	if !bytes.Equal(input, big) {
+		t.Errorf("got %d bytes of input, want %d", len(input), len(big))
+	}
+	kind, payload, err := readConsoleMessage(&b)
+	if err != nil || kind != consoleResize || !bytes.Equal(payload, []byte{0, 24, 0, 80}) {
+		t.Errorf("unexpected message: %c %v, %v", kind, payload, err)
+	}
+	if _, _, err := readConsoleMessage(&b); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestCopyConsoleInput(t *testing.T) {
+	tests := []struct {
+		input    string
+		sent     string
+		detached bool
+	}{
+		{"ls\r", "ls\r", false},
+		{"ls\x10\x11exit\r", "ls", true},
+		// ctrl-p on its own is passed on
+		{"a\x10b\x10", "a\x10b\x10", false},
+	}
+	for _, tt := range tests {
+		var sent []byte
+		send := func(kind byte, payload []byte) error {
+			sent = append(sent, payload...)
+			return nil
+		}
+		// one byte at a time splits the detach keys across reads
+		detached := copyConsoleInput(iotest.OneByteReader(bytes.NewReader([]byte(tt.input))), send)
+		if detached != tt.detached || string(sent) != tt.sent {
+			t.Errorf("input %q: sent %q, detached %v", tt.input, sent, detached)
+		}
+	}
+}
+
+func TestConsoleStalledClient(t *testing.T) {
+	r, w := io.Pipe()
+	go io.Copy(io.Discard, r)
+	c := &Console{logs: w, clients: map[*consoleClient]bool{}}
+	// nothing ever reads from the client's end
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	cl := &consoleClient{conn: conn, out: make(chan []byte, consoleBacklog)}
+	c.clients[cl] = true
+	go cl.writeOutput()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i <= consoleBacklog+1; i++ {
+			c.write([]byte("output"))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the output was held up by a stalled client")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.clients[cl] {
+		t.Error("expected the stalled client to be disconnected")
+	}
+}
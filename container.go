@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Container is the record of a container written to its bundle directory.
+type Container struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name,omitempty"`
+	Image       string            `json:"image"`
+	Command     []string          `json:"command,omitempty"`
+	Created     time.Time         `json:"created"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// ImageDigest is the digest of the image's manifest.
+	ImageDigest string `json:"image_digest,omitempty"`
+	// Layers are the snapshot directories of the rootfs, lowest first.
+	Layers []string          `json:"layers"`
+	Config ContainerSettings `json:"config"`
+	Mounts []ContainerMount  `json:"mounts,omitempty"`
+	// Network is nil for containers which use the host's network.
+	Network *NetworkConfig `json:"network,omitempty"`
+	// State is set once the container has started.
+	State *ContainerState `json:"state,omitempty"`
+}
+
+// ContainerSettings are what the container's process was started with.
+type ContainerSettings struct {
+	Env          []string `json:"env,omitempty"`
+	WorkingDir   string   `json:"working_dir"`
+	User         string   `json:"user,omitempty"`
+	Hostname     string   `json:"hostname,omitempty"`
+	Runtime      string   `json:"runtime"`
+	Isolation    string   `json:"isolation"`
+	Tty          bool     `json:"tty"`
+	Interactive  bool     `json:"interactive"`
+	ReadOnly     bool     `json:"read_only"`
+	Init         bool     `json:"init"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Umask and NoNewPrivileges are kept for exec, along with the
+	// seccomp filter in ContainerSeccompPath.
+	Umask           uint32 `json:"umask"`
+	NoNewPrivileges bool   `json:"no_new_privileges"`
+}
+
+// ContainerMount is a bind or tmpfs mount in the container.
+type ContainerMount struct {
+	// Type is bind or tmpfs.
+	Type string `json:"type"`
+	// Source is the host path of bind mounts.
+	Source      string   `json:"source,omitempty"`
+	Destination string   `json:"destination"`
+	ReadOnly    bool     `json:"read_only"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// ContainerMounts lists the bind and tmpfs mounts in the order they're mounted.
+func ContainerMounts(binds []BindMount, tmpfs []TmpfsMount) []ContainerMount {
+	var mounts []ContainerMount
+	for _, b := range binds {
+		mounts = append(mounts, ContainerMount{Type: "bind", Source: b.Source, Destination: b.Destination, ReadOnly: b.ReadOnly})
+	}
+	for _, t := range tmpfs {
+		flags, _ := t.mountOptions()
+		mounts = append(mounts, ContainerMount{Type: "tmpfs", Destination: t.Destination, ReadOnly: flags&syscall.MS_RDONLY != 0, Options: t.Options})
+	}
+	return mounts
+}
+
+// ContainerState records the container's process, and how it ended
+// once FinishedAt is set.
+type ContainerState struct {
+	// Pid is the host pid of the container's init process.
+	Pid int `json:"pid,omitempty"`
+	// StartTime is when the process started, see ProcessStartTime. It's
+	// what tells the process apart from a later one which got the same pid.
+	StartTime  uint64    `json:"start_time,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// ExitCode is 128+n when the process was killed by signal n, like docker.
+	ExitCode int `json:"exit_code"`
+	// Signal is the signal which killed the process, if any.
+	Signal    syscall.Signal `json:"signal,omitempty"`
+	OOMKilled bool           `json:"oom_killed"`
+}
+
+// Running reports whether the container's process is still alive. Containers
+// whose shittydocker process was killed without recording the exit count as
+// stopped once their pid is gone, or belongs to another process, like after
+// a reboot.
+func (c Container) Running() bool {
+	if c.State == nil || !c.State.FinishedAt.IsZero() || c.State.Pid == 0 {
+		return false
+	}
+	return isProcess(c.State.Pid, c.State.StartTime)
+}
+
+// Status describes the state of the container the way docker ps does.
+func (c Container) Status(now time.Time) string {
+	switch {
+	case c.State == nil:
+		return "Created"
+	case c.Running():
+		return "Up " + HumanDuration(now.Sub(c.State.StartedAt))
+	case c.State.FinishedAt.IsZero():
+		return "Exited (unknown)"
+	default:
+		return fmt.Sprintf("Exited (%d) %s ago", c.State.ExitCode, HumanDuration(now.Sub(c.State.FinishedAt)))
+	}
+}
+
+// ExitStatus returns the shell style exit code of a process and the
+// signal which killed it.
+func ExitStatus(ws syscall.WaitStatus) (int, syscall.Signal) {
+	if ws.Signaled() {
+		return 128 + int(ws.Signal()), ws.Signal()
+	}
+	return ws.ExitStatus(), 0
+}
+
+// ContainersDir returns the directory containing all the container directories.
+func ContainersDir() (string, error) {
+	root, err := DataRoot()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "containers"), nil
+}
+
+// ListContainers reads the records of all containers.
+func ListContainers() ([]Container, error) {
+	dir, err := ContainersDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var containers []Container
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name(), "container.json"))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var c Container
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		containers = append(containers, c)
+	}
+	return containers, nil
+}
+
+// errNoSuchContainer is returned by FindContainer when nothing matches.
+var errNoSuchContainer = errors.New("no such container")
+
+// FindContainer returns the container with the id or name, or an
+// unambiguous prefix of the id, along with its directory.
+func FindContainer(id string) (Container, string, error) {
+	containers, err := ListContainers()
+	if err != nil {
+		return Container{}, "", err
+	}
+	var matches []Container
+	for _, c := range containers {
+		if c.ID == id || c.Name == id && id != "" {
+			matches = []Container{c}
+			break
+		}
+		if id != "" && strings.HasPrefix(c.ID, id) {
+			matches = append(matches, c)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return Container{}, "", fmt.Errorf("%w: %s", errNoSuchContainer, id)
+	case 1:
+		dir, err := ContainersDir()
+		if err != nil {
+			return Container{}, "", err
+		}
+		return matches[0], filepath.Join(dir, matches[0].ID), nil
+	default:
+		return Container{}, "", fmt.Errorf("container id %s is ambiguous", id)
+	}
+}
+
+// WriteContainer writes the container record to dir/container.json
+func WriteContainer(dir string, c Container) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(dir, "container.json"), data, 0644)
+}
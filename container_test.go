@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		ws     syscall.WaitStatus
+		code   int
+		signal syscall.Signal
+	}{
+		{0, 0, 0},
+		{3 << 8, 3, 0},
+		{syscall.WaitStatus(syscall.SIGKILL), 137, syscall.SIGKILL},
+		{syscall.WaitStatus(syscall.SIGTERM), 143, syscall.SIGTERM},
+	}
+	for _, tt := range tests {
+		code, signal := ExitStatus(tt.ws)
+		if code != tt.code || signal != tt.signal {
+			t.Errorf("ExitStatus(%#x) = %d, %v, want %d, %v", uint32(tt.ws), code, signal, tt.code, tt.signal)
+		}
+	}
+}
+
+func TestFindContainer(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir, err := ContainersDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"abc123", "abd456"} {
+		if err := os.MkdirAll(filepath.Join(dir, id), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteContainer(filepath.Join(dir, id), Container{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, p, err := FindContainer("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != "abc123" || p != filepath.Join(dir, "abc123") {
+		t.Errorf("unexpected container: %s %s", c.ID, p)
+	}
+	if _, _, err := FindContainer("ab"); err == nil {
+		t.Error("expected an ambiguous prefix to fail")
+	}
+	if _, _, err := FindContainer("xyz"); err == nil {
+		t.Error("expected a missing container to fail")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// detachedEnv is set in the background process started for -d.
+const detachedEnv = "_SHITTYDOCKER_DETACHED"
+
+// Detached reports whether this is the background process of a -d run.
+func Detached() bool {
+	return os.Getenv(detachedEnv) != ""
+}
+
+// Detach re-executes shittydocker in a new session to run the container in
+// the background. The output of the setup is passed through until the
+// container has started, then its id is printed and the process exits.
+// Failures before that exit with the background process's exit code.
+func Detach() {
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+	output, outputW, err := os.Pipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+	env := []string{detachedEnv + "=1"}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, detachedEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	cmd := &exec.Cmd{
+		Path:        "/proc/self/exe",
+		Args:        os.Args,
+		Env:         env,
+		Stdout:      outputW,
+		Stderr:      outputW,
+		ExtraFiles:  []*os.File{readyW},
+		SysProcAttr: &syscall.SysProcAttr{Setsid: true},
+	}
+	if err := cmd.Start(); err != nil {
+		log.Fatal(err)
+	}
+	readyW.Close()
+	outputW.Close()
+	// the output reaches EOF once the background process lets go of it
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(os.Stderr, output)
+		close(copied)
+	}()
+	id, _ := io.ReadAll(ready)
+	<-copied
+	if len(id) == 0 {
+		if err := cmd.Wait(); err != nil && cmd.ProcessState == nil {
+			log.Fatal(err)
+		}
+		code, _ := ExitStatus(cmd.ProcessState.Sys().(syscall.WaitStatus))
+		if code == 0 {
+			code = 1
+		}
+		os.Exit(code)
+	}
+	os.Stdout.Write(id)
+	os.Exit(0)
+}
+
+// openDetachedReady takes over the pipe Detach waits on. It's made close on
+// exec so it doesn't leak into the container.
+func openDetachedReady() *os.File {
+	syscall.CloseOnExec(syncFD)
+	return os.NewFile(syncFD, "ready")
+}
+
+// signalDetached tells the foreground process that the container started
+// and stops writing to its output, which goes away when it exits.
+func signalDetached(ready *os.File, id string) {
+	ready.WriteString(id + "\n")
+	ready.Close()
+	devnull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return
+	}
+	defer devnull.Close()
+	for _, fd := range []int{0, 1, 2} {
+		syscall.Dup3(int(devnull.Fd()), fd, 0)
+	}
+}
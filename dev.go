@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// devices are the nodes created in the container's /dev, the same set
+// docker gives containers by default.
+var devices = []struct {
+	name         string
+	major, minor uint32
+}{
+	{"null", 1, 3},
+	{"zero", 1, 5},
+	{"full", 1, 7},
+	{"random", 1, 8},
+	{"urandom", 1, 9},
+	{"tty", 5, 0},
+}
+
+// devSymlinks point the usual /dev names at /proc and the container's own ptys.
+var devSymlinks = map[string]string{
+	"fd":     "/proc/self/fd",
+	"stdin":  "/proc/self/fd/0",
+	"stdout": "/proc/self/fd/1",
+	"stderr": "/proc/self/fd/2",
+	"ptmx":   "pts/ptmx",
+}
+
+// ttyGroup is the gid of the tty group in most images, which owns the ptys.
+const ttyGroup = 5
+
+// mountDev replaces the image's /dev with a tmpfs holding only the basic
+// device nodes, a devpts instance of the container's own and the /dev/shm
+// tmpfs. Device nodes can't be created in a user namespace, so they're bind
+// mounted from the host's /dev there instead.
+func mountDev(rootfs string, shmSize int64) error {
+	dev, err := resolveMountPoint(rootfs, "/dev")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dev, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", dev, "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755,size=65536k"); err != nil {
+		return fmt.Errorf("failed to mount /dev: %w", err)
+	}
+	// the mode isn't masked by the umask below
+	oldmask := syscall.Umask(0)
+	defer syscall.Umask(oldmask)
+	for _, d := range devices {
+		path := filepath.Join(dev, d.name)
+		err := syscall.Mknod(path, syscall.S_IFCHR|0666, int(d.major<<8|d.minor))
+		if err == syscall.EPERM {
+			err = bindDevice(path)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create /dev/%s: %w", d.name, err)
+		}
+	}
+	for name, target := range devSymlinks {
+		if err := os.Symlink(target, filepath.Join(dev, name)); err != nil {
+			return err
+		}
+	}
+	pts := filepath.Join(dev, "pts")
+	if err := os.Mkdir(pts, 0755); err != nil {
+		return err
+	}
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NOEXEC)
+	err = syscall.Mount("devpts", pts, "devpts", flags, fmt.Sprintf("newinstance,ptmxmode=0666,mode=0620,gid=%d", ttyGroup))
+	if err == syscall.EINVAL {
+		// the tty group isn't mapped in rootless user namespaces without subordinate gids
+		err = syscall.Mount("devpts", pts, "devpts", flags, "newinstance,ptmxmode=0666,mode=0620")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to mount /dev/pts: %w", err)
+	}
+	shm := filepath.Join(dev, "shm")
+	if err := os.Mkdir(shm, 01777); err != nil {
+		return err
+	}
+	data := fmt.Sprintf("mode=1777,size=%d", shmSize)
+	if err := syscall.Mount("shm", shm, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, data); err != nil {
+		return fmt.Errorf("failed to mount /dev/shm: %w", err)
+	}
+	return nil
+}
+
+// bindDevice bind mounts the host's device node with the same name on path.
+func bindDevice(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return syscall.Mount(filepath.Join("/dev", filepath.Base(path)), path, "", syscall.MS_BIND, "")
+}
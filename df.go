@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// UsageItem is a single object counted by the disk usage report.
+type UsageItem struct {
+	Name   string
+	Size   int64
+	Active bool
+}
+
+// DiskUsageReport is the space used by each kind of object in the data root.
+type DiskUsageReport struct {
+	Manifests  []UsageItem
+	Snapshots  []UsageItem
+	Containers []UsageItem
+}
+
+// SystemDiskUsage collects the disk usage report. A snapshot is active when a container
+// uses it and a container is active while its rootfs is mounted.
+func SystemDiskUsage() (DiskUsageReport, error) {
+	var report DiskUsageReport
+	root, err := DataRoot()
+	if err != nil {
+		return report, err
+	}
+	manifests := filepath.Join(root, "manifests")
+	err = filepath.WalkDir(manifests, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".json") {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(manifests, strings.TrimSuffix(path, ".json"))
+		report.Manifests = append(report.Manifests, UsageItem{Name: rel, Size: info.Size()})
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return report, err
+	}
+	containers, err := ListContainers()
+	if err != nil {
+		return report, err
+	}
+	mounts, err := MountPoints()
+	if err != nil {
+		return report, err
+	}
+	dir, err := ContainersDir()
+	if err != nil {
+		return report, err
+	}
+	used := map[string]bool{}
+	for _, c := range containers {
+		for _, layer := range c.Layers {
+			used[layer] = true
+		}
+		size, err := DiskUsage(filepath.Join(dir, c.ID, "upper"))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return report, err
+		}
+		report.Containers = append(report.Containers, UsageItem{
+			Name:   c.ID[:min(12, len(c.ID))],
+			Size:   size,
+			Active: mounts[filepath.Join(dir, c.ID, "rootfs")] || c.Running(),
+		})
+	}
+	snapshots, err := ListSnapshots()
+	if err != nil {
+		return report, err
+	}
+	for _, s := range snapshots {
+		report.Snapshots = append(report.Snapshots, UsageItem{
+			Name:   s.ID,
+			Size:   s.Size,
+			Active: used[s.Path],
+		})
+	}
+	return report, nil
+}
+
+// Write writes the report as a summary table, followed by
+// every object when verbose is set.
+func (r DiskUsageReport) Write(w io.Writer, verbose bool) error {
+	sections := []struct {
+		title string
+		items []UsageItem
+	}{
+		{"Manifests", r.Manifests},
+		{"Snapshots", r.Snapshots},
+		{"Containers", r.Containers},
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tTOTAL\tACTIVE\tSIZE\tRECLAIMABLE")
+	for _, s := range sections {
+		var active int
+		var size, reclaimable int64
+		for _, item := range s.items {
+			size += item.Size
+			if item.Active {
+				active++
+			} else {
+				reclaimable += item.Size
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", s.title, len(s.items), active, HumanSize(size), HumanSize(reclaimable))
+	}
+	if verbose {
+		for _, s := range sections {
+			if len(s.items) == 0 {
+				continue
+			}
+			fmt.Fprintf(tw, "\n%s:\nNAME\tSIZE\tACTIVE\n", s.title)
+			for _, item := range s.items {
+				fmt.Fprintf(tw, "%s\t%s\t%t\n", item.Name, HumanSize(item.Size), item.Active)
+			}
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+// ChangeKind is the kind of change made to a path.
+type ChangeKind byte
+
+const (
+	ChangeAdd    ChangeKind = 'A'
+	ChangeDelete ChangeKind = 'D'
+	ChangeModify ChangeKind = 'C'
+)
+
+// Change is a single path which differs between two trees.
+type Change struct {
+	Kind ChangeKind
+	Path string
+}
+
+func (c Change) String() string {
+	return string(c.Kind) + " " + c.Path
+}
+
+// fileMeta is what's compared to decide if a file changed.
+type fileMeta struct {
+	mode  fs.FileMode
+	size  int64
+	mtime int64
+	uid   uint32
+	gid   uint32
+	link  string
+}
+
+func readTree(root string) (map[string]fileMeta, error) {
+	tree := map[string]fileMeta{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		meta := fileMeta{mode: info.Mode()}
+		// directory sizes and mtimes change whenever their entries do,
+		// which is already reported by the entries themselves.
+		if !info.IsDir() {
+			meta.size = info.Size()
+			meta.mtime = info.ModTime().UnixNano()
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			meta.uid, meta.gid = st.Uid, st.Gid
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if meta.link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		rel, _ := filepath.Rel(root, path)
+		tree["/"+rel] = meta
+		return nil
+	})
+	return tree, err
+}
+
+// DiffTrees compares the directory trees at a and b and returns
+// the changes needed to turn a into b, sorted by path.
+func DiffTrees(a, b string) ([]Change, error) {
+	before, err := readTree(a)
+	if err != nil {
+		return nil, err
+	}
+	after, err := readTree(b)
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	for path, meta := range after {
+		prev, ok := before[path]
+		switch {
+		case !ok:
+			changes = append(changes, Change{ChangeAdd, path})
+		case prev != meta:
+			changes = append(changes, Change{ChangeModify, path})
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changes = append(changes, Change{ChangeDelete, path})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// ImageDiff is the difference between two images.
+type ImageDiff struct {
+	// Shared are the layers both images start with.
+	Shared []string
+	// Removed and Added are the layers only in the first or second image.
+	Removed []string
+	Added   []string
+	Changes []Change
+}
+
+// DiffImages compares the layers and files of two images.
+func DiffImages(a, b string) (ImageDiff, error) {
+	var diff ImageDiff
+	lock, err := LockStore(false)
+	if err != nil {
+		return diff, err
+	}
+	defer lock.Unlock()
+	refA, err := registry.ParseReference(a)
+	if err != nil {
+		return diff, err
+	}
+	layersA, err := FetchImageSnapshots(context.Background(), refA, PullMissing)
+	if err != nil {
+		return diff, err
+	}
+	refB, err := registry.ParseReference(b)
+	if err != nil {
+		return diff, err
+	}
+	layersB, err := FetchImageSnapshots(context.Background(), refB, PullMissing)
+	if err != nil {
+		return diff, err
+	}
+	n := 0
+	for n < len(layersA) && n < len(layersB) && layersA[n] == layersB[n] {
+		n++
+	}
+	for _, l := range layersA[:n] {
+		diff.Shared = append(diff.Shared, snapshotID(l))
+	}
+	for _, l := range layersA[n:] {
+		diff.Removed = append(diff.Removed, snapshotID(l))
+	}
+	for _, l := range layersB[n:] {
+		diff.Added = append(diff.Added, snapshotID(l))
+	}
+	tmp, err := os.MkdirTemp("", "diff-")
+	if err != nil {
+		return diff, err
+	}
+	defer os.RemoveAll(tmp)
+	dirA, dirB := filepath.Join(tmp, "a"), filepath.Join(tmp, "b")
+	for _, m := range []struct {
+		dir    string
+		layers []string
+	}{{dirA, layersA}, {dirB, layersB}} {
+		if err := os.Mkdir(m.dir, 0755); err != nil {
+			return diff, err
+		}
+		if err := MountReadOnly(m.layers, m.dir); err != nil {
+			return diff, err
+		}
+		defer Unmount(m.dir)
+	}
+	diff.Changes, err = DiffTrees(dirA, dirB)
+	return diff, err
+}
+
+// snapshotID turns a snapshot directory back into its diffID.
+func snapshotID(dir string) string {
+	return "sha256:" + filepath.Base(dir)
+}
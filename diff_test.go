@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffTrees(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	write := func(root, name, data string) {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Unix(1700000000, 0)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(a, "etc/same", "x")
+	write(b, "etc/same", "x")
+	write(a, "etc/changed", "old")
+	write(b, "etc/changed", "newer")
+	write(a, "removed", "x")
+	write(b, "usr/bin/added", "x")
+	if err := os.Symlink("same", filepath.Join(b, "etc", "link")); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := DiffTrees(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{ChangeModify, "/etc/changed"},
+		{ChangeAdd, "/etc/link"},
+		{ChangeDelete, "/removed"},
+		{ChangeAdd, "/usr"},
+		{ChangeAdd, "/usr/bin"},
+		{ChangeAdd, "/usr/bin/added"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("got %v, want %v", changes, want)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+)
+
+// maxResumes is how many times a download whose connection dropped is
+// resumed before it fails.
+const maxResumes = 5
+
+// blobDownload is a blob being downloaded into a .partial file next to where
+// it would be kept in the blob store, as it's passed on to the reader. When
+// the connection drops it carries on from where it stopped with a range
+// request, and a download which failed anyway is picked up from the .partial
+// file by the next pull of the blob instead of starting from zero.
+type blobDownload struct {
+	ctx   context.Context
+	repo  Repository
+	layer Layer
+	lock  *FileLock
+	f     *os.File
+	// prefix replays what an earlier download left in the .partial file
+	prefix io.Reader
+	body   io.ReadCloser
+	// offset is the amount of the blob read so far, which is also in the
+	// .partial file unless writing to it failed and f is nil
+	offset  int64
+	h       hash.Hash
+	resumes int
+	err     error
+}
+
+// openBlobDownload starts downloading a layer, resuming from its .partial
+// file if there is one. Like OpenLayer, the layer is verified against the
+// digest as it's read. Another process which is downloading the same blob
+// holds the .partial.lock file, so this one downloads it without resuming.
+func openBlobDownload(ctx context.Context, repo Repository, layer Layer) (io.ReadCloser, error) {
+	p, err := BlobPath(layer.Digest)
+	if err != nil {
+		return nil, err
+	}
+	p += ".partial"
+	// the lock is kept apart from the .partial file, which is removed
+	// while it's held
+	lock, ok, err := TryLockFile(p + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		body, err := registryClient.OpenLayer(ctx, repo, layer)
+		if err != nil {
+			return nil, err
+		}
+		return throttle(body), nil
+	}
+	d, err := resumeBlobDownload(ctx, repo, layer, lock, p)
+	if err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	return d, nil
+}
+
+func resumeBlobDownload(ctx context.Context, repo Repository, layer Layer, lock *FileLock, path string) (*blobDownload, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	offset := info.Size()
+	body, err := openLayerAt(ctx, repo, layer, offset)
+	if err != nil && offset > 0 {
+		// the .partial file might be complete already, or not be part of
+		// the blob at all, so start over
+		offset = 0
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return nil, err
+		}
+		body, err = openLayerAt(ctx, repo, layer, 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if offset > 0 {
+		log.Printf("resuming download of %s from %s", layer.Digest, HumanSize(offset))
+	}
+	return &blobDownload{
+		ctx:    ctx,
+		repo:   repo,
+		layer:  layer,
+		lock:   lock,
+		f:      f,
+		prefix: io.NewSectionReader(f, 0, offset),
+		body:   body,
+		offset: offset,
+		h:      sha256.New(),
+	}, nil
+}
+
+// openLayerAt starts downloading a layer from offset, throttled to the -pull-rate-limit.
+func openLayerAt(ctx context.Context, repo Repository, layer Layer, offset int64) (io.ReadCloser, error) {
+	body, err := registryClient.OpenLayerAt(ctx, repo, layer, offset)
+	if err != nil {
+		return nil, err
+	}
+	return throttle(body), nil
+}
+
+// throttle limits reading the body to the -pull-rate-limit, if there is one.
+func throttle(body io.ReadCloser) io.ReadCloser {
+	if pullLimit != nil {
+		return pullLimit.LimitReader(body)
+	}
+	return body
+}
+
+func (d *blobDownload) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	n, err := d.read(p)
+	d.h.Write(p[:n])
+	if err == io.EOF {
+		if got := fmt.Sprintf("sha256:%x", d.h.Sum(nil)); got != d.layer.Digest {
+			err = fmt.Errorf("digest mismatch: got %s, expected %s", got, d.layer.Digest)
+			// resuming from it would only fail the same way
+			if d.f != nil {
+				d.f.Truncate(0)
+			}
+		}
+	}
+	if err != nil {
+		d.err = err
+	}
+	return n, err
+}
+
+func (d *blobDownload) read(p []byte) (int, error) {
+	if d.prefix != nil {
+		n, err := d.prefix.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		d.prefix = nil
+		if n > 0 {
+			return n, nil
+		}
+	}
+	n, err := d.body.Read(p)
+	if n > 0 && d.f != nil {
+		if _, err := d.f.WriteAt(p[:n], d.offset); err != nil {
+			// like when the disk is full, the download carries on without
+			// the .partial file and can't be picked up by a later pull
+			log.Printf("downloading %s: %v, no longer keeping it to resume", d.layer.Digest, err)
+			d.f.Close()
+			os.Remove(d.f.Name())
+			d.f = nil
+		}
+	}
+	d.offset += int64(n)
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+	return n, d.resume(err)
+}
+
+// resume reconnects after the download failed with err, starting after
+// what was read so far.
+func (d *blobDownload) resume(err error) error {
+	d.body.Close()
+	if d.resumes >= maxResumes || d.ctx.Err() != nil {
+		return err
+	}
+	d.resumes++
+	log.Printf("downloading %s: %v, resuming from %s", d.layer.Digest, err, HumanSize(d.offset))
+	body, err := openLayerAt(d.ctx, d.repo, d.layer, d.offset)
+	if err != nil {
+		return err
+	}
+	d.body = body
+	return nil
+}
+
+// Close stops the download. The .partial file is kept for the next pull
+// unless the whole blob was downloaded and verified.
+func (d *blobDownload) Close() error {
+	d.body.Close()
+	if d.f != nil {
+		if d.err == io.EOF {
+			os.Remove(d.f.Name())
+		}
+		d.f.Close()
+	}
+	return d.lock.Unlock()
+}
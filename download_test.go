@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+func TestBlobDownloadResumes(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	blob := strings.Repeat("layer data ", 1000)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(blob)))
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) == 1 {
+			// drop the connection half way through
+			w.Header().Set("Content-Length", fmt.Sprint(len(blob)))
+			io.WriteString(w, blob[:len(blob)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(blob))
+	}))
+	defer srv.Close()
+	old := registryClient
+	registryClient = &registry.Client{BaseURL: srv.URL}
+	defer func() { registryClient = old }()
+	repo := Repository{Registry: "example.com", Library: "library", Image: "test"}
+	body, err := openBlobDownload(context.Background(), repo, Layer{Digest: digest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != blob {
+		t.Fatalf("got %d bytes, want %d", len(data), len(blob))
+	}
+	want := []string{"", fmt.Sprintf("bytes=%d-", len(blob)/2)}
+	if fmt.Sprint(ranges) != fmt.Sprint(want) {
+		t.Errorf("got ranges %q, want %q", ranges, want)
+	}
+	p, err := BlobPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(p + ".partial"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the .partial file to be removed, got %v", err)
+	}
+}
+
+func TestBlobDownloadResumesPartialFile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	blob := "0123456789"
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(blob)))
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(blob))
+	}))
+	defer srv.Close()
+	old := registryClient
+	registryClient = &registry.Client{BaseURL: srv.URL}
+	defer func() { registryClient = old }()
+	repo := Repository{Registry: "example.com", Library: "library", Image: "test"}
+	p, err := BlobPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		partial string
+		ranges  []string
+	}{
+		{partial: "0123", ranges: []string{"bytes=4-"}},
+		// a .partial file which doesn't match fails once and is thrown away
+		{partial: "abcd", ranges: []string{"bytes=4-"}},
+		{partial: "", ranges: []string{""}},
+	}
+	for _, tt := range tests {
+		ranges = nil
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if tt.partial != "" {
+			if err := os.WriteFile(p+".partial", []byte(tt.partial), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		body, err := openBlobDownload(context.Background(), repo, Layer{Digest: digest})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(body)
+		body.Close()
+		if fmt.Sprint(ranges) != fmt.Sprint(tt.ranges) {
+			t.Errorf("partial %q: got ranges %q, want %q", tt.partial, ranges, tt.ranges)
+		}
+		if tt.partial == "abcd" {
+			if err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+				t.Errorf("expected a digest mismatch, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != blob {
+			t.Errorf("partial %q: got %q", tt.partial, data)
+		}
+	}
+}
+
+func TestBlobDownloadWriteError(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "blob.partial")
+	if err := os.WriteFile(p, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// writing to a read-only file fails
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	blob := "data"
+	d := &blobDownload{
+		layer: Layer{Digest: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(blob)))},
+		f:     f,
+		body:  io.NopCloser(strings.NewReader(blob)),
+		h:     sha256.New(),
+	}
+	// the download carries on without the .partial file
+	data, err := io.ReadAll(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != blob {
+		t.Errorf("got %q, want %q", data, blob)
+	}
+	if _, err := os.Stat(p); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the .partial file to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ReadEnvFile reads an env file with one variable per line, in the same
+// format as docker's --env-file. Blank lines and lines starting with #
+// are ignored, and values aren't unquoted.
+func ReadEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var env []string
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimLeft(sc.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key, _, _ := strings.Cut(line, "="); key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s:%d: invalid variable: %q", path, n, line)
+		}
+		env = append(env, line)
+	}
+	return env, sc.Err()
+}
+
+// MergeEnv sets the variables in vars on top of env. Variables without a
+// value are taken from the host's environment, or skipped if it isn't set there.
+func MergeEnv(env, vars []string) ([]string, error) {
+	merged := append([]string{}, env...)
+	for _, kv := range vars {
+		key, _, ok := strings.Cut(kv, "=")
+		if key == "" {
+			return nil, fmt.Errorf("missing variable name in %q", kv)
+		}
+		if !ok {
+			value, set := os.LookupEnv(key)
+			if !set {
+				continue
+			}
+			kv = key + "=" + value
+		}
+		replaced := false
+		for i, existing := range merged {
+			if strings.HasPrefix(existing, key+"=") {
+				merged[i] = kv
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, kv)
+		}
+	}
+	return merged, nil
+}
+
+// DefaultEnv sets key to value in env unless it's set already.
+func DefaultEnv(env []string, key, value string) []string {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			return env
+		}
+	}
+	return append(env, key+"="+value)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "env")
+	data := "# database\nDB_HOST=db\n\n  DB_PASS=\"quoted\"\nHOME_DIR\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env, err := ReadEnvFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"DB_HOST=db", `DB_PASS="quoted"`, "HOME_DIR"}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("got %q, want %q", env, want)
+	}
+	if err := os.WriteFile(path, []byte("BAD KEY=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadEnvFile(path); err == nil {
+		t.Error("expected an error for a key with a space")
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	t.Setenv("FROM_HOST", "host")
+	image := []string{"PATH=/bin", "LANG=C"}
+	env, err := MergeEnv(image, []string{"LANG=C.UTF-8", "DEBUG=1", "FROM_HOST", "UNSET_ON_HOST"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"PATH=/bin", "LANG=C.UTF-8", "DEBUG=1", "FROM_HOST=host"}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("got %q, want %q", env, want)
+	}
+	if image[1] != "LANG=C" {
+		t.Error("the image's env was modified")
+	}
+	if _, err := MergeEnv(nil, []string{"=value"}); err == nil {
+		t.Error("expected an error for a missing name")
+	}
+}
+
+func TestDefaultEnv(t *testing.T) {
+	env := DefaultEnv([]string{"PATH=/bin"}, "HOME", "/root")
+	if want := []string{"PATH=/bin", "HOME=/root"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("got %q, want %q", env, want)
+	}
+	env = DefaultEnv([]string{"HOME=/home/app"}, "HOME", "/root")
+	if want := []string{"HOME=/home/app"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("got %q, want %q", env, want)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// execNamespaces are the namespaces of a container which exec joins. A
+// multithreaded process can't join a mount namespace, so the container's
+// root is reached through /proc/<pid>/root instead, and the same goes for
+// user namespaces, which is why exec doesn't work for rootless containers.
+var execNamespaces = []struct {
+	name string
+	flag int
+}{
+	{"ipc", syscall.CLONE_NEWIPC},
+	{"uts", syscall.CLONE_NEWUTS},
+	{"net", syscall.CLONE_NEWNET},
+	{"pid", syscall.CLONE_NEWPID},
+}
+
+// ExecOptions are how exec runs a command in a container. They default to
+// what the container was run with.
+type ExecOptions struct {
+	// Env is merged into the container's environment.
+	Env []string
+	// User and WorkingDir replace the container's when they're set.
+	User       string
+	WorkingDir string
+}
+
+// ContainerSeccompPath returns the path of the container's compiled seccomp
+// filter in its directory, which exec applies as well.
+func ContainerSeccompPath(dir string) string {
+	return filepath.Join(dir, "seccomp.bpf")
+}
+
+// ExecCommand returns a command which runs args in the running container,
+// with the same capabilities, seccomp filter and no_new_privs as its own
+// process. StartExec has to be used to start it.
+func ExecCommand(c Container, dir string, args []string, opts ExecOptions) (*exec.Cmd, error) {
+	if c.Config.Runtime != "native" {
+		return nil, fmt.Errorf("exec requires the native runtime")
+	}
+	root := fmt.Sprintf("/proc/%d/root", c.State.Pid)
+	env, err := MergeEnv(c.Config.Env, opts.Env)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -e: %w", err)
+	}
+	spec := c.Config.User
+	if opts.User != "" {
+		spec = opts.User
+	}
+	u, err := ResolveUser(root, spec)
+	if err != nil {
+		return nil, err
+	}
+	var user *User
+	if spec != "" {
+		user = &u
+		env = DefaultEnv(env, "HOME", u.Home)
+	}
+	workdir := c.Config.WorkingDir
+	if opts.WorkingDir != "" {
+		workdir = opts.WorkingDir
+	}
+	seccomp, err := os.ReadFile(ContainerSeccompPath(dir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return InitCommand(ContainerConfig{
+		Rootfs:          root,
+		Args:            args,
+		Env:             env,
+		Dir:             workdir,
+		User:            user,
+		Umask:           c.Config.Umask,
+		Keyring:         "_ses." + c.ID[:12],
+		Capabilities:    c.Config.Capabilities,
+		Seccomp:         seccomp,
+		NoNewPrivileges: c.Config.NoNewPrivileges,
+	}, 0)
+}
+
+// StartExec starts cmd in the namespaces and cgroup of the container's
+// process. The namespaces are joined by the thread which forks the command,
+// and that thread is thrown away afterwards.
+func StartExec(cmd *exec.Cmd, c Container) error {
+	pid := c.State.Pid
+	var fds []*os.File
+	var flags []int
+	defer func() {
+		for _, f := range fds {
+			f.Close()
+		}
+	}()
+	for _, ns := range execNamespaces {
+		theirs, err := os.Stat(fmt.Sprintf("/proc/%d/ns/%s", pid, ns.name))
+		if err != nil {
+			return err
+		}
+		// the container shares the ones it wasn't given its own of
+		if ours, err := os.Stat("/proc/self/ns/" + ns.name); err == nil && os.SameFile(ours, theirs) {
+			continue
+		}
+		f, err := os.Open(fmt.Sprintf("/proc/%d/ns/%s", pid, ns.name))
+		if err != nil {
+			return err
+		}
+		fds = append(fds, f)
+		flags = append(flags, ns.flag)
+	}
+	// make sure the namespaces are still the container's, and not those
+	// of a process which reused its pid
+	if !isProcess(pid, c.State.StartTime) {
+		return fmt.Errorf("container %s isn't running", c.ID)
+	}
+	if cgroup, err := processCgroup(pid); err == nil && CgroupsAvailable() {
+		if dir, err := os.Open(filepath.Join(cgroupRoot, cgroup)); err == nil {
+			defer dir.Close()
+			cmd.SysProcAttr.UseCgroupFD = true
+			cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+		}
+	}
+	errc := make(chan error, 1)
+	go func() {
+		// never unlocked, so the thread exits along with the goroutine
+		runtime.LockOSThread()
+		for i, f := range fds {
+			if _, _, errno := syscall.Syscall(sysSetns, f.Fd(), uintptr(flags[i]), 0); errno != 0 {
+				errc <- fmt.Errorf("failed to join the container's namespaces: %w", errno)
+				return
+			}
+		}
+		errc <- cmd.Start()
+	}()
+	return <-errc
+}
+
+// processCgroup returns the cgroup v2 path of the process.
+func processCgroup(pid int) (string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if p, ok := strings.CutPrefix(line, "0::"); ok {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("process %d isn't in a cgroup v2 hierarchy", pid)
+}
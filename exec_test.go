@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExecCommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(ContainerSeccompPath(dir), []byte("filter"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := Container{
+		ID: "0123456789abcdef",
+		Config: ContainerSettings{
+			Runtime:    "native",
+			Env:        []string{"A=1", "B=2"},
+			WorkingDir: "/srv",
+			Umask:      027,
+		},
+		State: &ContainerState{Pid: os.Getpid()},
+	}
+	cmd, err := ExecCommand(c, dir, []string{"true"}, ExecOptions{
+		Env:        []string{"B=3"},
+		WorkingDir: "/tmp",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg ContainerConfig
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(cmd.Env[0], initConfigEnv+"=")), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("/proc/%d/root", os.Getpid()); cfg.Rootfs != want {
+		t.Errorf("Rootfs = %q, want %q", cfg.Rootfs, want)
+	}
+	if want := []string{"A=1", "B=3"}; !slices.Equal(cfg.Env, want) {
+		t.Errorf("Env = %q, want %q", cfg.Env, want)
+	}
+	if cfg.Dir != "/tmp" {
+		t.Errorf("Dir = %q, want /tmp", cfg.Dir)
+	}
+	if cfg.User != nil {
+		t.Errorf("User = %+v, want nil", cfg.User)
+	}
+	if cfg.Umask != 027 || cfg.Keyring != "_ses.0123456789ab" || string(cfg.Seccomp) != "filter" {
+		t.Errorf("got umask %o, keyring %q and seccomp %q", cfg.Umask, cfg.Keyring, cfg.Seccomp)
+	}
+
+	c.Config.Runtime = "gvisor"
+	if _, err := ExecCommand(c, dir, []string{"true"}, ExecOptions{}); err == nil {
+		t.Error("expected an error for another runtime")
+	}
+}
+
+func TestProcessCgroup(t *testing.T) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		t.Skip(err)
+	}
+	if !strings.Contains(string(data), "0::") {
+		t.Skip("not in a cgroup v2 hierarchy")
+	}
+	p, err := processCgroup(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(p, "/") {
+		t.Errorf("processCgroup = %q, want an absolute path", p)
+	}
+}
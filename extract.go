@@ -0,0 +1,314 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/icholy/shittydocker/internal/zstd"
+)
+
+// ExtractLayer extracts a layer tarball into dir. Layers can be gzipped,
+// zstd compressed or uncompressed. Paths, including symlinks in their parent directories, are
+// resolved as if dir was the root, so a layer can't write outside of it.
+// Unless diffID is empty, the uncompressed layer has to match it.
+func ExtractLayer(r io.Reader, dir, diffID string) error {
+	br := bufio.NewReader(r)
+	decompressed, err := decompressLayer(br)
+	if err != nil {
+		return fmt.Errorf("failed to untar: %w", err)
+	}
+	h := sha256.New()
+	layer := io.TeeReader(decompressed, h)
+	x := extractor{root: dir, chown: os.Geteuid() == 0, rootless: Rootless()}
+	tr := tar.NewReader(layer)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to untar: %w", err)
+		}
+		if err := x.extract(hdr, tr); err != nil {
+			return fmt.Errorf("failed to untar %s: %w", hdr.Name, err)
+		}
+	}
+	// directory times are restored last, since adding the
+	// entries inside of them changes their mtime.
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		hdr := x.dirs[i]
+		if err := lutimes(hdr.path, hdr.AccessTime, hdr.ModTime); err != nil {
+			return fmt.Errorf("failed to untar %s: %w", hdr.Name, err)
+		}
+	}
+	// the tar stream can be padded after the end of the archive, and the
+	// compressed stream can have trailing data after that. Read the rest
+	// of both so neither digest mismatch goes unnoticed.
+	if _, err := io.Copy(io.Discard, layer); err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, br); err != nil {
+		return err
+	}
+	if got := fmt.Sprintf("sha256:%x", h.Sum(nil)); diffID != "" && got != diffID {
+		return fmt.Errorf("diff id mismatch: got %s, expected %s", got, diffID)
+	}
+	return nil
+}
+
+// decompressLayer returns the tar stream of a layer which is gzipped, zstd
+// compressed or uncompressed.
+func decompressLayer(br *bufio.Reader) (io.Reader, error) {
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		// a copy of the standard library's decoder, which isn't exported
+		return zstd.NewReader(br), nil
+	}
+	return br, nil
+}
+
+type extractor struct {
+	root string
+	// chown is whether file ownership can be restored, which requires root.
+	chown bool
+	// rootless is set in a user namespace, where device nodes can't be
+	// created and only the mapped ids can own files.
+	rootless bool
+	dirs     []extractedDir
+}
+
+type extractedDir struct {
+	*tar.Header
+	path string
+}
+
+func (x *extractor) extract(hdr *tar.Header, r io.Reader) error {
+	path, err := x.resolve(hdr.Name)
+	if err != nil {
+		return err
+	}
+	if ok, err := x.whiteout(path); ok || err != nil {
+		return err
+	}
+	if path == x.root {
+		// the root directory itself, only its metadata matters
+		return x.finish(hdr, path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// later entries replace earlier ones with the same name, except
+	// that directories are merged.
+	if fi, err := os.Lstat(path); err == nil && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(path, 0700); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		err = copySparse(f, r, hdr.Size)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, path); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		target, err := x.resolve(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := os.Link(target, path); err != nil {
+			return err
+		}
+		// the link shares the target's inode, which already has its metadata
+		return nil
+	case tar.TypeChar, tar.TypeBlock:
+		typ := uint32(syscall.S_IFCHR)
+		if hdr.Typeflag == tar.TypeBlock {
+			typ = syscall.S_IFBLK
+		}
+		if err := syscall.Mknod(path, typ|mode, mkdev(hdr)); err != nil {
+			// the container gets its devices from /dev anyway
+			if x.rootless && err == syscall.EPERM {
+				return nil
+			}
+			return err
+		}
+	case tar.TypeFifo:
+		if err := syscall.Mkfifo(path, mode); err != nil {
+			return err
+		}
+	default:
+		// nothing else ends up on disk
+		return nil
+	}
+	return x.finish(hdr, path)
+}
+
+// whiteout converts whiteout entries to the overlayfs format, so the
+// snapshots can be stacked as lower directories. It reports false for
+// entries which should be extracted like any other file.
+func (x *extractor) whiteout(path string) (bool, error) {
+	dir, name := filepath.Split(path)
+	if name == opaqueWhiteout {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return false, err
+		}
+		return markOpaque(dir)
+	}
+	if target, ok := strings.CutPrefix(name, whiteoutPrefix); ok {
+		// other .wh..wh. names are aufs metadata which has no meaning here
+		if target == "" || strings.HasPrefix(target, whiteoutPrefix) {
+			return true, nil
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return false, err
+		}
+		return createWhiteout(filepath.Join(dir, target))
+	}
+	return false, nil
+}
+
+// finish restores the ownership, mode, xattrs and times of the file.
+func (x *extractor) finish(hdr *tar.Header, path string) error {
+	if x.chown {
+		// ids outside of the user namespace's mappings are left owned by root
+		if err := os.Lchown(path, hdr.Uid, hdr.Gid); err != nil && !(x.rootless && errors.Is(err, syscall.EINVAL)) {
+			return err
+		}
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		return lutimes(path, hdr.AccessTime, hdr.ModTime)
+	}
+	// chmod after chown, which clears the setuid and setgid bits
+	if err := os.Chmod(path, hdr.FileInfo().Mode()); err != nil {
+		return err
+	}
+	for key, value := range hdr.PAXRecords {
+		name, ok := strings.CutPrefix(key, "SCHILY.xattr.")
+		if !ok {
+			continue
+		}
+		// file capabilities like the cap_net_raw on ping are stored as xattrs
+		if err := syscall.Setxattr(path, name, []byte(value), 0); err != nil && err != syscall.ENOTSUP {
+			return fmt.Errorf("failed to set xattr %s: %w", name, err)
+		}
+	}
+	if hdr.Typeflag == tar.TypeDir {
+		x.dirs = append(x.dirs, extractedDir{Header: hdr, path: path})
+		return nil
+	}
+	return lutimes(path, hdr.AccessTime, hdr.ModTime)
+}
+
+// resolve returns where the archive path ends up under the root. Symlinks in
+// the parent directories are followed as if the root was /, and .. can't go
+// above it. The last component isn't followed since it's being replaced.
+func (x *extractor) resolve(name string) (string, error) {
+	return resolveInRoot(x.root, name)
+}
+
+// resolveInRoot is resolve for any root.
+func resolveInRoot(root, name string) (string, error) {
+	parts := strings.Split(filepath.Clean("/"+name), "/")[1:]
+	var resolved []string
+	for hops := 0; len(parts) > 1; {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) > 0 {
+				resolved = resolved[:len(resolved)-1]
+			}
+			continue
+		}
+		path := filepath.Join(root, filepath.Join(resolved...), part)
+		fi, err := os.Lstat(path)
+		if err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+			resolved = append(resolved, part)
+			continue
+		}
+		if hops++; hops > 40 {
+			return "", fmt.Errorf("too many levels of symbolic links")
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(target, "/") {
+			resolved = nil
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+	last := parts[0]
+	if last == ".." || last == "." || last == "" {
+		// only possible for the root itself after cleaning
+		return filepath.Join(root, filepath.Join(resolved...)), nil
+	}
+	return filepath.Join(root, filepath.Join(resolved...), last), nil
+}
+
+func mkdev(hdr *tar.Header) int {
+	major, minor := uint64(hdr.Devmajor), uint64(hdr.Devminor)
+	return int((major&0xfff)<<8 | (major&^0xfff)<<32 | minor&0xff | (minor&^0xff)<<12)
+}
+
+// AT_FDCWD and AT_SYMLINK_NOFOLLOW from linux/fcntl.h
+const (
+	atFDCWD           = -0x64
+	atSymlinkNofollow = 0x100
+)
+
+// lutimes sets the times of the path without following symlinks. The
+// access time falls back to the modification time when it's missing.
+func lutimes(path string, atime, mtime time.Time) error {
+	if atime.IsZero() {
+		atime = mtime
+	}
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	ts := [2]syscall.Timespec{
+		syscall.NsecToTimespec(atime.UnixNano()),
+		syscall.NsecToTimespec(mtime.UnixNano()),
+	}
+	dirfd := atFDCWD
+	_, _, errno := syscall.Syscall6(syscall.SYS_UTIMENSAT, uintptr(dirfd), uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&ts[0])), atSymlinkNofollow, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
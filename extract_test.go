@@ -0,0 +1,303 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// buildLayer returns a gzipped tarball of the headers, with contents
+// taken from the map for regular files.
+func buildLayer(t *testing.T, headers []*tar.Header, contents map[string]string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for _, hdr := range headers {
+		var data string
+		if hdr.Typeflag == tar.TypeReg {
+			data = contents[hdr.Name]
+			hdr.Size = int64(len(data))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExtractLayer(t *testing.T) {
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	layer := buildLayer(t, []*tar.Header{
+		{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: mtime},
+		{Name: "usr/bin/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: mtime},
+		{Name: "bin", Typeflag: tar.TypeSymlink, Linkname: "usr/bin", ModTime: mtime},
+		{Name: "bin/sh", Typeflag: tar.TypeReg, Mode: 0755, ModTime: mtime},
+		{Name: "usr/bin/su", Typeflag: tar.TypeReg, Mode: 04755, ModTime: mtime},
+		{Name: "usr/bin/sh2", Typeflag: tar.TypeLink, Linkname: "bin/sh"},
+		{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "/../.."},
+		{Name: "escape/etc/passwd", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "../../outside", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{
+		"bin/sh":            "#!shell",
+		"usr/bin/su":        "su",
+		"escape/etc/passwd": "root::0:0::/:/bin/sh",
+		"../../outside":     "outside",
+	})
+	parent := t.TempDir()
+	root := filepath.Join(parent, "a", "rootfs")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ExtractLayer(layer, root, ""); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "usr", "bin", "sh")); err != nil || string(data) != "#!shell" {
+		t.Errorf("the file written through the symlink is missing: %q %v", data, err)
+	}
+	if target, err := os.Readlink(filepath.Join(root, "bin")); err != nil || target != "usr/bin" {
+		t.Errorf("bad symlink: %q %v", target, err)
+	}
+	info, err := os.Stat(filepath.Join(root, "usr", "bin", "sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 || !info.ModTime().Equal(mtime) {
+		t.Errorf("unexpected metadata: %v %v", info.Mode(), info.ModTime())
+	}
+	link, err := os.Stat(filepath.Join(root, "usr", "bin", "sh2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(info, link) {
+		t.Error("expected the hardlink to share the inode")
+	}
+	if info, err := os.Stat(filepath.Join(root, "usr", "bin", "su")); err != nil || info.Mode()&os.ModeSetuid == 0 {
+		t.Errorf("expected su to be setuid: %v %v", info.Mode(), err)
+	}
+	if info, err := os.Stat(filepath.Join(root, "usr")); err != nil || !info.ModTime().Equal(mtime) {
+		t.Errorf("expected the directory mtime to be restored: %v", err)
+	}
+	// paths leaving the root end up inside of it
+	if data, err := os.ReadFile(filepath.Join(root, "etc", "passwd")); err != nil || string(data) != "root::0:0::/:/bin/sh" {
+		t.Errorf("expected the escaping symlink to resolve inside the root: %q %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "outside")); err != nil {
+		t.Errorf("expected ../ to be clamped to the root: %v", err)
+	}
+	for _, p := range []string{filepath.Join(parent, "outside"), filepath.Join(parent, "etc")} {
+		if _, err := os.Lstat(p); err == nil {
+			t.Errorf("%s was written outside of the root", p)
+		}
+	}
+}
+
+// zstdFrame wraps data in a zstd frame made of raw, uncompressed blocks.
+func zstdFrame(data []byte) []byte {
+	// no checksum or content size, and a 2MB window
+	frame := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x58}
+	for {
+		n := min(len(data), 128*1024)
+		header := uint32(n) << 3
+		if n == len(data) {
+			header |= 1
+		}
+		frame = append(frame, byte(header), byte(header>>8), byte(header>>16))
+		frame = append(frame, data[:n]...)
+		if data = data[n:]; len(data) == 0 {
+			return frame
+		}
+	}
+}
+
+func TestExtractLayerZstd(t *testing.T) {
+	zr, err := gzip.NewReader(buildLayer(t, []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/hostname", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{
+		"etc/hostname": strings.Repeat("zstd", 64*1024),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	if err := ExtractLayer(bytes.NewReader(zstdFrame(data)), root, ""); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "etc", "hostname")); err != nil || string(data) != strings.Repeat("zstd", 64*1024) {
+		t.Errorf("unexpected contents: %d bytes, %v", len(data), err)
+	}
+}
+
+func TestExtractLayerReplace(t *testing.T) {
+	root := t.TempDir()
+	layer := buildLayer(t, []*tar.Header{
+		{Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/tmp"},
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/fifo", Typeflag: tar.TypeFifo, Mode: 0600},
+		{Name: "etc/fifo", Typeflag: tar.TypeReg, Mode: 0600},
+	}, map[string]string{"etc/fifo": "file"})
+	if err := ExtractLayer(layer, root, ""); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(filepath.Join(root, "etc"))
+	if err != nil || !info.IsDir() {
+		t.Fatalf("expected the symlink to be replaced by a directory: %v", err)
+	}
+	var st syscall.Stat_t
+	if err := syscall.Lstat(filepath.Join(root, "etc", "fifo"), &st); err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFREG {
+		t.Errorf("expected the fifo to be replaced by a file: %v", err)
+	}
+}
+
+func TestExtractLayerWhiteouts(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("whiteouts need root")
+	}
+	root := t.TempDir()
+	layer := buildLayer(t, []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/.wh.motd", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "var/cache/.wh..wh..opq", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "var/cache/new", Typeflag: tar.TypeReg, Mode: 0644},
+	}, nil)
+	if err := ExtractLayer(layer, root, ""); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(filepath.Join(root, "etc", "motd"))
+	if err != nil || !isWhiteout(info) {
+		t.Errorf("expected motd to be a whiteout: %v", err)
+	}
+	for _, name := range []string{"etc/.wh.motd", "var/cache/.wh..wh..opq"} {
+		if _, err := os.Lstat(filepath.Join(root, name)); err == nil {
+			t.Errorf("%s was extracted as a file", name)
+		}
+	}
+	if !isOpaque(filepath.Join(root, "var", "cache")) {
+		t.Error("expected var/cache to be opaque")
+	}
+	// unpacking the layer on top of a lower one applies the whiteouts
+	dir := t.TempDir()
+	for _, name := range []string{"etc/motd", "etc/hosts", "var/cache/old", "var/log"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := applyLayer(root, dir); err != nil {
+		t.Fatal(err)
+	}
+	for name, exists := range map[string]bool{
+		"etc/motd":      false,
+		"etc/hosts":     true,
+		"var/cache/old": false,
+		"var/cache/new": true,
+		"var/log":       true,
+	} {
+		if _, err := os.Lstat(filepath.Join(dir, name)); (err == nil) != exists {
+			t.Errorf("%s: expected exists=%v, got %v", name, exists, err)
+		}
+	}
+	if isOpaque(filepath.Join(dir, "var", "cache")) {
+		t.Error("expected the opaque xattr to be removed")
+	}
+}
+
+func TestCreateSnapshotDiffID(t *testing.T) {
+	layer := buildLayer(t, []*tar.Header{{Name: "file", Typeflag: tar.TypeReg, Mode: 0644}}, map[string]string{"file": "data"})
+	zr, err := gzip.NewReader(bytes.NewReader(layer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarball, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffID := fmt.Sprintf("sha256:%x", sha256.Sum256(tarball))
+	parent := t.TempDir()
+	// another image claiming the layer's diff id can't put its own contents there
+	dir := filepath.Join(parent, "other")
+	err = createSnapshot(dir, bytes.NewReader(layer.Bytes()), "sha256:"+strings.Repeat("1", 64))
+	if err == nil || !strings.Contains(err.Error(), "diff id mismatch") {
+		t.Fatalf("expected a diff id mismatch, got %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected no snapshot after a mismatch, got %v", err)
+	}
+	dir = filepath.Join(parent, "snapshot")
+	if err := createSnapshot(dir, bytes.NewReader(layer.Bytes()), diffID); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "file")); err != nil || string(data) != "data" {
+		t.Fatalf("unexpected snapshot contents: %q, %v", data, err)
+	}
+}
+
+func TestExtractLayerSparse(t *testing.T) {
+	src := t.TempDir()
+	f, err := os.Create(filepath.Join(src, "disk.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 64 << 20
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("middle"), 32<<20); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// WriteTar stores it as a PAX sparse entry
+	var buf bytes.Buffer
+	if err := WriteTar(&buf, src, ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := ExtractLayer(&buf, dir, ""); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, "disk.img")
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != size {
+		t.Errorf("got size %d, want %d", info.Size(), size)
+	}
+	if used := info.Sys().(*syscall.Stat_t).Blocks * 512; used > 1<<20 {
+		t.Errorf("expected the holes to be kept, %d bytes are allocated", used)
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[32<<20:32<<20+6]) != "middle" {
+		t.Error("sparse file contents don't match")
+	}
+}
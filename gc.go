@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Snapshot is an extracted layer in the snapshot store.
+type Snapshot struct {
+	ID       string
+	Path     string
+	Size     int64
+	LastUsed time.Time
+}
+
+// ListSnapshots returns all the snapshots in the store.
+func ListSnapshots() ([]Snapshot, error) {
+	root, err := DataRoot()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(root, "snapshots", "sha256")
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []Snapshot
+	for _, e := range entries {
+		// skip in progress extractions
+		if !e.IsDir() || len(e.Name()) != 64 {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, e.Name())
+		size, err := DiskUsage(path)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, Snapshot{
+			ID:       "sha256:" + e.Name(),
+			Path:     path,
+			Size:     size,
+			LastUsed: info.ModTime(),
+		})
+	}
+	return snapshots, nil
+}
+
+// DiskUsage returns the total size of the files under path.
+func DiskUsage(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// GCPolicy decides which snapshots are garbage collected. Snapshots used
+// by existing containers or mounted with image mount are never removed.
+type GCPolicy struct {
+	// MaxAge removes snapshots which haven't been used for longer than this.
+	MaxAge time.Duration
+	// MaxSize removes the least recently used snapshots until
+	// the total size of the store is at most this many bytes.
+	MaxSize int64
+	// DryRun only reports what would be removed.
+	DryRun bool
+}
+
+// GarbageCollect removes the snapshots selected by the policy
+// and returns them.
+func GarbageCollect(policy GCPolicy) ([]Snapshot, error) {
+	lock, err := LockStore(true)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+	snapshots, err := ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	containers, err := ListContainers()
+	if err != nil {
+		return nil, err
+	}
+	used, err := MountedLayers()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range containers {
+		for _, layer := range c.Layers {
+			used[layer] = true
+		}
+	}
+	var total int64
+	for _, s := range snapshots {
+		total += s.Size
+	}
+	// oldest first, so the size policy evicts the least recently used
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].LastUsed.Before(snapshots[j].LastUsed)
+	})
+	var removed []Snapshot
+	for _, s := range snapshots {
+		if used[s.Path] {
+			continue
+		}
+		expired := policy.MaxAge > 0 && time.Since(s.LastUsed) > policy.MaxAge
+		oversize := policy.MaxSize > 0 && total > policy.MaxSize
+		if !expired && !oversize {
+			continue
+		}
+		if !policy.DryRun {
+			if err := os.RemoveAll(s.Path); err != nil {
+				return removed, err
+			}
+			os.Remove(s.Path + ".lock")
+		}
+		total -= s.Size
+		removed = append(removed, s)
+	}
+	return removed, nil
+}
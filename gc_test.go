@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGarbageCollect(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	snapshot := func(name string, size int, age time.Duration) string {
+		dir, err := SnapshotDir("sha256:" + strings.Repeat(name, 64))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "data"), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		used := time.Now().Add(-age)
+		if err := os.Chtimes(dir, used, used); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	old := snapshot("a", 100, 48*time.Hour)
+	inuse := snapshot("b", 100, 72*time.Hour)
+	recent := snapshot("c", 100, time.Hour)
+	newest := snapshot("d", 100, time.Minute)
+	containers, err := ContainersDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(containers, "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteContainer(filepath.Join(containers, "x"), Container{ID: "x", Layers: []string{inuse}}); err != nil {
+		t.Fatal(err)
+	}
+	paths := func(snapshots []Snapshot) []string {
+		var p []string
+		for _, s := range snapshots {
+			p = append(p, s.Path)
+		}
+		return p
+	}
+	removed, err := GarbageCollect(GCPolicy{MaxAge: 24 * time.Hour, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := paths(removed); len(got) != 1 || got[0] != old {
+		t.Fatalf("max age: got %v, want [%s]", got, old)
+	}
+	removed, err = GarbageCollect(GCPolicy{MaxSize: 250})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := paths(removed); len(got) != 2 || got[0] != old || got[1] != recent {
+		t.Fatalf("max size: got %v, want [%s %s]", got, old, recent)
+	}
+	for _, dir := range []string{inuse, newest} {
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("expected %s to be kept: %v", dir, err)
+		}
+	}
+}
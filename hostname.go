@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// hostnameLabel matches one dot separated part of a hostname.
+var hostnameLabel = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+// ValidateHostname checks that the name can be used as the container's
+// hostname, which the kernel limits to 64 characters.
+func ValidateHostname(name string) error {
+	if name == "" || len(name) > 64 {
+		return fmt.Errorf("invalid hostname %q: must be 1 to 64 characters", name)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !hostnameLabel.MatchString(label) {
+			return fmt.Errorf("invalid hostname %q", name)
+		}
+	}
+	return nil
+}
+
+// InstallHostname writes the container's /etc/hostname.
+func InstallHostname(rootfs, name string) error {
+	etc, err := rootfsEtc(rootfs)
+	if err != nil {
+		return err
+	}
+	return writeNoFollow(filepath.Join(etc, "hostname"), strings.NewReader(name+"\n"))
+}
+
+// HostEntry is an extra /etc/hosts entry given with -add-host.
+type HostEntry struct {
+	Name    string
+	Address netip.Addr
+}
+
+// hostGateway is the -add-host address which stands for the host, like in docker.
+const hostGateway = "host-gateway"
+
+// ParseHostEntry parses an -add-host value like db:10.0.0.5, with IPv6
+// addresses optionally in brackets. host-gateway is replaced by gateway.
+func ParseHostEntry(s string, gateway netip.Addr) (HostEntry, error) {
+	name, addr, ok := strings.Cut(s, ":")
+	if !ok {
+		return HostEntry{}, fmt.Errorf("invalid host %q: expected name:ip", s)
+	}
+	if err := ValidateHostname(name); err != nil {
+		return HostEntry{}, err
+	}
+	if addr == hostGateway {
+		if !gateway.IsValid() {
+			return HostEntry{}, fmt.Errorf("invalid host %q: the network has no gateway", s)
+		}
+		return HostEntry{Name: name, Address: gateway}, nil
+	}
+	ip, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"))
+	if err != nil {
+		return HostEntry{}, fmt.Errorf("invalid host %q: %w", s, err)
+	}
+	return HostEntry{Name: name, Address: ip}, nil
+}
+
+// InstallHosts adds the container's hostname to /etc/hosts, along with
+// the extra entries. The hostname resolves to the container's address, or
+// to a loopback one when it doesn't have its own network.
+func InstallHosts(rootfs, hostname string, addr netip.Addr, extra []HostEntry) error {
+	etc, err := rootfsEtc(rootfs)
+	if err != nil {
+		return err
+	}
+	hostsPath := filepath.Join(etc, "hosts")
+	var hosts []byte
+	if info, err := os.Lstat(hostsPath); err == nil && info.Mode().IsRegular() {
+		if hosts, err = os.ReadFile(hostsPath); err != nil {
+			return err
+		}
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if len(hosts) == 0 {
+		hosts = []byte("127.0.0.1\tlocalhost\n::1\tlocalhost ip6-localhost ip6-loopback\n")
+	}
+	if len(hosts) > 0 && hosts[len(hosts)-1] != '\n' {
+		hosts = append(hosts, '\n')
+	}
+	if hostname != "" {
+		if !addr.IsValid() {
+			addr = netip.MustParseAddr("127.0.1.1")
+		}
+		hosts = fmt.Appendf(hosts, "%s\t%s\n", addr, hostname)
+	}
+	for _, e := range extra {
+		hosts = fmt.Appendf(hosts, "%s\t%s\n", e.Address, e.Name)
+	}
+	return writeNoFollow(hostsPath, bytes.NewReader(hosts))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateHostname(t *testing.T) {
+	for _, name := range []string{"box", "3f2a9c1e04b7", "web-1.example.com"} {
+		if err := ValidateHostname(name); err != nil {
+			t.Errorf("ValidateHostname(%q): %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "-box", "box-", "a..b", "under_score", strings.Repeat("a", 65)} {
+		if err := ValidateHostname(name); err == nil {
+			t.Errorf("expected %q to be rejected", name)
+		}
+	}
+}
+
+func TestInstallHostname(t *testing.T) {
+	rootfs := t.TempDir()
+	if err := os.Mkdir(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rootfs, "etc", "hosts"), []byte("127.0.0.1 localhost"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := InstallHostname(rootfs, "box"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(rootfs, "etc", "hostname")); string(data) != "box\n" {
+		t.Errorf("unexpected /etc/hostname: %q", data)
+	}
+}
+
+func TestInstallHosts(t *testing.T) {
+	rootfs := t.TempDir()
+	if err := os.Mkdir(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rootfs, "etc", "hosts"), []byte("127.0.0.1 localhost"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db := HostEntry{Name: "db", Address: netip.MustParseAddr("10.0.0.5")}
+	if err := InstallHosts(rootfs, "box", netip.Addr{}, []HostEntry{db}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(rootfs, "etc", "hosts")); string(data) != "127.0.0.1 localhost\n127.0.1.1\tbox\n10.0.0.5\tdb\n" {
+		t.Errorf("unexpected /etc/hosts: %q", data)
+	}
+	// a networked container's hostname resolves to its address
+	empty := t.TempDir()
+	if err := InstallHosts(empty, "box", netip.MustParseAddr("172.29.0.2"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(empty, "etc", "hosts")); !strings.HasSuffix(string(data), "ip6-loopback\n172.29.0.2\tbox\n") {
+		t.Errorf("unexpected /etc/hosts: %q", data)
+	}
+}
+
+func TestParseHostEntry(t *testing.T) {
+	gateway := netip.MustParseAddr("172.29.0.1")
+	tests := []struct {
+		value string
+		want  HostEntry
+	}{
+		{"db:10.0.0.5", HostEntry{Name: "db", Address: netip.MustParseAddr("10.0.0.5")}},
+		{"v6:[fd00::1]", HostEntry{Name: "v6", Address: netip.MustParseAddr("fd00::1")}},
+		{"v6:fd00::1", HostEntry{Name: "v6", Address: netip.MustParseAddr("fd00::1")}},
+		{"host.docker.internal:host-gateway", HostEntry{Name: "host.docker.internal", Address: gateway}},
+	}
+	for _, tt := range tests {
+		got, err := ParseHostEntry(tt.value, gateway)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.value, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+	for _, value := range []string{"db", "db:", "-db:10.0.0.5", "db:nope"} {
+		if _, err := ParseHostEntry(value, gateway); err == nil {
+			t.Errorf("%q: expected error", value)
+		}
+	}
+	if _, err := ParseHostEntry("gw:host-gateway", netip.Addr{}); err == nil {
+		t.Error("expected host-gateway to fail without a gateway")
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+// PullImage resolves the image against its registry and fetches anything
+// missing from the local cache. It returns the digest of the platform
+// manifest that was pulled.
+func PullImage(ctx context.Context, image string) (string, error) {
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	lock, err := LockStore(false)
+	if err != nil {
+		return "", err
+	}
+	defer lock.Unlock()
+	if _, err := FetchImageSnapshots(ctx, ref, PullAlways); err != nil {
+		return "", err
+	}
+	rec, err := ReadImageRecord(ref)
+	if err != nil {
+		return "", err
+	}
+	return rec.Manifest, nil
+}
+
+// UnpackImage materializes the image's rootfs into dir by copying its
+// layer snapshots on top of each other and applying their whiteouts.
+func UnpackImage(image, dir string) error {
+	lock, err := LockStore(false)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return err
+	}
+	layers, err := FetchImageSnapshots(context.Background(), ref, PullMissing)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		if err := applyLayer(layer, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImageShell runs a shell chrooted into a throwaway copy of the image without
+// networking. Nothing is tracked and all changes are discarded on exit.
+func ImageShell(image, shell string) error {
+	lock, err := LockStore(false)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return err
+	}
+	layers, err := FetchImageSnapshots(context.Background(), ref, PullMissing)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp("", "shell-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	rootfs := filepath.Join(tmp, "rootfs")
+	upper := filepath.Join(tmp, "upper")
+	work := filepath.Join(tmp, "work")
+	for _, dir := range []string{rootfs, upper, work} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := MountOverlay(layers, upper, work, rootfs); err != nil {
+		return err
+	}
+	defer Unmount(rootfs)
+	cmd, err := InitCommand(ContainerConfig{
+		Rootfs:         rootfs,
+		Args:           []string{shell},
+		Env:            []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"},
+		Dir:            "/",
+		Umask:          0022,
+		MountNamespace: true,
+	}, syscall.CLONE_NEWPID|syscall.CLONE_NEWIPC|syscall.CLONE_NEWNET|syscall.CLONE_NEWNS)
+	if err != nil {
+		return err
+	}
+	return cmd.Run()
+}
+
+// MountImage mounts the image's rootfs read-only at dir so host tools can
+// inspect it. It stays mounted until it's unmounted with UnmountImage.
+func MountImage(image, dir string) error {
+	lock, err := LockStore(false)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return err
+	}
+	layers, err := FetchImageSnapshots(context.Background(), ref, PullMissing)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return MountReadOnly(layers, dir)
+}
+
+// UnmountImage unmounts an image mounted with MountImage.
+func UnmountImage(dir string) error {
+	if err := Unmount(dir); err != nil {
+		return fmt.Errorf("failed to unmount %s: %w", dir, err)
+	}
+	return nil
+}
+
+// ExportImage writes the image's flattened rootfs to the output file,
+// either as a tar archive or as a squashfs filesystem image.
+// Tar archives are written to stdout when output is "-".
+func ExportImage(image, format, output string, opts ExportOptions) error {
+	if format != "tar" && format != "squashfs" {
+		return fmt.Errorf("unknown export format: %s", format)
+	}
+	if output == "-" {
+		if format != "tar" {
+			return fmt.Errorf("%s export can't be streamed, it needs an output file", format)
+		}
+		if isTerminal(os.Stdout) {
+			return fmt.Errorf("refusing to write a tar archive to a terminal, use -o or redirect stdout")
+		}
+	}
+	lock, err := LockStore(false)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return err
+	}
+	layers, err := FetchImageSnapshots(context.Background(), ref, PullMissing)
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "export-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dir)
+	if err := MountReadOnly(layers, dir); err != nil {
+		return err
+	}
+	defer Unmount(dir)
+	if format == "squashfs" {
+		// NOTE: shelling out since there's nothing in the stdlib for writing squashfs
+		args := []string{dir, output, "-noappend", "-quiet"}
+		if opts.Reproducible {
+			var mkfsTime int64
+			if !opts.ModTime.IsZero() {
+				mkfsTime = opts.ModTime.Unix()
+				args = append(args, "-all-time", fmt.Sprint(mkfsTime))
+			}
+			args = append(args, "-mkfs-time", fmt.Sprint(mkfsTime))
+		}
+		cmd := exec.Command("mksquashfs", args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("mksquashfs: %w", err)
+		}
+		return nil
+	}
+	return writeTarFile(output, dir, opts)
+}
+
+// writeTarFile writes the tree at root to the output file, or stdout for "-".
+func writeTarFile(output, root string, opts ExportOptions) error {
+	if output == "-" {
+		return WriteTar(os.Stdout, root, opts)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := WriteTar(f, root, opts); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// ExportContainer writes the container's filesystem, the image with the
+// container's changes on top, to the output as a tar archive. The writable
+// layer is stacked read-only over the image, so this also works while
+// the container is running.
+func ExportContainer(id, output string, opts ExportOptions) error {
+	lock, err := LockStore(false)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	c, dir, err := FindContainer(id)
+	if err != nil {
+		return err
+	}
+	if output == "-" && isTerminal(os.Stdout) {
+		return fmt.Errorf("refusing to write a tar archive to a terminal, use -o or redirect stdout")
+	}
+	mnt, err := os.MkdirTemp("", "export-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(mnt)
+	layers := append(c.Layers[:len(c.Layers):len(c.Layers)], filepath.Join(dir, "upper"))
+	if err := MountReadOnly(layers, mnt); err != nil {
+		return err
+	}
+	defer Unmount(mnt)
+	return writeTarFile(output, mnt, opts)
+}
+
+// isTerminal reports whether f is a character device like a tty.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
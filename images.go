@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+// LocalImage is a reference in the local image index.
+type LocalImage struct {
+	Repository string `json:"repository"`
+	// Tag is empty for images pulled by digest.
+	Tag string `json:"tag"`
+	// Digest is the digest of the platform manifest.
+	Digest   string    `json:"digest"`
+	Platform string    `json:"platform"`
+	Size     int64     `json:"size"`
+	Created  time.Time `json:"created"`
+}
+
+// ListImages returns every image in the local index. The size is the
+// space used by the image's layer snapshots, so layers which are shared
+// between images are counted for each of them.
+func ListImages() ([]LocalImage, error) {
+	snapshots, err := ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	sizes := map[string]int64{}
+	for _, s := range snapshots {
+		sizes[s.Path] = s.Size
+	}
+	var images []LocalImage
+	err = walkImageRecords(func(ref Reference) error {
+		image, _, err := readLocalImageInfo(ref, sizes)
+		if err != nil {
+			return fmt.Errorf("%s: %w", ref, err)
+		}
+		images = append(images, image)
+		return nil
+	})
+	return images, err
+}
+
+// walkImageRecords calls fn with the reference of every record in the index.
+func walkImageRecords(fn func(ref Reference) error) error {
+	root, err := DataRoot()
+	if err != nil {
+		return err
+	}
+	index := filepath.Join(root, "images")
+	err = filepath.WalkDir(index, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".json") {
+			return err
+		}
+		rel, _ := filepath.Rel(index, strings.TrimSuffix(path, ".json"))
+		ref, ok := imageRecordReference(filepath.ToSlash(rel))
+		if !ok {
+			return nil
+		}
+		return fn(ref)
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// imageRecordReference is the inverse of imageRecordPath, it turns the
+// slash separated path of a record relative to the index into the
+// reference it was written for.
+func imageRecordReference(rel string) (Reference, bool) {
+	parts := strings.Split(rel, "/")
+	if len(parts) < 3 {
+		return Reference{}, false
+	}
+	n := len(parts)
+	ref := Reference{
+		Repository: Repository{
+			Registry: parts[0],
+			Library:  strings.Join(parts[1:n-2], "/"),
+			Image:    parts[n-2],
+		},
+		Tag: parts[n-1],
+	}
+	if isHexDigest(ref.Tag) {
+		ref.Digest, ref.Tag = "sha256:"+ref.Tag, ""
+	}
+	return ref, true
+}
+
+// isHexDigest reports whether s looks like the hex part of a sha256 digest.
+func isHexDigest(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// readLocalImageInfo reads the record, manifest and config of a reference
+// in the index.
+func readLocalImageInfo(ref Reference, sizes map[string]int64) (LocalImage, ImageConfig, error) {
+	rec, err := ReadImageRecord(ref)
+	if err != nil {
+		return LocalImage{}, ImageConfig{}, err
+	}
+	image := LocalImage{
+		Repository: ref.Repository.String(),
+		Tag:        ref.Tag,
+		Digest:     rec.Manifest,
+		Platform:   rec.Platform,
+	}
+	if image.Platform == "" {
+		image.Platform = registry.HostPlatform().String()
+	}
+	var m ImageManifest
+	if err := readBlobJSON(rec.Manifest, &m); err != nil {
+		return LocalImage{}, ImageConfig{}, err
+	}
+	var config ImageConfig
+	if err := readBlobJSON(m.Config.Digest, &config); err != nil {
+		return LocalImage{}, ImageConfig{}, err
+	}
+	image.Created = config.Created
+	dirs, err := imageSnapshotDirs(m, config)
+	if err != nil {
+		return LocalImage{}, ImageConfig{}, err
+	}
+	for _, dir := range dirs {
+		image.Size += sizes[dir]
+	}
+	return image, config, nil
+}
+
+// WriteImageList writes the images as a table like docker images, newest first.
+func WriteImageList(w io.Writer, images []LocalImage, now time.Time) error {
+	sortImages(images)
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tTAG\tDIGEST\tCREATED\tSIZE")
+	for _, image := range images {
+		tag := image.Tag
+		if tag == "" {
+			tag = "<none>"
+		}
+		_, digest, _ := strings.Cut(image.Digest, ":")
+		created := "Unknown"
+		if !image.Created.IsZero() {
+			created = HumanDuration(now.Sub(image.Created)) + " ago"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", image.Repository, tag, digest[:min(12, len(digest))], created, HumanSize(image.Size))
+	}
+	return tw.Flush()
+}
+
+// WriteImageListJSON writes the images as one JSON object per line, newest first.
+func WriteImageListJSON(w io.Writer, images []LocalImage) error {
+	sortImages(images)
+	enc := json.NewEncoder(w)
+	for _, image := range images {
+		if err := enc.Encode(image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortImages sorts the images newest first, and then by name.
+func sortImages(images []LocalImage) {
+	sort.Slice(images, func(i, j int) bool {
+		a, b := images[i], images[j]
+		if !a.Created.Equal(b.Created) {
+			return a.Created.After(b.Created)
+		}
+		if a.Repository != b.Repository {
+			return a.Repository < b.Repository
+		}
+		return a.Tag < b.Tag
+	})
+}
+
+// imageData is what the images in the index keep in the store.
+type imageData struct {
+	// Blobs are the digests of the manifests and configs.
+	Blobs map[string]bool
+	// Snapshots are the snapshot directories of the layers.
+	Snapshots map[string]bool
+}
+
+// usedImageData returns what's used by the images in the index. Records
+// whose blobs are missing don't use anything else.
+func usedImageData() (imageData, error) {
+	used := imageData{Blobs: map[string]bool{}, Snapshots: map[string]bool{}}
+	err := walkImageRecords(func(ref Reference) error {
+		rec, err := ReadImageRecord(ref)
+		if err != nil {
+			return err
+		}
+		blobs, dirs := readImageData(rec)
+		for _, digest := range blobs {
+			used.Blobs[digest] = true
+		}
+		for _, dir := range dirs {
+			used.Snapshots[dir] = true
+		}
+		return nil
+	})
+	return used, err
+}
+
+// readImageData returns the blobs and snapshot directories of the record,
+// as far as they can be read.
+func readImageData(rec ImageRecord) (blobs, dirs []string) {
+	blobs = []string{rec.Manifest}
+	var m ImageManifest
+	if err := readBlobJSON(rec.Manifest, &m); err != nil {
+		return blobs, nil
+	}
+	blobs = append(blobs, m.Config.Digest)
+	var config ImageConfig
+	if err := readBlobJSON(m.Config.Digest, &config); err != nil {
+		return blobs, nil
+	}
+	dirs, _ = imageSnapshotDirs(m, config)
+	return blobs, dirs
+}
+
+// RemoveReport lists what RemoveImage deleted.
+type RemoveReport struct {
+	Snapshots []string
+	Blobs     []string
+	Reclaimed int64
+}
+
+// RemoveImage removes the reference from the index, along with the
+// snapshots and blobs of the image which aren't used by another image,
+// a container or image mount.
+func RemoveImage(ref Reference) (RemoveReport, error) {
+	var report RemoveReport
+	lock, err := LockStore(true)
+	if err != nil {
+		return report, err
+	}
+	defer lock.Unlock()
+	p, err := imageRecordPath(ref)
+	if err != nil {
+		return report, err
+	}
+	rec, err := ReadImageRecord(ref)
+	if errors.Is(err, fs.ErrNotExist) {
+		return report, fmt.Errorf("no such image: %s", ref)
+	}
+	if err != nil {
+		return report, err
+	}
+	if err := os.Remove(p); err != nil {
+		return report, err
+	}
+	used, err := usedImageData()
+	if err != nil {
+		return report, err
+	}
+	mounted, err := MountedLayers()
+	if err != nil {
+		return report, err
+	}
+	containers, err := ListContainers()
+	if err != nil {
+		return report, err
+	}
+	for _, c := range containers {
+		for _, layer := range c.Layers {
+			mounted[layer] = true
+		}
+	}
+	blobs, dirs := readImageData(rec)
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); used.Snapshots[dir] || mounted[dir] || errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		size, err := removeAll(dir)
+		if err != nil {
+			return report, err
+		}
+		os.Remove(dir + ".lock")
+		report.Snapshots = append(report.Snapshots, "sha256:"+filepath.Base(dir))
+		report.Reclaimed += size
+	}
+	for _, digest := range blobs {
+		if used.Blobs[digest] {
+			continue
+		}
+		bp, err := BlobPath(digest)
+		if err != nil {
+			return report, err
+		}
+		if _, err := os.Stat(bp); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		size, err := removeAll(bp)
+		if err != nil {
+			return report, err
+		}
+		report.Blobs = append(report.Blobs, digest)
+		report.Reclaimed += size
+	}
+	return report, nil
+}
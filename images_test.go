@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+func TestListImages(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	manifestDigest := writeTestImage(t, created, []string{"alpine:3.19", "ghcr.io/owner/image@sha256:" + strings.Repeat("3", 64)})
+	images, err := ListImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortImages(images)
+	want := []LocalImage{
+		{Repository: "alpine", Tag: "3.19", Digest: manifestDigest, Platform: registry.HostPlatform().String(), Size: 1000, Created: created},
+		{Repository: "ghcr.io/owner/image", Digest: manifestDigest, Platform: registry.HostPlatform().String(), Size: 1000, Created: created},
+	}
+	if len(images) != len(want) {
+		t.Fatalf("expected %d images, got %+v", len(want), images)
+	}
+	for i := range want {
+		if images[i] != want[i] {
+			t.Errorf("image %d: got %+v, want %+v", i, images[i], want[i])
+		}
+	}
+	var b strings.Builder
+	if err := WriteImageList(&b, images, created.Add(48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a header and 2 images, got:\n%s", b.String())
+	}
+	if fields := strings.Fields(lines[2]); fields[1] != "<none>" || fields[2] != manifestDigest[7:19] {
+		t.Errorf("unexpected digest image row: %q", lines[2])
+	}
+}
+
+// writeTestImage adds an image to the store under the references, with
+// one 1000 byte snapshot for each of the diff ids and returns the
+// manifest digest.
+func writeTestImage(t *testing.T, created time.Time, refs []string, diffIDs ...string) string {
+	t.Helper()
+	if len(diffIDs) == 0 {
+		diffIDs = []string{"sha256:" + strings.Repeat("1", 64)}
+	}
+	config, _ := json.Marshal(map[string]any{
+		"created": created,
+		"rootfs":  map[string]any{"type": "layers", "diff_ids": diffIDs},
+	})
+	configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(config))
+	var layers []Layer
+	for _, diffID := range diffIDs {
+		layers = append(layers, Layer{MediaType: registry.MediaTypeOCILayerGzip, Digest: diffID})
+		dir, err := SnapshotDir(diffID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "file"), make([]byte, 1000), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest, _ := json.Marshal(ImageManifest{
+		Config: Layer{MediaType: registry.MediaTypeOCIConfig, Digest: configDigest},
+		Layers: layers,
+	})
+	manifestDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+	for digest, data := range map[string][]byte{configDigest: config, manifestDigest: manifest} {
+		if err := storeBlob(digest, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, s := range refs {
+		ref, err := registry.ParseReference(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteImageRecord(ref, ImageRecord{Manifest: manifestDigest}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return manifestDigest
+}
+
+func TestRemoveImage(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	shared := "sha256:" + strings.Repeat("1", 64)
+	own := "sha256:" + strings.Repeat("2", 64)
+	writeTestImage(t, time.Time{}, []string{"alpine:3.19"}, shared)
+	digest := writeTestImage(t, time.Time{}, []string{"nginx:latest", "nginx:1.25"}, shared, own)
+	ref, _ := registry.ParseReference("nginx:latest")
+	report, err := RemoveImage(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Snapshots) != 0 || len(report.Blobs) != 0 {
+		t.Fatalf("expected nginx:1.25 to keep the image, got %+v", report)
+	}
+	if _, err := RemoveImage(ref); err == nil || !strings.Contains(err.Error(), "no such image") {
+		t.Fatalf("expected no such image, got %v", err)
+	}
+	ref, _ = registry.ParseReference("nginx:1.25")
+	report, err = RemoveImage(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Snapshots) != 1 || report.Snapshots[0] != own {
+		t.Errorf("expected only the unshared snapshot to be removed, got %v", report.Snapshots)
+	}
+	if len(report.Blobs) != 2 || report.Blobs[0] != digest {
+		t.Errorf("expected the manifest and config to be removed, got %v", report.Blobs)
+	}
+	if dirs, err := LocalImageSnapshots(registry.Reference{Repository: registry.Repository{Registry: registry.DockerHub, Library: "library", Image: "alpine"}, Tag: "3.19"}); err != nil || len(dirs) != 1 {
+		t.Errorf("expected alpine to be untouched: %v %v", dirs, err)
+	}
+}
+
+func TestSystemPruneBlobs(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	writeTestImage(t, time.Time{}, []string{"alpine:3.19"})
+	unused := []byte("unused")
+	unusedDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(unused))
+	if err := storeBlob(unusedDigest, unused); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ContainersDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "leftover", "rootfs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	report, err := SystemPrune(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Blobs) != 1 || report.Blobs[0] != unusedDigest {
+		t.Errorf("expected only the unused blob to be removed, got %v", report.Blobs)
+	}
+	if len(report.Containers) != 1 || report.Containers[0] != "leftover" {
+		t.Errorf("expected the leftover container to be removed, got %v", report.Containers)
+	}
+	images, err := ListImages()
+	if err != nil || len(images) != 1 {
+		t.Errorf("expected alpine to be kept: %v %v", images, err)
+	}
+}
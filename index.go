@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+// PullPolicy decides when an image is pulled instead of using the local copy.
+type PullPolicy string
+
+const (
+	// PullAlways resolves the reference against the registry every time.
+	PullAlways PullPolicy = "always"
+	// PullMissing only goes to the registry when the image isn't local.
+	PullMissing PullPolicy = "missing"
+	// PullNever fails when the image isn't local.
+	PullNever PullPolicy = "never"
+)
+
+// ParsePullPolicy parses a -pull value.
+func ParsePullPolicy(s string) (PullPolicy, error) {
+	switch p := PullPolicy(s); p {
+	case PullAlways, PullMissing, PullNever:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid pull policy %q: expected always, missing or never", s)
+}
+
+// ImageRecord is the local index entry for a pulled reference.
+type ImageRecord struct {
+	// Manifest is the digest of the platform manifest. The manifest
+	// and the image config are kept in the blob store.
+	Manifest string    `json:"manifest"`
+	PulledAt time.Time `json:"pulledAt"`
+	// Platform is what the image was pulled for, records without
+	// one are from before -platform and are for the host.
+	Platform string `json:"platform,omitempty"`
+}
+
+// imageRecordPath returns where the reference's index entry is kept.
+func imageRecordPath(ref Reference) (string, error) {
+	root, err := DataRoot()
+	if err != nil {
+		return "", err
+	}
+	name := ref.Tag
+	if ref.Digest != "" {
+		_, name, _ = strings.Cut(ref.Digest, ":")
+	}
+	return filepath.Join(root, "images", ref.Registry, filepath.FromSlash(ref.Path()), name+".json"), nil
+}
+
+// ReadImageRecord returns the index entry for the reference.
+func ReadImageRecord(ref Reference) (ImageRecord, error) {
+	var rec ImageRecord
+	p, err := imageRecordPath(ref)
+	if err != nil {
+		return rec, err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return rec, err
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, fmt.Errorf("invalid image record %s: %w", p, err)
+	}
+	return rec, nil
+}
+
+// WriteImageRecord updates the index entry for the reference.
+func WriteImageRecord(ref Reference, rec ImageRecord) error {
+	p, err := imageRecordPath(ref)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return WriteFileAtomic(p, data, 0644)
+}
+
+// storeBlob adds a blob which has already been downloaded to the blob store.
+func storeBlob(digest string, data []byte) error {
+	if err := registry.VerifyDigest(data, digest); err != nil {
+		return err
+	}
+	p, err := BlobPath(digest)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(p); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return WriteFileAtomic(p, data, 0644)
+}
+
+// readBlobJSON decodes a blob from the blob store.
+func readBlobJSON(digest string, v any) error {
+	p, err := BlobPath(digest)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// errNotLocal is returned when an image can't be used without pulling it.
+var errNotLocal = errors.New("image isn't available locally")
+
+// readLocalImage returns the manifest and config of a previously pulled image.
+func readLocalImage(ref Reference) (ImageManifest, ImageConfig, error) {
+	rec, err := ReadImageRecord(ref)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ImageManifest{}, ImageConfig{}, errNotLocal
+	}
+	if err != nil {
+		return ImageManifest{}, ImageConfig{}, err
+	}
+	if rec.Platform == "" {
+		rec.Platform = registry.HostPlatform().String()
+	}
+	// the reference names a single local image, like in docker, so
+	// pulling it for another platform replaces it.
+	if rec.Platform != pullPlatform.String() {
+		return ImageManifest{}, ImageConfig{}, fmt.Errorf("%w: it was pulled for %s", errNotLocal, rec.Platform)
+	}
+	var m ImageManifest
+	if err := readBlobJSON(rec.Manifest, &m); err != nil {
+		return ImageManifest{}, ImageConfig{}, fmt.Errorf("%w: %v", errNotLocal, err)
+	}
+	var config ImageConfig
+	if err := readBlobJSON(m.Config.Digest, &config); err != nil {
+		return ImageManifest{}, ImageConfig{}, fmt.Errorf("%w: %v", errNotLocal, err)
+	}
+	return m, config, nil
+}
+
+// ReadImageConfig returns the config of a previously pulled image.
+func ReadImageConfig(ref Reference) (ImageConfig, error) {
+	_, config, err := readLocalImage(ref)
+	return config, err
+}
+
+// LocalImageSnapshots returns the snapshot directories of a previously
+// pulled image without using the network.
+func LocalImageSnapshots(ref Reference) ([]string, error) {
+	m, config, err := readLocalImage(ref)
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := imageSnapshotDirs(m, config)
+	if err != nil {
+		return nil, err
+	}
+	// the snapshot's mtime records when it was last used, for gc
+	now := time.Now()
+	for _, dir := range dirs {
+		if err := os.Chtimes(dir, now, now); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("%w: layer snapshot %s was removed", errNotLocal, filepath.Base(dir))
+			}
+			return nil, err
+		}
+	}
+	return dirs, nil
+}
+
+// imageSnapshotDirs returns the snapshot directory of each layer in the image.
+func imageSnapshotDirs(m ImageManifest, config ImageConfig) ([]string, error) {
+	if len(config.RootFS.DiffIDs) != len(m.Layers) {
+		return nil, fmt.Errorf("image config has %d diff ids for %d layers", len(config.RootFS.DiffIDs), len(m.Layers))
+	}
+	dirs := make([]string, len(m.Layers))
+	for i := range m.Layers {
+		dir, err := SnapshotDir(config.RootFS.DiffIDs[i])
+		if err != nil {
+			return nil, err
+		}
+		dirs[i] = dir
+	}
+	return dirs, nil
+}
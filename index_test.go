@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+func TestLocalImageSnapshots(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ref, err := registry.ParseReference("alpine:3.19")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FetchImageSnapshots(context.Background(), ref, PullNever); !errors.Is(err, errNotLocal) {
+		t.Fatalf("expected the image to be missing, got %v", err)
+	}
+	diffID := "sha256:" + strings.Repeat("1", 64)
+	config, _ := json.Marshal(map[string]any{"rootfs": map[string]any{"type": "layers", "diff_ids": []string{diffID}}})
+	configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(config))
+	manifest, _ := json.Marshal(ImageManifest{
+		Config: Layer{Digest: configDigest},
+		Layers: []Layer{{Digest: "sha256:" + strings.Repeat("2", 64)}},
+	})
+	manifestDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+	for digest, data := range map[string][]byte{configDigest: config, manifestDigest: manifest} {
+		if err := storeBlob(digest, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storeBlob(manifestDigest, config); err == nil {
+		t.Fatal("expected a blob which doesn't match its digest to be rejected")
+	}
+	if err := WriteImageRecord(ref, ImageRecord{Manifest: manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+	// the snapshot hasn't been extracted yet
+	if _, err := LocalImageSnapshots(ref); !errors.Is(err, errNotLocal) {
+		t.Fatalf("expected the image to be incomplete, got %v", err)
+	}
+	dir, err := SnapshotDir(diffID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	dirs, err := FetchImageSnapshots(context.Background(), ref, PullNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 || dirs[0] != dir {
+		t.Fatalf("unexpected snapshots: %v", dirs)
+	}
+	defer func(p Platform) { pullPlatform = p }(pullPlatform)
+	pullPlatform = Platform{OS: "linux", Architecture: "s390x"}
+	if _, err := LocalImageSnapshots(ref); !errors.Is(err, errNotLocal) {
+		t.Fatalf("expected an image pulled for another platform to be missing, got %v", err)
+	}
+	pullPlatform = registry.HostPlatform()
+	other, _ := registry.ParseReference("alpine:3.20")
+	if _, err := LocalImageSnapshots(other); !errors.Is(err, errNotLocal) {
+		t.Fatalf("expected other tags to be missing, got %v", err)
+	}
+}
+
+func TestParsePullPolicy(t *testing.T) {
+	for _, s := range []string{"always", "missing", "never"} {
+		if p, err := ParsePullPolicy(s); err != nil || string(p) != s {
+			t.Errorf("ParsePullPolicy(%q) = %q, %v", s, p, err)
+		}
+	}
+	if _, err := ParsePullPolicy("sometimes"); err == nil {
+		t.Error("expected an invalid policy to be rejected")
+	}
+}
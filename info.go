@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// HostInfo is what shittydocker detects about the host it's running on.
+type HostInfo struct {
+	KernelVersion  string
+	CgroupVersion  string
+	Overlayfs      bool
+	UserNamespaces bool
+	Seccomp        bool
+	StorageDriver  string
+	DataRoot       string
+	Snapshots      int
+	Containers     int
+	Running        int
+	Runtimes       []string
+	Network        string
+}
+
+// SystemInfo collects the host info.
+func SystemInfo() (HostInfo, error) {
+	info := HostInfo{
+		StorageDriver: "overlay",
+		Runtimes:      []string{"native"},
+		// containers share the host's network namespace
+		Network: "host",
+	}
+	release, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return info, err
+	}
+	info.KernelVersion = strings.TrimSpace(string(release))
+	switch {
+	case CgroupsAvailable():
+		info.CgroupVersion = "2"
+	case exists("/sys/fs/cgroup/memory"):
+		info.CgroupVersion = "1 (unsupported)"
+	default:
+		info.CgroupVersion = "none"
+	}
+	if data, err := os.ReadFile("/proc/filesystems"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.TrimSpace(strings.TrimPrefix(line, "nodev")) == "overlay" {
+				info.Overlayfs = true
+			}
+		}
+	}
+	if data, err := os.ReadFile("/proc/sys/user/max_user_namespaces"); err == nil {
+		info.UserNamespaces = strings.TrimSpace(string(data)) != "0"
+	}
+	if data, err := os.ReadFile("/proc/self/status"); err == nil {
+		info.Seccomp = bytes.Contains(data, []byte("\nSeccomp:"))
+	}
+	if _, err := exec.LookPath("runsc"); err == nil {
+		info.Runtimes = append(info.Runtimes, "runsc")
+	}
+	root, err := DataRoot()
+	if err != nil {
+		return info, err
+	}
+	info.DataRoot = root
+	snapshots, err := ListSnapshots()
+	if err != nil {
+		return info, err
+	}
+	info.Snapshots = len(snapshots)
+	report, err := SystemDiskUsage()
+	if err != nil {
+		return info, err
+	}
+	info.Containers = len(report.Containers)
+	for _, c := range report.Containers {
+		if c.Active {
+			info.Running++
+		}
+	}
+	return info, nil
+}
+
+// Write prints the info as a list of key value pairs.
+func (info HostInfo) Write(w io.Writer) {
+	fmt.Fprintf(w, "Kernel Version: %s\n", info.KernelVersion)
+	fmt.Fprintf(w, "Cgroup Version: %s\n", info.CgroupVersion)
+	fmt.Fprintf(w, "Overlayfs: %s\n", supported(info.Overlayfs))
+	fmt.Fprintf(w, "User Namespaces: %s\n", supported(info.UserNamespaces))
+	fmt.Fprintf(w, "Seccomp: %s\n", supported(info.Seccomp))
+	fmt.Fprintf(w, "Storage Driver: %s\n", info.StorageDriver)
+	fmt.Fprintf(w, "Data Root: %s\n", info.DataRoot)
+	fmt.Fprintf(w, "Snapshots: %d\n", info.Snapshots)
+	fmt.Fprintf(w, "Containers: %d (running: %d)\n", info.Containers, info.Running)
+	fmt.Fprintf(w, "Runtimes: %s\n", strings.Join(info.Runtimes, " "))
+	fmt.Fprintf(w, "Network: %s\n", info.Network)
+}
+
+func supported(ok bool) string {
+	if ok {
+		return "supported"
+	}
+	return "not available"
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// initArg0 is the argv[0] used when shittydocker re-executes itself
+// as the container's init process.
+const initArg0 = "shittydocker-init"
+
+// initConfigEnv is the environment variable used to pass the
+// ContainerConfig to the init process.
+const initConfigEnv = "_SHITTYDOCKER_CONFIG"
+
+// ContainerConfig is everything the init process needs to set up the
+// container from the inside before it execs the command.
+type ContainerConfig struct {
+	Rootfs  string            `json:"rootfs"`
+	Args    []string          `json:"args"`
+	Env     []string          `json:"env"`
+	Dir     string            `json:"dir"`
+	User    *User             `json:"user,omitempty"`
+	Sysctls map[string]string `json:"sysctls,omitempty"`
+	Umask   uint32            `json:"umask"`
+	// Keyring is the name of the session keyring created for the container.
+	Keyring string `json:"keyring,omitempty"`
+	// Sync makes the init process wait for the parent to finish setting
+	// up the container from the outside before running the command.
+	Sync bool `json:"sync,omitempty"`
+	// Hostname is set in the container's uts namespace.
+	Hostname string `json:"hostname,omitempty"`
+	// Binds are mounted into the rootfs, which requires MountNamespace.
+	Binds []BindMount `json:"binds,omitempty"`
+	// ShmSize is the size of /dev/shm, which is mounted along with the
+	// rest of /dev when there's a MountNamespace.
+	ShmSize int64 `json:"shmSize,omitempty"`
+	// Tmpfs are mounted into the rootfs after the binds, which requires MountNamespace.
+	Tmpfs []TmpfsMount `json:"tmpfs,omitempty"`
+	// ReadOnly mounts the rootfs read-only, which requires MountNamespace.
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// Capabilities are the only ones the command is left with.
+	Capabilities []string `json:"capabilities"`
+	// Seccomp is the compiled seccomp filter, if any.
+	Seccomp []byte `json:"seccomp,omitempty"`
+	// NoNewPrivileges sets no_new_privs, so setuid binaries and file
+	// capabilities don't give the command more than it started with.
+	NoNewPrivileges bool `json:"noNewPrivileges,omitempty"`
+	// MaskedPaths and ReadonlyPaths restrict paths in /proc and /sys,
+	// which requires MountNamespace.
+	MaskedPaths   []string `json:"maskedPaths,omitempty"`
+	ReadonlyPaths []string `json:"readonlyPaths,omitempty"`
+	// Init keeps init running as the command's parent, reaping zombies
+	// and forwarding signals, instead of exec'ing the command.
+	Init bool `json:"init,omitempty"`
+	// MountNamespace is set when init runs in its own mount namespace, in
+	// which case it pivots into the rootfs and mounts /proc instead of
+	// just chrooting.
+	MountNamespace bool `json:"mountNamespace,omitempty"`
+}
+
+// prSetNoNewPrivs is PR_SET_NO_NEW_PRIVS from linux/prctl.h
+const prSetNoNewPrivs = 38
+
+// syncFD is the file descriptor of the pipe used for Sync, the first one
+// after stdio in ExtraFiles.
+const syncFD = 3
+
+// InitCommand returns a command which re-executes shittydocker in new
+// namespaces to run the container described by cfg.
+func InitCommand(cfg ContainerConfig, cloneflags uintptr) (*exec.Cmd, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &exec.Cmd{
+		Path: "/proc/self/exe",
+		Args: []string{initArg0},
+		Env:  []string{initConfigEnv + "=" + string(data)},
+		SysProcAttr: &syscall.SysProcAttr{
+			Cloneflags: cloneflags,
+		},
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		Stdin:  os.Stdin,
+	}, nil
+}
+
+// Init runs inside the container's namespaces. It does the setup which can't
+// be expressed with SysProcAttr and then replaces itself with the command.
+func Init() error {
+	var cfg ContainerConfig
+	if err := json.Unmarshal([]byte(os.Getenv(initConfigEnv)), &cfg); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	// the parent writes a byte once it's done, and closing the
+	// pipe without writing anything means the setup failed.
+	if cfg.Sync {
+		pipe := os.NewFile(syncFD, "sync")
+		var b [1]byte
+		_, err := pipe.Read(b[:])
+		pipe.Close()
+		if err != nil {
+			return fmt.Errorf("container setup failed")
+		}
+	}
+	// set first so an explicit kernel.hostname sysctl still wins
+	if cfg.Hostname != "" {
+		if err := syscall.Sethostname([]byte(cfg.Hostname)); err != nil {
+			return fmt.Errorf("sethostname: %w", err)
+		}
+	}
+	// sysctls have to be written before chroot, while the host's /proc
+	// is still reachable. The namespaced ones only affect this container.
+	for key, value := range cfg.Sysctls {
+		name := filepath.Join("/proc/sys", strings.ReplaceAll(key, ".", "/"))
+		if err := os.WriteFile(name, []byte(value), 0644); err != nil {
+			return fmt.Errorf("failed to set sysctl %s: %w", key, err)
+		}
+	}
+	// a fresh session keyring keeps the processes in the container from
+	// seeing keys belonging to the host's or other containers' sessions.
+	if cfg.Keyring != "" {
+		if err := joinSessionKeyring(cfg.Keyring); err != nil && err != syscall.ENOSYS {
+			return fmt.Errorf("failed to create session keyring: %w", err)
+		}
+	}
+	if cfg.MountNamespace {
+		if err := pivotRoot(cfg); err != nil {
+			return err
+		}
+	} else if err := syscall.Chroot(cfg.Rootfs); err != nil {
+		return fmt.Errorf("chroot: %w", err)
+	}
+	// the image's working directory doesn't have to exist
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return err
+	}
+	if err := os.Chdir(cfg.Dir); err != nil {
+		return err
+	}
+	// capabilities belong to the thread, so the one which drops them has
+	// to be the one which execs. The user switch below still needs them.
+	runtime.LockOSThread()
+	if err := dropBoundingSet(cfg.Capabilities); err != nil {
+		return err
+	}
+	// installing the filter takes CAP_SYS_ADMIN unless no_new_privs is set,
+	// so it goes in before the capabilities are dropped
+	if cfg.Seccomp != nil {
+		if err := installSeccomp(cfg.Seccomp); err != nil {
+			return err
+		}
+	}
+	if u := cfg.User; u != nil {
+		groups := make([]int, len(u.Groups))
+		for i, g := range u.Groups {
+			groups[i] = int(g)
+		}
+		// rootless user namespaces without subordinate gids have setgroups disabled
+		if err := syscall.Setgroups(groups); err != nil && !(err == syscall.EPERM && setgroupsDenied()) {
+			return fmt.Errorf("setgroups: %w", err)
+		}
+		if err := syscall.Setgid(int(u.GID)); err != nil {
+			return fmt.Errorf("setgid: %w", err)
+		}
+		if err := syscall.Setuid(int(u.UID)); err != nil {
+			return fmt.Errorf("setuid: %w", err)
+		}
+	}
+	// like in docker, other users lose their capabilities along with root
+	caps := cfg.Capabilities
+	if cfg.User != nil && cfg.User.UID != 0 {
+		caps = nil
+	}
+	if err := setCapabilities(caps); err != nil {
+		return err
+	}
+	if cfg.NoNewPrivileges {
+		if _, _, errno := syscall.Syscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+			return fmt.Errorf("failed to set no_new_privs: %w", errno)
+		}
+	}
+	syscall.Umask(int(cfg.Umask))
+	path, err := lookPath(cfg.Args[0], cfg.Env)
+	if err != nil {
+		return err
+	}
+	if cfg.Init {
+		code, err := runInit(path, cfg.Args, cfg.Env)
+		if err != nil {
+			return err
+		}
+		os.Exit(code)
+	}
+	return syscall.Exec(path, cfg.Args, cfg.Env)
+}
+
+// maskedPaths are hidden from the container, files by mounting /dev/null
+// over them and directories with an empty read-only tmpfs. They expose the
+// host's hardware and kernel, like its memory in /proc/kcore, and the key
+// files would list the keys of every session on the host.
+var maskedPaths = []string{
+	"/proc/acpi",
+	"/proc/asound",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/key-users",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/sys/firmware",
+	"/sys/devices/virtual/powercap",
+}
+
+// readonlyPaths can be read but not written. Most of the sysctls aren't
+// namespaced, so writing them would change the host's.
+var readonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
+// pivotRoot makes the rootfs the root of the mount namespace, with a /dev
+// and /proc of the container's own, the bind and tmpfs mounts and the
+// masked and read-only paths. The host's mounts are detached afterwards
+// so they don't show up in the container's mount table.
+func pivotRoot(cfg ContainerConfig) error {
+	rootfs := cfg.Rootfs
+	// keep the mounts below from propagating back to the host
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+	// pivot_root needs the new root to be a mount point of its own
+	if err := syscall.Mount(rootfs, rootfs, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to bind mount rootfs: %w", err)
+	}
+	if err := mountDev(rootfs, cfg.ShmSize); err != nil {
+		return err
+	}
+	for _, b := range cfg.Binds {
+		if err := mountBind(rootfs, b); err != nil {
+			return err
+		}
+	}
+	for _, t := range cfg.Tmpfs {
+		if err := mountTmpfs(rootfs, t); err != nil {
+			return err
+		}
+	}
+	proc := filepath.Join(rootfs, "proc")
+	if err := os.Mkdir(proc, 0555); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	// a symlink would have the mount land somewhere else
+	if fi, err := os.Lstat(proc); err != nil || !fi.IsDir() {
+		return fmt.Errorf("/proc in the image isn't a directory")
+	}
+	if err := syscall.Mount("proc", proc, "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("failed to mount /proc: %w", err)
+	}
+	for _, p := range cfg.MaskedPaths {
+		if err := maskPath(filepath.Join(rootfs, p)); err != nil {
+			return fmt.Errorf("failed to mask %s: %w", p, err)
+		}
+	}
+	for _, p := range cfg.ReadonlyPaths {
+		target := filepath.Join(rootfs, p)
+		if err := syscall.Mount(target, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			if err == syscall.ENOENT {
+				continue
+			}
+			return fmt.Errorf("failed to bind mount %s: %w", p, err)
+		}
+		if err := remountReadOnly(target); err != nil {
+			return fmt.Errorf("failed to make %s read-only: %w", p, err)
+		}
+	}
+	if cfg.ReadOnly {
+		// the working directory can't be created once it's read-only
+		dir, err := resolveMountPoint(rootfs, cfg.Dir)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err := remountReadOnly(rootfs); err != nil {
+			return fmt.Errorf("failed to make the rootfs read-only: %w", err)
+		}
+	}
+	// pivoting onto . stacks the old root on top of the new one, so it
+	// can be detached without needing a directory to move it to.
+	if err := os.Chdir(rootfs); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("pivot_root: %w", err)
+	}
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to detach the old root: %w", err)
+	}
+	return os.Chdir("/")
+}
+
+// maskPath hides the file or directory at path, if there is one.
+func maskPath(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return syscall.Mount("tmpfs", path, "tmpfs", syscall.MS_RDONLY, "")
+	}
+	return syscall.Mount("/dev/null", path, "", syscall.MS_BIND, "")
+}
+
+// setgroupsDenied reports whether setgroups has been disabled in the user namespace.
+func setgroupsDenied() bool {
+	data, err := os.ReadFile("/proc/self/setgroups")
+	return err == nil && strings.TrimSpace(string(data)) == "deny"
+}
+
+// keyctlJoinSessionKeyring is KEYCTL_JOIN_SESSION_KEYRING from linux/keyctl.h
+const keyctlJoinSessionKeyring = 1
+
+// joinSessionKeyring creates a new session keyring with the given name
+// and makes it the session keyring of the calling process.
+func joinSessionKeyring(name string) error {
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_KEYCTL, keyctlJoinSessionKeyring, uintptr(unsafe.Pointer(p)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// lookPath searches for file in the PATH found in env.
+func lookPath(file string, env []string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+	var path string
+	for _, kv := range env {
+		if v, ok := strings.CutPrefix(kv, "PATH="); ok {
+			path = v
+		}
+	}
+	for _, dir := range filepath.SplitList(path) {
+		name := filepath.Join(dir, file)
+		if fi, err := os.Stat(name); err == nil && !fi.IsDir() && fi.Mode()&0111 != 0 {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("%s: executable file not found in $PATH", file)
+}
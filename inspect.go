@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"text/template"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+// ImageDetails is what inspect shows for an image: its entry in the
+// index along with the parts of its config which matter for running it.
+type ImageDetails struct {
+	LocalImage
+	// Layers are the digests of the uncompressed layers, lowest first.
+	Layers []string                    `json:"layers"`
+	Config registry.ImageRuntimeConfig `json:"config"`
+}
+
+// InspectImage returns the details of an image in the local index.
+func InspectImage(ref Reference) (ImageDetails, error) {
+	snapshots, err := ListSnapshots()
+	if err != nil {
+		return ImageDetails{}, err
+	}
+	sizes := map[string]int64{}
+	for _, s := range snapshots {
+		sizes[s.Path] = s.Size
+	}
+	image, config, err := readLocalImageInfo(ref, sizes)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ImageDetails{}, fmt.Errorf("no such image: %s", ref)
+	}
+	if err != nil {
+		return ImageDetails{}, err
+	}
+	return ImageDetails{
+		LocalImage: image,
+		Layers:     config.RootFS.DiffIDs,
+		Config:     config.Config,
+	}, nil
+}
+
+// Inspect returns the container with the id, or an unambiguous prefix of
+// it, and otherwise the local image with the name.
+func Inspect(name string) (any, error) {
+	c, _, err := FindContainer(name)
+	if !errors.Is(err, errNoSuchContainer) {
+		return c, err
+	}
+	ref, err := registry.ParseReference(name)
+	if err != nil {
+		return nil, fmt.Errorf("no such container or image: %s", name)
+	}
+	image, err := InspectImage(ref)
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// inspectFuncs are the functions available to inspect templates, a subset
+// of the ones docker has.
+var inspectFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// ParseInspectFormat parses an inspect -format template, like
+// {{.State.ExitCode}} or {{json .Config.Env}}.
+func ParseInspectFormat(format string) (*template.Template, error) {
+	return template.New("format").Funcs(inspectFuncs).Parse(format)
+}
+
+// WriteInspect writes the objects as an indented JSON array like docker
+// inspect, or each of them on its own line with the template if it's set.
+func WriteInspect(w io.Writer, objects []any, tmpl *template.Template) error {
+	if tmpl == nil {
+		if objects == nil {
+			objects = []any{}
+		}
+		data, err := json.MarshalIndent(objects, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+	for _, v := range objects {
+		if err := tmpl.Execute(w, v); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
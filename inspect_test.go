@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInspect(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	digest := writeTestImage(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), []string{"alpine:3.19"})
+	dir, err := ContainersDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Container{
+		ID:          "abc123",
+		Image:       "docker.io/library/alpine:3.19",
+		ImageDigest: digest,
+		Config:      ContainerSettings{Env: []string{"PATH=/bin", "HOME=/root"}},
+		State:       &ContainerState{ExitCode: 3, FinishedAt: time.Now()},
+	}
+	if err := os.MkdirAll(filepath.Join(dir, c.ID), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteContainer(filepath.Join(dir, c.ID), c); err != nil {
+		t.Fatal(err)
+	}
+	var objects []any
+	for _, name := range []string{"abc", "alpine:3.19"} {
+		v, err := Inspect(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		objects = append(objects, v)
+	}
+	if _, err := Inspect("alpine:3.20"); err == nil {
+		t.Error("expected a missing image to fail")
+	}
+	for i, tt := range []struct {
+		format string
+		want   string
+	}{
+		{`{{.State.ExitCode}} {{join .Config.Env ","}}`, "3 PATH=/bin,HOME=/root\n"},
+		{`{{.Repository}}:{{.Tag}} {{json .Layers}}`, "alpine:3.19 [\"sha256:" + strings.Repeat("1", 64) + "\"]\n"},
+	} {
+		tmpl, err := ParseInspectFormat(tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := WriteInspect(&buf, objects[i:i+1], tmpl); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("got %q, want %q", buf.String(), tt.want)
+		}
+	}
+	var buf bytes.Buffer
+	if err := WriteInspect(&buf, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "[]\n" {
+		t.Errorf("got %q for no objects", buf.String())
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// FileLock is an flock(2) lock. The kernel releases it when the process
+// exits, so a crashed invocation can't leave a stale lock behind.
+type FileLock struct {
+	f *os.File
+}
+
+// LockFile blocks until it holds the lock on the file at path, creating it
+// if it doesn't exist. Shared locks can be held by several processes at once.
+func LockFile(path string, exclusive bool) (*FileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		err = syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FileLock{f: f}, nil
+}
+
+// TryLockFile is LockFile for an exclusive lock, except that it reports
+// false instead of waiting when another process holds the lock.
+func TryLockFile(path string) (*FileLock, bool, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, false, err
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return &FileLock{f: f}, true, nil
+}
+
+// Unlock releases the lock.
+func (l *FileLock) Unlock() error {
+	return l.f.Close()
+}
+
+// LockStore locks the whole data root. Anything which adds to the store or
+// relies on what's in it takes a shared lock, while gc and prune take an
+// exclusive one so they never remove something another process is using.
+func LockStore(exclusive bool) (*FileLock, error) {
+	root, err := DataRoot()
+	if err != nil {
+		return nil, err
+	}
+	return LockFile(filepath.Join(root, "store.lock"), exclusive)
+}
+
+// WriteFileAtomic writes the file to a temporary file next to it and then
+// renames it into place, so readers never see a partially written file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	a, err := LockFile(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan *FileLock)
+	go func() {
+		b, err := LockFile(path, false)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- b
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("shared lock was acquired while the exclusive lock was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := a.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case b := <-acquired:
+		b.Unlock()
+	case <-time.After(time.Second):
+		t.Fatal("shared lock wasn't acquired after unlocking")
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(path, []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "new" {
+		t.Fatalf("unexpected contents: %q %v", data, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected mode: %v %v", info.Mode(), err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("temporary files were left behind: %v", entries)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LogEntry is a line of container output in the log file, in the format of
+// docker's json-file logging driver.
+type LogEntry struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+// maxLogLine is where long lines are split into several entries, so a
+// container writing without newlines can't make the logger buffer forever.
+const maxLogLine = 16 * 1024
+
+// ContainerLogPath returns the path of the log file in a container's directory.
+func ContainerLogPath(dir string) string {
+	return filepath.Join(dir, "container-json.log")
+}
+
+// LogWriter appends the output of a container to its log file.
+type LogWriter struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// CreateLogFile opens the log file for appending, creating it if needed.
+func CreateLogFile(path string) (*LogWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &LogWriter{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Copy logs everything read from r as the stream until EOF.
+func (w *LogWriter) Copy(stream string, r io.Reader) error {
+	br := bufio.NewReaderSize(r, maxLogLine)
+	for {
+		line, err := br.ReadSlice('\n')
+		if len(line) > 0 {
+			if err := w.write(stream, line); err != nil {
+				return err
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (w *LogWriter) write(stream string, line []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(LogEntry{Log: string(line), Stream: stream, Time: time.Now().UTC()})
+}
+
+// Close closes the log file.
+func (w *LogWriter) Close() error {
+	return w.f.Close()
+}
+
+// logPollInterval is how often a followed log file is checked for new entries.
+const logPollInterval = 250 * time.Millisecond
+
+// CopyLogs writes the entries of the log file to stdout or stderr depending
+// on their stream. When follow is set, it keeps waiting for new entries
+// until running reports that the container has stopped.
+func CopyLogs(r io.Reader, stdout, stderr io.Writer, follow bool, running func() bool) error {
+	br := bufio.NewReader(r)
+	var partial []byte
+	stopped := false
+	for {
+		line, err := br.ReadBytes('\n')
+		partial = append(partial, line...)
+		if err == io.EOF {
+			if !follow || stopped {
+				return nil
+			}
+			// read once more after the container stops to get the
+			// entries written while it was exiting
+			if !running() {
+				stopped = true
+			} else {
+				time.Sleep(logPollInterval)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var entry LogEntry
+		if err := json.Unmarshal(partial, &entry); err != nil {
+			return errors.New("corrupt log file")
+		}
+		partial = partial[:0]
+		w := stdout
+		if entry.Stream == "stderr" {
+			w = stderr
+		}
+		if _, err := io.WriteString(w, entry.Log); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "container-json.log")
+	w, err := CreateLogFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("x", maxLogLine+10)
+	if err := w.Copy("stdout", strings.NewReader("one\ntwo\n"+long)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Copy("stderr", strings.NewReader("oops\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the long line is split at the buffer size
+	if n := strings.Count(string(data), "\n"); n != 5 {
+		t.Errorf("expected 5 entries, got %d:\n%s", n, data)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var stdout, stderr strings.Builder
+	if err := CopyLogs(f, &stdout, &stderr, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stdout.String(), "one\ntwo\n"+long; got != want {
+		t.Errorf("stdout: got %d bytes, want %d", len(got), len(want))
+	}
+	if got := stderr.String(); got != "oops\n" {
+		t.Errorf("stderr: got %q", got)
+	}
+}
+
+func TestCopyLogsFollow(t *testing.T) {
+	r := strings.NewReader(`{"log":"a\n","stream":"stdout","time":"2024-01-01T00:00:00Z"}` + "\n")
+	var stdout strings.Builder
+	calls := 0
+	running := func() bool {
+		calls++
+		return false
+	}
+	if err := CopyLogs(r, &stdout, &stdout, true, running); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 || stdout.String() != "a\n" {
+		t.Errorf("got %q after %d checks", stdout.String(), calls)
+	}
+}
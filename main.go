@@ -1,206 +1,1751 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/exec"
-	"runtime"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/icholy/shittydocker/pkg/registry"
 )
 
 func main() {
+	if os.Args[0] == initArg0 {
+		if err := Init(); err != nil {
+			log.Fatalf("init: %v", err)
+		}
+		return
+	}
+	// unprivileged users get a user namespace to be root in, except for the
+	// commands which never touch the image store.
+	if (os.Geteuid() != 0 || Rootless()) && (len(os.Args) < 2 || !rootlessExempt[os.Args[1]]) {
+		EnterRootless()
+	}
+	args := os.Args[1:]
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "run":
+			// the same as running without a subcommand
+			args = os.Args[2:]
+		case "pull":
+			pullCmd(os.Args[2:])
+			return
+		case "registry":
+			registryCmd(os.Args[2:])
+			return
+		case "system":
+			systemCmd(os.Args[2:])
+			return
+		case "image":
+			imageCmd(os.Args[2:])
+			return
+		case "tags":
+			tagsCmd(os.Args[2:])
+			return
+		case "export":
+			exportCmd(os.Args[2:])
+			return
+		case "save":
+			saveCmd(os.Args[2:])
+			return
+		case "load":
+			loadCmd(os.Args[2:])
+			return
+		case "images":
+			imagesCmd(os.Args[2:])
+			return
+		case "rmi":
+			rmiCmd(os.Args[2:])
+			return
+		case "ps":
+			psCmd(os.Args[2:])
+			return
+		case "logs":
+			logsCmd(os.Args[2:])
+			return
+		case "attach":
+			attachCmd(os.Args[2:])
+			return
+		case "exec":
+			execCmd(os.Args[2:])
+			return
+		case "stop":
+			stopCmd(os.Args[2:])
+			return
+		case "kill":
+			killCmd(os.Args[2:])
+			return
+		case "wait":
+			waitCmd(os.Args[2:])
+			return
+		case "rm":
+			rmCmd(os.Args[2:])
+			return
+		case "inspect":
+			inspectCmd(os.Args[2:])
+			return
+		case "login":
+			loginCmd(os.Args[2:])
+			return
+		case "logout":
+			logoutCmd(os.Args[2:])
+			return
+		}
+	}
 	// parse args
-	var image string
-	flag.StringVar(&image, "image", "alpine", "image to run")
-	flag.Parse()
+	var image, runtimeName, isolation, userSpec, shmSize, cgroupParent, memory, memoryReservation, cpus, umaskFlag, maxCacheSize, pullRateLimit, pullFlag, tz string
+	var sysctlFlags, labelFlags, annotationFlags, envFlags, envFiles, volumeFlags, tmpfsFlags, addHosts, capAdd, capDrop, securityOpts StringList
+	var dns ResolvConf
+	var networkFlag, ipFlag, gatewayFlag, hostname, name string
+	flag.StringVar(&image, "image", "alpine", "image to run, as name[:tag]")
+	flag.StringVar(&runtimeName, "runtime", "native", "container runtime: native or runsc")
+	flag.StringVar(&isolation, "isolation", "namespace", "isolation mode: namespace or chroot (no namespaces, for restricted environments)")
+	flag.StringVar(&userSpec, "user", "", "user to run as: name, uid, name:group or uid:gid")
+	flag.Var(&sysctlFlags, "sysctl", "namespaced sysctl to set, as key=value (repeatable)")
+	flag.StringVar(&shmSize, "shm-size", "64m", "size of /dev/shm")
+	flag.Var(&capAdd, "cap-add", "capability to grant on top of the default set, e.g. NET_ADMIN, or ALL (repeatable)")
+	flag.Var(&capDrop, "cap-drop", "capability to drop from the default set, e.g. NET_RAW, or ALL (repeatable)")
+	flag.Var(&securityOpts, "security-opt", "security option: seccomp=profile.json, seccomp=unconfined, no-new-privileges=false or systempaths=unconfined (repeatable)")
+	flag.Var(&labelFlags, "label", "container label, as key=value (repeatable)")
+	flag.Var(&annotationFlags, "annotation", "OCI annotation, as key=value (repeatable)")
+	flag.StringVar(&cgroupParent, "cgroup-parent", "", "parent cgroup for the container, a path or a systemd slice (default /shittydocker)")
+	var resources CgroupResources
+	flag.Uint64Var(&resources.CPUShares, "cpu-shares", 0, "relative cpu weight against other containers (default 1024 when unset)")
+	flag.StringVar(&cpus, "cpus", "", "number of cpus the container can use, e.g. 1.5")
+	flag.StringVar(&memory, "memory", "", "memory limit, the container is OOM killed above it, e.g. 512m")
+	flag.StringVar(&memoryReservation, "memory-reservation", "", "soft memory limit enforced under host memory pressure, e.g. 256m")
+	swappiness := flag.Int("memory-swappiness", -1, "swappiness of the container's memory [0-100]")
+	flag.Int64Var(&resources.PidsLimit, "pids-limit", 0, "maximum number of processes in the container (default unlimited)")
+	flag.StringVar(&umaskFlag, "umask", "0022", "initial umask of the container process, in octal")
+	registryCA := flag.String("registry-cacert", "", "PEM file of extra CA certificates to trust for registries")
+	var insecureRegistries StringList
+	flag.Var(&insecureRegistries, "insecure-registry", "registry host[:port] to pull from over plain http or unverified https (repeatable)")
+	flag.StringVar(&maxCacheSize, "max-cache-size", os.Getenv("SHITTYDOCKER_MAX_CACHE_SIZE"), "evict the least recently used snapshots after pulling when the cache is bigger than this, e.g. 20g")
+	flag.Var((*StringList)(&dns.Nameservers), "dns", "nameserver to use instead of the host's (repeatable)")
+	flag.Var((*StringList)(&dns.Search), "dns-search", "dns search domain to use instead of the host's, . for none (repeatable)")
+	flag.Var((*StringList)(&dns.Options), "dns-option", "resolv.conf option to use instead of the host's (repeatable)")
+	flag.Var(&addHosts, "add-host", "add an /etc/hosts entry as name:ip, where host-gateway is the host (repeatable)")
+	flag.Var(&envFlags, "e", "set an environment variable as KEY=VALUE, or KEY to copy it from the host (repeatable)")
+	flag.Var(&envFiles, "env-file", "read environment variables from a file (repeatable)")
+	flag.Var(&volumeFlags, "v", "bind mount a host path into the container as /host/path:/container/path[:ro] (repeatable)")
+	flag.Var(&tmpfsFlags, "tmpfs", "mount a tmpfs in the container as /path[:options], e.g. /run:size=64m,mode=755 (repeatable)")
+	readOnly := flag.Bool("read-only", false, "mount the container's root filesystem read-only")
+	interactive := flag.Bool("i", false, "keep stdin attached to the container")
+	tty := flag.Bool("t", false, "allocate a pty for the container")
+	detach := flag.Bool("d", false, "run the container in the background and print its id")
+	initFlag := flag.Bool("init", false, "run a tiny init as pid 1 which reaps zombies and forwards signals to the command")
+	remove := flag.Bool("rm", false, "remove the container once it exits instead of keeping it around")
+	var entrypoint *string
+	flag.Func("entrypoint", "command to run instead of the image's entrypoint, \"\" to remove it", func(s string) error {
+		entrypoint = &s
+		return nil
+	})
+	flag.StringVar(&name, "name", "", "name of the container, for the commands which take a container id (default a random one)")
+	flag.StringVar(&hostname, "hostname", "", "container hostname (default the short container id)")
+	flag.StringVar(&networkFlag, "network", "host", "network to attach to: host, bridge, macvlan:<parent> or ipvlan:<parent>")
+	flag.StringVar(&ipFlag, "ip", "", "address of the container on a macvlan or ipvlan network, e.g. 192.168.1.50/24, or on the bridge network without the prefix length")
+	flag.StringVar(&gatewayFlag, "gateway", "", "default gateway on a macvlan or ipvlan network")
+	flag.StringVar(&tz, "tz", "", "timezone of the container: host or a zoneinfo name like Europe/Berlin")
+	flag.StringVar(&pullFlag, "pull", "missing", "when to pull the image: always, missing or never")
+	flag.StringVar(&pullRateLimit, "pull-rate-limit", "", "limit layer downloads to this many bytes per second, e.g. 10m")
+	quiet := flag.Bool("quiet", false, "don't show the progress of layer downloads")
+	pullTimeout := flag.Duration("pull-timeout", 0, "give up on pulling the image after this long, e.g. 10m (default no timeout)")
+	platform := flag.String("platform", "", "pull the image for this platform instead of the host's, e.g. linux/arm64")
+	flag.CommandLine.Parse(args)
 
-	if flag.NArg() < 1 {
-		log.Fatal("command is required")
+	if *registryCA != "" {
+		if err := AddRegistryCA(*registryCA); err != nil {
+			log.Fatalf("invalid -registry-cacert: %v", err)
+		}
+	}
+	for _, host := range insecureRegistries {
+		AddInsecureRegistry(host)
+	}
+	if runtimeName != "native" && runtimeName != "runsc" {
+		log.Fatalf("unknown runtime: %s", runtimeName)
+	}
+	if runtimeName == "runsc" && Rootless() {
+		log.Fatal("-runtime runsc requires root")
+	}
+	if runtimeName == "runsc" && *tty {
+		log.Fatal("-t requires the native runtime")
+	}
+	if runtimeName == "runsc" && *initFlag {
+		log.Fatal("-init requires the native runtime")
+	}
+	if *detach && *interactive && !*tty {
+		log.Fatal("-d can't be used with -i unless there's a -t to attach to")
+	}
+	var detachedReady *os.File
+	if *detach && !Detached() {
+		Detach()
+	} else if *detach {
+		detachedReady = openDetachedReady()
+	}
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pull, err := ParsePullPolicy(pullFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	caps, err := ParseCapabilities(capAdd, capDrop)
+	if err != nil {
+		log.Fatal(err)
+	}
+	security, err := ParseSecurityOpts(securityOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var masked, readonly []string
+	if !security.UnconfinedPaths {
+		masked, readonly = maskedPaths, readonlyPaths
+	}
+	// gVisor implements the syscalls itself rather than passing them to the host
+	var seccomp []byte
+	if runtimeName == "native" {
+		seccomp, err = security.SeccompFilter(caps)
+		if err != nil {
+			log.Fatalf("invalid -security-opt: %v", err)
+		}
+	}
+	var cloneflags uintptr
+	switch isolation {
+	case "namespace":
+		cloneflags = syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWNS | syscall.CLONE_NEWUTS
+	case "chroot":
+		if runtimeName != "native" {
+			log.Fatal("chroot isolation requires the native runtime")
+		}
+	default:
+		log.Fatalf("unknown isolation mode: %s", isolation)
+	}
+	var network *NetworkConfig
+	if networkFlag != "host" {
+		n, err := ParseNetwork(networkFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if runtimeName != "native" || cloneflags == 0 {
+			log.Fatal("-network requires the native runtime and namespace isolation")
+		}
+		if Rootless() {
+			log.Fatal("-network requires root")
+		}
+		if n.Driver == "bridge" {
+			var addr netip.Addr
+			if ipFlag != "" {
+				if addr, err = netip.ParseAddr(ipFlag); err != nil {
+					log.Fatalf("invalid -ip: %v", err)
+				}
+			}
+			if gatewayFlag != "" {
+				log.Fatal("-gateway can't be used with the bridge network")
+			}
+			prefix, lease, err := LeaseBridgeAddress(addr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer lease.Unlock()
+			n.Address, n.Gateway = prefix, bridgeGateway
+		} else if n.Address, err = netip.ParsePrefix(ipFlag); err != nil {
+			log.Fatalf("-network %s requires -ip with a prefix length, e.g. 192.168.1.50/24", n.Driver)
+		}
+		if gatewayFlag != "" {
+			if n.Gateway, err = netip.ParseAddr(gatewayFlag); err != nil {
+				log.Fatalf("invalid -gateway: %v", err)
+			}
+		}
+		network = &n
+		cloneflags |= syscall.CLONE_NEWNET
+	} else if ipFlag != "" || gatewayFlag != "" {
+		log.Fatal("-ip and -gateway require a bridge, macvlan or ipvlan -network")
+	}
+	// the host is the gateway of the container's network, or
+	// reachable on the loopback address when they share it
+	gateway := netip.MustParseAddr("127.0.0.1")
+	if network != nil {
+		gateway = network.Gateway
+	}
+	var extraHosts []HostEntry
+	for _, h := range addHosts {
+		e, err := ParseHostEntry(h, gateway)
+		if err != nil {
+			log.Fatalf("invalid -add-host: %v", err)
+		}
+		extraHosts = append(extraHosts, e)
+	}
+	if name != "" {
+		if err := ValidateContainerName(name); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if hostname != "" {
+		if cloneflags&syscall.CLONE_NEWUTS == 0 {
+			log.Fatal("-hostname requires namespace isolation")
+		}
+		if err := ValidateHostname(hostname); err != nil {
+			log.Fatal(err)
+		}
+	}
+	sysctls := map[string]string{}
+	for _, s := range sysctlFlags {
+		key, value, err := ParseSysctl(s, cloneflags)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sysctls[key] = value
+	}
+	umask, err := strconv.ParseUint(umaskFlag, 8, 32)
+	if err != nil || umask > 0777 {
+		log.Fatalf("invalid -umask: %q", umaskFlag)
+	}
+	shmBytes, err := ParseBytes(shmSize)
+	if err != nil {
+		log.Fatalf("invalid -shm-size: %v", err)
+	}
+	if cpus != "" {
+		resources.CPUQuota, resources.CPUPeriod, err = ParseCPUs(cpus)
+		if err != nil {
+			log.Fatalf("invalid -cpus: %v", err)
+		}
+	}
+	if memory != "" {
+		resources.Memory, err = ParseBytes(memory)
+		if err != nil {
+			log.Fatalf("invalid -memory: %v", err)
+		}
+	}
+	if memoryReservation != "" {
+		resources.MemoryReservation, err = ParseBytes(memoryReservation)
+		if err != nil {
+			log.Fatalf("invalid -memory-reservation: %v", err)
+		}
+	}
+	if resources.Memory != 0 && resources.MemoryReservation > resources.Memory {
+		log.Fatal("-memory-reservation must be smaller than -memory")
+	}
+	if resources.PidsLimit < 0 {
+		log.Fatalf("invalid -pids-limit: %d", resources.PidsLimit)
+	}
+	if pullRateLimit != "" {
+		rate, err := ParseBytes(pullRateLimit)
+		if err != nil || rate <= 0 {
+			log.Fatalf("invalid -pull-rate-limit: %q", pullRateLimit)
+		}
+		pullLimit = NewTokenBucket(rate)
+	}
+	if *platform != "" {
+		setPullPlatform(*platform)
+		if host := registry.HostPlatform(); pullPlatform != host {
+			log.Printf("WARNING: the platform %s doesn't match the host's %s, it needs emulation to run", pullPlatform, host)
+		}
+	}
+	if !*quiet {
+		pullProgress = NewProgress(os.Stderr, isTerminal(os.Stderr))
+	}
+	if _, err := (ResolvConf{}).Override(dns); err != nil {
+		log.Fatalf("invalid -dns: %v", err)
+	}
+	var tzFile, tzName string
+	if tz != "" {
+		if tzFile, tzName, err = TimezoneFile(tz); err != nil {
+			log.Fatalf("invalid -tz: %v", err)
+		}
+	}
+	var cacheLimit int64
+	if maxCacheSize != "" {
+		cacheLimit, err = ParseBytes(maxCacheSize)
+		if err != nil {
+			log.Fatalf("invalid -max-cache-size: %v", err)
+		}
+	}
+	if *swappiness != -1 {
+		if *swappiness < 0 || *swappiness > 100 {
+			log.Fatalf("invalid -memory-swappiness: %d is not in the range [0-100]", *swappiness)
+		}
+		v := uint64(*swappiness)
+		resources.MemorySwappiness = &v
+	}
+	// NOTE: rootless containers would need a cgroup delegated by systemd
+	useCgroups := cloneflags != 0 && CgroupsAvailable() && !Rootless()
+	if (cgroupParent != "" || resources != CgroupResources{}) && !useCgroups {
+		log.Fatal("-cgroup-parent and resource limits require root, cgroup v2 and namespace isolation")
+	}
+	labels, err := ParseKeyValues(labelFlags)
+	if err != nil {
+		log.Fatalf("invalid -label: %v", err)
+	}
+	annotations, err := ParseKeyValues(annotationFlags)
+	if err != nil {
+		log.Fatalf("invalid -annotation: %v", err)
+	}
+	var binds []BindMount
+	for _, v := range volumeFlags {
+		b, err := ParseBindMount(v)
+		if err != nil {
+			log.Fatal(err)
+		}
+		binds = append(binds, b)
+	}
+	if len(binds) > 0 && cloneflags&syscall.CLONE_NEWNS == 0 {
+		log.Fatal("-v requires namespace isolation")
+	}
+	var tmpfs []TmpfsMount
+	for _, v := range tmpfsFlags {
+		t, err := ParseTmpfs(v)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tmpfs = append(tmpfs, t)
+	}
+	if len(tmpfs) > 0 && cloneflags&syscall.CLONE_NEWNS == 0 {
+		log.Fatal("-tmpfs requires namespace isolation")
+	}
+	if *readOnly && cloneflags&syscall.CLONE_NEWNS == 0 {
+		log.Fatal("-read-only requires namespace isolation")
+	}
+	// env files are applied first so -e can override them
+	var envVars []string
+	for _, name := range envFiles {
+		vars, err := ReadEnvFile(name)
+		if err != nil {
+			log.Fatalf("invalid -env-file: %v", err)
+		}
+		envVars = append(envVars, vars...)
+	}
+	// create bundle dir
+	id := NewContainerID()
+	if hostname == "" && cloneflags&syscall.CLONE_NEWUTS != 0 {
+		hostname = id[:12]
+	}
+	root, err := DataRoot()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// hold off gc and prune until the container's layers are mounted
+	storeLock, err := LockStore(false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	jail := filepath.Join(root, "containers", id)
+	rootfs := filepath.Join(jail, "rootfs")
+	upper := filepath.Join(jail, "upper")
+	work := filepath.Join(jail, "work")
+	for _, dir := range []string{rootfs, upper, work} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatalf("failed to create jail: %s", err)
+		}
+	}
+	container := Container{
+		ID:          id,
+		Name:        name,
+		Image:       ref.String(),
+		Created:     time.Now(),
+		Labels:      labels,
+		Annotations: annotations,
+		Mounts:      ContainerMounts(binds, tmpfs),
+		Network:     network,
+	}
+	if err := CreateContainer(jail, &container); err != nil {
+		os.RemoveAll(jail)
+		log.Fatal(err)
+	}
+	var cmd *exec.Cmd
+	var shm string
+	var cg *Cgroup
+	mounted := false
+	// ctx is cancelled when shittydocker is interrupted during the setup
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var startMu sync.Mutex
+	started := false
+	var interrupted syscall.Signal
+	// teardown undoes the setup on the host once the container exits,
+	// or when the setup fails part way through.
+	teardown := func() {
+		if cg != nil {
+			if err := cg.Remove(); err != nil {
+				log.Printf("failed to remove cgroup: %v", err)
+			}
+		}
+		unmounted := true
+		for _, target := range []string{shm, rootfs} {
+			if target == "" || target == rootfs && !mounted {
+				continue
+			}
+			if err := Unmount(target); err != nil {
+				log.Printf("failed to unmount %s: %v", target, err)
+				unmounted = false
+			}
+		}
+		// removing the jail with anything still mounted would delete through the mounts
+		if *remove && unmounted {
+			if err := os.RemoveAll(jail); err != nil {
+				log.Printf("failed to remove container: %v", err)
+			}
+		}
+	}
+	fatalf := func(format string, v ...any) {
+		log.Printf(format, v...)
+		teardown()
+		startMu.Lock()
+		defer startMu.Unlock()
+		if interrupted != 0 {
+			os.Exit(128 + int(interrupted))
+		}
+		os.Exit(1)
+	}
+	// signals are forwarded to the container once it's running. Until then
+	// they cancel the setup, which stops at the next check and tears down
+	// whatever it did so far.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, forwardedSignals...)
+	go func() {
+		for sig := range sigs {
+			startMu.Lock()
+			switch {
+			case !started && interrupted != 0:
+				// a second signal doesn't wait for the setup to notice
+				teardown()
+				os.Exit(128 + int(interrupted))
+			case !started:
+				interrupted = sig.(syscall.Signal)
+				cancel()
+			case cmd.Process != nil:
+				// init only gets the signals it has a handler for, like any pid 1
+				cmd.Process.Signal(sig)
+			}
+			startMu.Unlock()
+		}
+	}()
+	cgroupPath, err := CgroupPath(cgroupParent, id)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	// download/extract the image layers and stack them up
+	pullCtx, cancelPull := withTimeout(ctx, *pullTimeout)
+	layers, err := FetchImageSnapshots(pullCtx, ref, pull)
+	cancelPull()
+	if err != nil {
+		fatalf("failed to fetch image: %s", err)
+	}
+	rec, err := ReadImageRecord(ref)
+	if err != nil {
+		fatalf("failed to read image record: %v", err)
+	}
+	container.ImageDigest = rec.Manifest
+	container.Layers = layers
+	if err := WriteContainer(jail, container); err != nil {
+		fatalf("failed to write container: %s", err)
+	}
+	if err := MountOverlay(layers, upper, work, rootfs); err != nil {
+		fatalf("%v", err)
+	}
+	mounted = true
+	storeLock.Unlock()
+	// the container's layers are protected now that they're mounted
+	if cacheLimit > 0 {
+		removed, err := GarbageCollect(GCPolicy{MaxSize: cacheLimit})
+		if err != nil {
+			log.Printf("WARNING: failed to evict snapshots: %v", err)
+		}
+		for _, s := range removed {
+			log.Printf("evicted snapshot %s (%s)", s.ID, HumanSize(s.Size))
+		}
+	}
+	imageConfig, err := ReadImageConfig(ref)
+	if err != nil {
+		fatalf("failed to read image config: %v", err)
+	}
+	command := imageConfig.Config.Command(entrypoint, flag.Args())
+	if len(command) == 0 {
+		fatalf("%s has no default command, specify one", ref)
+	}
+	container.Command = command
+	if userSpec == "" {
+		userSpec = imageConfig.Config.User
+	}
+	// the user is only switched when one's given, but root's home is
+	// still looked up for HOME
+	u, err := ResolveUser(rootfs, userSpec)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	var user *User
+	if userSpec != "" {
+		user = &u
+	}
+	if err := InstallResolvConf(rootfs, dns, network != nil); err != nil {
+		fatalf("failed to write resolv.conf: %v", err)
 	}
-	// create chroot dir
-	jail, err := os.MkdirTemp("", "jail-")
+	if hostname != "" {
+		if err := InstallHostname(rootfs, hostname); err != nil {
+			fatalf("failed to set hostname: %v", err)
+		}
+	}
+	if hostname != "" || len(extraHosts) > 0 {
+		var addr netip.Addr
+		if network != nil {
+			addr = network.Address.Addr()
+		}
+		if err := InstallHosts(rootfs, hostname, addr, extraHosts); err != nil {
+			fatalf("failed to write /etc/hosts: %v", err)
+		}
+	}
+	if tzFile != "" {
+		if err := InstallTimezone(rootfs, tzFile, tzName); err != nil {
+			fatalf("failed to set timezone: %v", err)
+		}
+	}
+	env, err := MergeEnv(imageConfig.Config.Environ(), append(envVars, envFlags...))
 	if err != nil {
-		log.Fatalf("failed to create jail: %s", err)
+		fatalf("invalid -e: %v", err)
 	}
-	// download/extract image to dir
-	if err := FetchImageTo("library", image, jail); err != nil {
-		log.Fatalf("failed to fetch image: %s", err)
+	env = DefaultEnv(env, "HOME", u.Home)
+	container.Config = ContainerSettings{
+		Env:             env,
+		WorkingDir:      imageConfig.Config.Dir(),
+		User:            userSpec,
+		Hostname:        hostname,
+		Runtime:         runtimeName,
+		Isolation:       isolation,
+		Tty:             *tty,
+		Interactive:     *interactive,
+		ReadOnly:        *readOnly,
+		Init:            *initFlag,
+		Capabilities:    caps,
+		Umask:           uint32(umask),
+		NoNewPrivileges: security.NoNewPrivileges,
+	}
+	if seccomp != nil {
+		if err := os.WriteFile(ContainerSeccompPath(jail), seccomp, 0644); err != nil {
+			fatalf("%v", err)
+		}
 	}
 	// run isolated process
-	cmd := exec.Cmd{
-		Path: flag.Arg(0),
-		Args: flag.Args()[1:],
-		Dir:  "/",
-		Env:  []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"},
-		SysProcAttr: &syscall.SysProcAttr{
-			Chroot:     jail,
-			Cloneflags: syscall.CLONE_NEWPID,
-		},
-		Stdout: os.Stdout,
-		Stderr: os.Stderr,
-		Stdin:  os.Stdin,
-	}
-	if err := cmd.Run(); err != nil {
+	switch runtimeName {
+	case "runsc":
+		spec := NewSpec(command, env)
+		spec.Process.Cwd = imageConfig.Config.Dir()
+		spec.Process.Capabilities = &SpecCapabilities{Bounding: caps, Effective: caps, Permitted: caps}
+		spec.Process.NoNewPrivileges = security.NoNewPrivileges
+		spec.Linux.MaskedPaths, spec.Linux.ReadonlyPaths = masked, readonly
+		if user != nil {
+			spec.Process.User = SpecUser{UID: user.UID, GID: user.GID, AdditionalGids: user.Groups}
+		}
+		specUmask := uint32(umask)
+		spec.Process.User.Umask = &specUmask
+		spec.Linux.Sysctl = sysctls
+		spec.Annotations = annotations
+		spec.Hostname = hostname
+		for _, b := range binds {
+			m := SpecMount{Destination: b.Destination, Type: "bind", Source: b.Source, Options: []string{"rbind"}}
+			if b.ReadOnly {
+				m.Options = append(m.Options, "ro")
+			}
+			spec.Mounts = append(spec.Mounts, m)
+		}
+		for _, t := range tmpfs {
+			spec.Mounts = append(spec.Mounts, SpecMount{Destination: t.Destination, Type: "tmpfs", Source: "tmpfs", Options: t.Options})
+		}
+		spec.Root.Readonly = *readOnly
+		if useCgroups {
+			spec.Linux.CgroupsPath = cgroupPath
+			spec.Linux.Resources = NewSpecResources(resources)
+		}
+		for i, m := range spec.Mounts {
+			if m.Destination == "/dev/shm" {
+				spec.Mounts[i].Options = []string{"nosuid", "noexec", "nodev", "mode=1777", fmt.Sprintf("size=%d", shmBytes)}
+			}
+		}
+		if err := WriteBundle(jail, spec); err != nil {
+			fatalf("failed to write bundle: %s", err)
+		}
+		cmd, err = RunscCommand(jail, id)
+		if err != nil {
+			fatalf("%v", err)
+		}
+	default:
+		// init mounts /dev/shm itself when it has a mount namespace
+		if cloneflags&syscall.CLONE_NEWNS == 0 {
+			shm, err = MountShm(rootfs, shmBytes)
+			if err != nil {
+				fatalf("%v", err)
+			}
+		}
+		cmd, err = InitCommand(ContainerConfig{
+			Rootfs:          rootfs,
+			Args:            command,
+			Env:             env,
+			Dir:             imageConfig.Config.Dir(),
+			User:            user,
+			Sysctls:         sysctls,
+			Umask:           uint32(umask),
+			Keyring:         "_ses." + id[:12],
+			Hostname:        hostname,
+			Binds:           binds,
+			ShmSize:         shmBytes,
+			Tmpfs:           tmpfs,
+			ReadOnly:        *readOnly,
+			Capabilities:    caps,
+			Seccomp:         seccomp,
+			NoNewPrivileges: security.NoNewPrivileges,
+			MaskedPaths:     masked,
+			ReadonlyPaths:   readonly,
+			Init:            *initFlag,
+			Sync:            network != nil,
+			MountNamespace:  cloneflags&syscall.CLONE_NEWNS != 0,
+		}, cloneflags)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if useCgroups {
+			cg, err = CreateCgroup(cgroupPath, resources.Controllers())
+			if err != nil {
+				fatalf("%v", err)
+			}
+			if err := cg.Apply(resources); err != nil {
+				fatalf("%v", err)
+			}
+			cgroupDir, err := cg.Open()
+			if err != nil {
+				fatalf("%v", err)
+			}
+			defer cgroupDir.Close()
+			cmd.SysProcAttr.UseCgroupFD = true
+			cmd.SysProcAttr.CgroupFD = int(cgroupDir.Fd())
+		}
+	}
+	var term *Terminal
+	var console *Console
+	if *tty && *detach {
+		// the pty is kept here, for attach, once the foreground process exits
+		if console, err = OpenConsole(cmd, *interactive, ContainerConsolePath(jail)); err != nil {
+			fatalf("failed to allocate a pty: %v", err)
+		}
+	} else if *tty {
+		if term, err = AttachTerminal(cmd, *interactive); err != nil {
+			fatalf("failed to allocate a pty: %v", err)
+		}
+	} else if !*interactive {
+		cmd.Stdin = nil
+	}
+	var logs *LogWriter
+	var logPipes []*os.File
+	var logsDone sync.WaitGroup
+	if *detach {
+		if logs, err = CreateLogFile(ContainerLogPath(jail)); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if *detach && console == nil {
+		for _, stream := range []string{"stdout", "stderr"} {
+			r, w, err := os.Pipe()
+			if err != nil {
+				fatalf("%v", err)
+			}
+			if stream == "stdout" {
+				cmd.Stdout = w
+			} else {
+				cmd.Stderr = w
+			}
+			logPipes = append(logPipes, w)
+			logsDone.Add(1)
+			go func() {
+				defer logsDone.Done()
+				defer r.Close()
+				if err := logs.Copy(stream, r); err != nil {
+					log.Printf("failed to write logs: %v", err)
+				}
+			}()
+		}
+	}
+	var syncPipe *os.File
+	if network != nil {
+		r, w, err := os.Pipe()
+		if err != nil {
+			fatalf("%v", err)
+		}
+		cmd.ExtraFiles = []*os.File{r}
+		syncPipe = w
+		defer r.Close()
+	}
+	state := ContainerState{StartedAt: time.Now()}
+	startMu.Lock()
+	if ctx.Err() != nil {
+		startMu.Unlock()
+		fatalf("interrupted")
+	}
+	err = cmd.Start()
+	started = true
+	startMu.Unlock()
+	// the container has its own copies of the log pipes
+	for _, w := range logPipes {
+		w.Close()
+	}
+	if err == nil {
+		state.Pid = cmd.Process.Pid
+		if state.StartTime, err = ProcessStartTime(state.Pid); err != nil {
+			log.Printf("failed to read the container's start time: %v", err)
+			err = nil
+		}
+		container.State = &state
+		if err := WriteContainer(jail, container); err != nil {
+			log.Printf("failed to record container start: %v", err)
+		}
+	}
+	if err == nil && syncPipe != nil {
+		// the namespace only exists once the process does, so the
+		// link has to be set up while init waits for us.
+		if err := SetupNetwork(*network, cmd.Process.Pid, id); err != nil {
+			log.Printf("ERROR: failed to set up network: %v", err)
+		} else {
+			syncPipe.Write([]byte{0})
+		}
+		syncPipe.Close()
+	}
+	if err == nil {
+		if term != nil {
+			term.Start()
+		}
+		if console != nil {
+			console.Start(logs, &logsDone)
+		}
+		if detachedReady != nil {
+			signalDetached(detachedReady, id)
+		}
+		err = cmd.Wait()
+	}
+	if term != nil {
+		term.Close()
+	}
+	if console != nil {
+		console.Close()
+	}
+	if logs != nil {
+		logsDone.Wait()
+		logs.Close()
+	}
+	state.FinishedAt = time.Now()
+	if cmd.ProcessState != nil {
+		if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+			state.ExitCode, state.Signal = ExitStatus(ws)
+		}
+	}
+	if cg != nil {
+		// the oom events have to be read before the cgroup is gone
+		state.OOMKilled = cg.OOMKilled()
+		if state.OOMKilled {
+			log.Printf("container was killed by the OOM killer")
+		}
+	}
+	container.State = &state
+	if err := WriteContainer(jail, container); err != nil {
+		log.Printf("failed to record container exit: %v", err)
+	}
+	teardown()
+	if err != nil {
 		log.Printf("ERROR: %v", err)
+		if errors.Is(err, syscall.EPERM) && cloneflags != 0 {
+			log.Printf("creating namespaces is not permitted here, try -isolation=chroot")
+		}
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
-			os.Exit(exitErr.ExitCode())
+			os.Exit(state.ExitCode)
 		}
 		os.Exit(1)
 	}
 }
 
-func FetchImageTo(library, image, dir string) error {
-	token, err := FetchRegistryToken(library, image)
-	if err != nil {
-		return err
+// forwardedSignals are passed on to the container's init process.
+var forwardedSignals = []os.Signal{
+	syscall.SIGINT,
+	syscall.SIGTERM,
+	syscall.SIGHUP,
+	syscall.SIGQUIT,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+}
+
+// rootlessExempt are the subcommands which work as an unprivileged user
+// without a user namespace.
+var rootlessExempt = map[string]bool{
+	"registry": true,
+	"tags":     true,
+	"ps":       true,
+	"logs":     true,
+	"attach":   true,
+	"kill":     true,
+	"wait":     true,
+	"login":    true,
+	"logout":   true,
+}
+
+// registryCmd implements the registry subcommands.
+func registryCmd(args []string) {
+	if len(args) < 1 {
+		log.Fatal("usage: shittydocker registry limits|serve")
 	}
-	manifests, err := ListManifests(library, image, token)
+	switch args[0] {
+	case "limits":
+		registryLimitsCmd()
+	case "serve":
+		flags := flag.NewFlagSet("registry serve", flag.ExitOnError)
+		addr := flags.String("addr", ":5000", "address to listen on")
+		flags.Parse(args[1:])
+		log.Printf("serving registry mirror on %s", *addr)
+		log.Fatal(http.ListenAndServe(*addr, &Mirror{}))
+	default:
+		log.Fatalf("unknown registry command: %s", args[0])
+	}
+}
+
+func registryLimitsCmd() {
+	rl, err := registryClient.RateLimit(context.Background())
 	if err != nil {
-		return err
+		log.Fatalf("failed to fetch rate limit: %v", err)
 	}
-	manifest, ok := FindManifest(manifests, Platform{
-		Architecture: runtime.GOARCH,
-		OS:           runtime.GOOS,
-	})
-	if !ok {
-		return fmt.Errorf("manifest not found")
+	fmt.Printf("limit:     %d\n", rl.Limit)
+	fmt.Printf("remaining: %d\n", rl.Remaining)
+	if rl.Window != 0 {
+		fmt.Printf("window:    %s\n", rl.Window)
+	}
+	if rl.Source != "" {
+		fmt.Printf("source:    %s\n", rl.Source)
+	}
+}
+
+// imageCmd implements the image subcommands.
+func imageCmd(args []string) {
+	if len(args) < 1 {
+		log.Fatal("usage: shittydocker image unpack|shell|mount|unmount|diff|export [flags]")
+	}
+	switch args[0] {
+	case "unpack":
+		flags := flag.NewFlagSet("image unpack", flag.ExitOnError)
+		platform := flags.String("platform", "", "unpack the image for this platform, e.g. linux/arm64")
+		flags.Parse(args[1:])
+		if flags.NArg() != 2 {
+			log.Fatal("usage: shittydocker image unpack [-platform os/arch] <image> <dir>")
+		}
+		if *platform != "" {
+			setPullPlatform(*platform)
+		}
+		if err := UnpackImage(flags.Arg(0), flags.Arg(1)); err != nil {
+			log.Fatal(err)
+		}
+	case "shell":
+		flags := flag.NewFlagSet("image shell", flag.ExitOnError)
+		shell := flags.String("shell", "/bin/sh", "shell to run")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			log.Fatal("usage: shittydocker image shell [-shell path] <image>")
+		}
+		if err := ImageShell(flags.Arg(0), *shell); err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				os.Exit(exitErr.ExitCode())
+			}
+			log.Fatal(err)
+		}
+	case "mount":
+		if len(args) != 3 {
+			log.Fatal("usage: shittydocker image mount <image> <dir>")
+		}
+		if err := MountImage(args[1], args[2]); err != nil {
+			log.Fatal(err)
+		}
+	case "unmount":
+		if len(args) != 2 {
+			log.Fatal("usage: shittydocker image unmount <dir>")
+		}
+		if err := UnmountImage(args[1]); err != nil {
+			log.Fatal(err)
+		}
+	case "diff":
+		if len(args) != 3 {
+			log.Fatal("usage: shittydocker image diff <image> <image>")
+		}
+		diff, err := DiffImages(args[1], args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Layers:")
+		for _, l := range diff.Shared {
+			fmt.Printf("  = %s\n", l)
+		}
+		for _, l := range diff.Removed {
+			fmt.Printf("  - %s\n", l)
+		}
+		for _, l := range diff.Added {
+			fmt.Printf("  + %s\n", l)
+		}
+		fmt.Println("Files:")
+		for _, c := range diff.Changes {
+			fmt.Printf("  %s\n", c)
+		}
+	case "export":
+		flags := flag.NewFlagSet("image export", flag.ExitOnError)
+		output := flags.String("o", "-", "output file, - for stdout")
+		format := flags.String("format", "tar", "output format: tar or squashfs")
+		reproducible := flags.Bool("reproducible", false, "strip host specific metadata and clamp mtimes to SOURCE_DATE_EPOCH")
+		platform := flags.String("platform", "", "export the image for this platform, e.g. linux/arm64")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			log.Fatal("usage: shittydocker image export [-o file] [-format tar|squashfs] [-reproducible] [-platform os/arch] <image>")
+		}
+		if *platform != "" {
+			setPullPlatform(*platform)
+		}
+		opts := ExportOptions{Reproducible: *reproducible}
+		if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" && *reproducible {
+			sec, err := strconv.ParseInt(epoch, 10, 64)
+			if err != nil {
+				log.Fatalf("invalid SOURCE_DATE_EPOCH: %v", err)
+			}
+			opts.ModTime = time.Unix(sec, 0)
+		}
+		if err := ExportImage(flags.Arg(0), *format, *output, opts); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unknown image command: %s", args[0])
+	}
+}
+
+// exportCmd writes a container's filesystem as a tar archive.
+func exportCmd(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("o", "-", "output file, - for stdout")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("usage: shittydocker export [-o file] <container>")
+	}
+	if err := ExportContainer(flags.Arg(0), *output, ExportOptions{}); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// saveCmd writes images to a tar archive, which loadCmd or docker
+// load can read on another machine.
+func saveCmd(args []string) {
+	flags := flag.NewFlagSet("save", flag.ExitOnError)
+	output := flags.String("o", "-", "output file, - for stdout")
+	platform := flags.String("platform", "", "save the images for this platform, e.g. linux/arm64")
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		log.Fatal("usage: shittydocker save [-o file] [-platform os/arch] <image>...")
+	}
+	if *platform != "" {
+		setPullPlatform(*platform)
 	}
-	layers, err := ListLayers(library, image, manifest, token)
+	if err := SaveImages(flags.Args(), *output); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadCmd adds the images in a tar archive to the local cache.
+func loadCmd(args []string) {
+	flags := flag.NewFlagSet("load", flag.ExitOnError)
+	input := flags.String("i", "-", "input file, - for stdin")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		log.Fatal("usage: shittydocker load [-i file]")
+	}
+	refs, err := LoadImages(*input)
 	if err != nil {
-		return err
+		log.Fatal(err)
+	}
+	for _, ref := range refs {
+		fmt.Printf("Loaded image: %s\n", ref)
 	}
-	for _, layer := range layers {
-		log.Printf("downloading layer %s/%s: %s", library, image, layer.Digest)
-		data, err := FetchLayer(library, image, layer, token)
+}
+
+// loginCmd verifies and stores Docker Hub credentials.
+func loginCmd(args []string) {
+	flags := flag.NewFlagSet("login", flag.ExitOnError)
+	username := flags.String("u", "", "username")
+	password := flags.String("p", "", "password (insecure, prefer -password-stdin)")
+	passwordStdin := flags.Bool("password-stdin", false, "read the password from stdin")
+	flags.Parse(args)
+	if flags.NArg() > 1 || (flags.NArg() == 1 && !registry.IsDockerHub(flags.Arg(0))) {
+		log.Fatal("usage: shittydocker login [-u username] [-password-stdin] (only Docker Hub is supported)")
+	}
+	if *username == "" {
+		fmt.Print("Username: ")
+		fmt.Scanln(username)
+	}
+	switch {
+	case *passwordStdin:
+		data, err := io.ReadAll(os.Stdin)
 		if err != nil {
-			return err
+			log.Fatal(err)
 		}
-		// NOTE: shelling out here because I couldn't figure out how
-		//       to extract symlinks using archive/tar
-		cmd := exec.Command("tar", "-xzf", "-", "-C", dir)
-		cmd.Stdin = bytes.NewReader(data)
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to untar: %v", err)
+		*password = strings.TrimRight(string(data), "\r\n")
+	case *password != "":
+		log.Print("WARNING: using -p exposes the password in the process list, use -password-stdin")
+	default:
+		*password = readPassword("Password: ")
+	}
+	if *username == "" || *password == "" {
+		log.Fatal("username and password are required")
+	}
+	if err := VerifyCredentials(context.Background(), *username, *password); err != nil {
+		log.Fatalf("login failed: %v", err)
+	}
+	if err := SaveCredentials(dockerHubServer, *username, *password); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Login Succeeded")
+}
+
+// logoutCmd removes the stored Docker Hub credentials.
+func logoutCmd(args []string) {
+	if len(args) > 1 || (len(args) == 1 && !registry.IsDockerHub(args[0])) {
+		log.Fatal("usage: shittydocker logout (only Docker Hub is supported)")
+	}
+	removed, err := RemoveCredentials(dockerHubServer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !removed {
+		fmt.Println("Not logged in")
+		return
+	}
+	fmt.Println("Removed login credentials")
+}
+
+// readPassword prompts for a password without echoing it.
+func readPassword(prompt string) string {
+	fmt.Print(prompt)
+	// NOTE: shelling out since the stdlib can't turn off terminal echo
+	if isTerminal(os.Stdin) {
+		stty := exec.Command("stty", "-echo")
+		stty.Stdin = os.Stdin
+		if err := stty.Run(); err == nil {
+			defer func() {
+				restore := exec.Command("stty", "echo")
+				restore.Stdin = os.Stdin
+				restore.Run()
+				fmt.Println()
+			}()
 		}
 	}
-	return nil
+	var password string
+	fmt.Scanln(&password)
+	return password
 }
 
-func FetchRegistryToken(library, image string) (string, error) {
-	var body struct {
-		Token string `json:"token"`
+// pullCmd fetches images into the local cache, so they can be
+// run later with -pull never.
+func pullCmd(args []string) {
+	flags := flag.NewFlagSet("pull", flag.ExitOnError)
+	registryCA := flags.String("registry-cacert", "", "PEM file of extra CA certificates to trust for registries")
+	var insecureRegistries StringList
+	flags.Var(&insecureRegistries, "insecure-registry", "registry host[:port] to pull from over plain http or unverified https (repeatable)")
+	rateLimit := flags.String("pull-rate-limit", "", "limit layer downloads to this many bytes per second, e.g. 10m")
+	quiet := flags.Bool("quiet", false, "only print the digests of the pulled images")
+	timeout := flags.Duration("pull-timeout", 0, "give up on pulling an image after this long, e.g. 10m (default no timeout)")
+	platform := flags.String("platform", "", "pull for this platform instead of the host's, e.g. linux/arm/v7")
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		log.Fatal("usage: shittydocker pull [-registry-cacert file] [-insecure-registry host] [-pull-rate-limit rate] [-platform os/arch] [-quiet] <image>...")
+	}
+	if *platform != "" {
+		setPullPlatform(*platform)
+	}
+	if !*quiet {
+		pullProgress = NewProgress(os.Stderr, isTerminal(os.Stderr))
 	}
-	url := fmt.Sprintf("https://auth.docker.io/token?service=registry.docker.io&scope=repository:%s/%s:pull", library, image)
-	res, err := http.DefaultClient.Get(url)
+	if *registryCA != "" {
+		if err := AddRegistryCA(*registryCA); err != nil {
+			log.Fatalf("invalid -registry-cacert: %v", err)
+		}
+	}
+	for _, host := range insecureRegistries {
+		AddInsecureRegistry(host)
+	}
+	if *rateLimit != "" {
+		rate, err := ParseBytes(*rateLimit)
+		if err != nil || rate <= 0 {
+			log.Fatalf("invalid -pull-rate-limit: %q", *rateLimit)
+		}
+		pullLimit = NewTokenBucket(rate)
+	}
+	// cancelling on ^C lets partial extractions clean up after themselves
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	for _, image := range flags.Args() {
+		pullCtx, cancel := withTimeout(ctx, *timeout)
+		digest, err := PullImage(pullCtx, image)
+		cancel()
+		if err != nil {
+			log.Fatalf("failed to pull %s: %v", image, err)
+		}
+		fmt.Printf("%s: %s\n", image, digest)
+	}
+}
+
+// tagsCmd lists the tags of a repository.
+func tagsCmd(args []string) {
+	if len(args) != 1 {
+		log.Fatal("usage: shittydocker tags <image>")
+	}
+	ref, err := registry.ParseReference(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	tags, err := registryClient.Tags(context.Background(), ref.Repository)
 	if err != nil {
-		return "", err
+		log.Fatalf("failed to list tags: %v", err)
 	}
-	defer res.Body.Close()
-	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
-		return "", err
+	for _, tag := range tags {
+		fmt.Println(tag)
 	}
-	return body.Token, nil
 }
 
-type Platform struct {
-	Architecture string `json:"architecture"`
-	OS           string `json:"os"`
+// imagesCmd lists the images in the local index.
+func imagesCmd(args []string) {
+	flags := flag.NewFlagSet("images", flag.ExitOnError)
+	format := flags.String("format", "table", "output format: table or json")
+	flags.Parse(args)
+	if *format != "table" && *format != "json" {
+		log.Fatalf("invalid format %q: expected table or json", *format)
+	}
+	images, err := ListImages()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *format == "json" {
+		err = WriteImageListJSON(os.Stdout, images)
+	} else {
+		err = WriteImageList(os.Stdout, images, time.Now())
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
-type Layer struct {
-	MediaType string `json:"mediaType"`
-	Size      int    `json:"size"`
-	Digest    string `json:"digest"`
+// rmiCmd removes images from the local index.
+func rmiCmd(args []string) {
+	if len(args) == 0 {
+		log.Fatal("usage: shittydocker rmi <image>...")
+	}
+	failed := false
+	for _, name := range args {
+		ref, err := registry.ParseReference(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		report, err := RemoveImage(ref)
+		if err != nil {
+			log.Print(err)
+			failed = true
+			continue
+		}
+		fmt.Printf("Untagged: %s\n", ref)
+		for _, id := range report.Snapshots {
+			fmt.Printf("Deleted: %s\n", id)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
 }
 
-type Manifest struct {
-	Annotations map[string]string `json:"annotations"`
-	Digest      string            `json:"digest"`
-	MediaType   string            `json:"mediaType"`
-	Platform    Platform          `json:"platform"`
-	Size        int               `json:"size"`
+// psCmd lists the containers.
+func psCmd(args []string) {
+	flags := flag.NewFlagSet("ps", flag.ExitOnError)
+	all := flags.Bool("a", false, "show all containers, not just the running ones")
+	flags.Parse(args)
+	containers, err := ListContainers()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := WriteContainerList(os.Stdout, containers, *all, time.Now()); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func ListManifests(library, image, token string) ([]Manifest, error) {
-	url := fmt.Sprintf("https://registry.hub.docker.com/v2/%s/%s/manifests/latest", library, image)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// logsCmd writes the output of a detached container.
+func logsCmd(args []string) {
+	flags := flag.NewFlagSet("logs", flag.ExitOnError)
+	follow := flags.Bool("f", false, "keep streaming new output until the container stops")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("usage: shittydocker logs [-f] <container>")
+	}
+	c, dir, err := FindContainer(flags.Arg(0))
 	if err != nil {
-		return nil, err
+		log.Fatal(err)
+	}
+	f, err := os.Open(ContainerLogPath(dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("%s has no logs, only detached containers are logged", c.ID)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	running := func() bool {
+		c, _, err := FindContainer(c.ID)
+		return err == nil && c.Running()
+	}
+	if err := CopyLogs(f, os.Stdout, os.Stderr, *follow, running); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// attachCmd connects the terminal to a container run with -d and -t.
+func attachCmd(args []string) {
+	flags := flag.NewFlagSet("attach", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("usage: shittydocker attach <container>")
+	}
+	c, dir, err := FindContainer(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !c.Running() {
+		log.Fatalf("%s isn't running", c.ID)
+	}
+	detached, err := AttachConsole(ContainerConsolePath(dir), c.Config.Interactive)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("%s has no terminal to attach to, only containers run with -d and -t have one", c.ID)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if detached {
+		return
+	}
+	state, err := WaitContainer(c.ID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(state.ExitCode)
+}
+
+// execCmd runs a command in a running container.
+func execCmd(args []string) {
+	flags := flag.NewFlagSet("exec", flag.ExitOnError)
+	var env StringList
+	flags.Var(&env, "e", "set an environment variable, KEY=value (repeatable)")
+	user := flags.String("u", "", "user to run as, name|uid[:group|gid] (default the container's)")
+	workdir := flags.String("w", "", "working directory in the container (default the container's)")
+	detach := flags.Bool("d", false, "run the command in the background")
+	interactive := flags.Bool("i", false, "keep stdin attached")
+	tty := flags.Bool("t", false, "allocate a pty for the command")
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		log.Fatal("usage: shittydocker exec [-d] [-i] [-t] [-e KEY=value] [-u user] [-w dir] <container> <command>...")
+	}
+	if *detach && (*interactive || *tty) {
+		log.Fatal("-d can't be used with -i or -t")
+	}
+	if Rootless() {
+		log.Fatal("exec can't join the user namespace of rootless containers")
+	}
+	c, dir, err := FindContainer(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !c.Running() {
+		log.Fatalf("%s isn't running", c.ID)
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
-	res, err := http.DefaultClient.Do(req)
+	cmd, err := ExecCommand(c, dir, flags.Args()[1:], ExecOptions{
+		Env:        env,
+		User:       *user,
+		WorkingDir: *workdir,
+	})
 	if err != nil {
-		return nil, err
+		log.Fatal(err)
+	}
+	var term *Terminal
+	switch {
+	case *detach:
+		// it's left to the container's init once this exits
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = nil, nil, nil
+		cmd.SysProcAttr.Setsid = true
+	case *tty:
+		if term, err = AttachTerminal(cmd, *interactive); err != nil {
+			log.Fatalf("failed to allocate a pty: %v", err)
+		}
+	case !*interactive:
+		cmd.Stdin = nil
+	}
+	if err := StartExec(cmd, c); err != nil {
+		log.Fatal(err)
+	}
+	if *detach {
+		return
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, forwardedSignals...)
+	go func() {
+		for sig := range sigs {
+			cmd.Process.Signal(sig)
+		}
+	}()
+	if term != nil {
+		term.Start()
+	}
+	err = cmd.Wait()
+	if term != nil {
+		term.Close()
+	}
+	if cmd.ProcessState == nil {
+		log.Fatal(err)
+	}
+	code, _ := ExitStatus(cmd.ProcessState.Sys().(syscall.WaitStatus))
+	os.Exit(code)
+}
+
+// stopCmd stops running containers.
+func stopCmd(args []string) {
+	flags := flag.NewFlagSet("stop", flag.ExitOnError)
+	timeout := flags.Duration("t", 10*time.Second, "how long to wait after SIGTERM before sending SIGKILL")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		log.Fatal("usage: shittydocker stop [-t timeout] <container>...")
+	}
+	failed := false
+	for _, id := range flags.Args() {
+		c, _, err := FindContainer(id)
+		if err == nil {
+			var sig syscall.Signal
+			if sig, err = StopContainer(c, *timeout); err == nil {
+				err = CleanupContainer(c.ID, 128+int(sig))
+			}
+		}
+		if err != nil {
+			log.Print(err)
+			failed = true
+			continue
+		}
+		fmt.Println(id)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// inspectCmd prints containers and images as json.
+func inspectCmd(args []string) {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	format := flags.String("format", "", "go template to format each object with, e.g. {{.State.ExitCode}}")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		log.Fatal("usage: shittydocker inspect [-format template] <container|image>...")
+	}
+	var tmpl *template.Template
+	if *format != "" {
+		var err error
+		if tmpl, err = ParseInspectFormat(*format); err != nil {
+			log.Fatalf("invalid -format: %v", err)
+		}
+	}
+	failed := false
+	var objects []any
+	for _, name := range flags.Args() {
+		v, err := Inspect(name)
+		if err != nil {
+			log.Print(err)
+			failed = true
+			continue
+		}
+		objects = append(objects, v)
 	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	if err := WriteInspect(os.Stdout, objects, tmpl); err != nil {
+		log.Fatal(err)
 	}
-	var body struct {
-		Manifests []Manifest `json:"manifests"`
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// rmCmd removes stopped containers.
+func rmCmd(args []string) {
+	flags := flag.NewFlagSet("rm", flag.ExitOnError)
+	force := flags.Bool("f", false, "kill the container first if it's running")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		log.Fatal("usage: shittydocker rm [-f] <container>...")
 	}
-	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
-		return nil, err
+	failed := false
+	for _, id := range flags.Args() {
+		if err := RemoveContainer(id, *force); err != nil {
+			log.Print(err)
+			failed = true
+			continue
+		}
+		fmt.Println(id)
+	}
+	if failed {
+		os.Exit(1)
 	}
-	return body.Manifests, nil
 }
 
-func FindManifest(manifests []Manifest, platform Platform) (Manifest, bool) {
-	for _, m := range manifests {
-		if m.Platform == platform {
-			return m, true
+// waitCmd waits for containers to exit and prints their exit codes.
+func waitCmd(args []string) {
+	flags := flag.NewFlagSet("wait", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		log.Fatal("usage: shittydocker wait <container>...")
+	}
+	failed := false
+	for _, id := range flags.Args() {
+		state, err := WaitContainer(id)
+		if err != nil {
+			log.Print(err)
+			failed = true
+			continue
+		}
+		if state.OOMKilled {
+			log.Printf("%s was killed by the OOM killer", id)
 		}
+		fmt.Println(state.ExitCode)
+	}
+	if failed {
+		os.Exit(1)
 	}
-	return Manifest{}, false
 }
 
-func ListLayers(library, image string, m Manifest, token string) ([]Layer, error) {
-	url := fmt.Sprintf("https://registry.hub.docker.com/v2/%s/%s/manifests/%s", library, image, m.Digest)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// killCmd sends a signal to running containers.
+func killCmd(args []string) {
+	flags := flag.NewFlagSet("kill", flag.ExitOnError)
+	signal := flags.String("s", "KILL", "signal to send, by name or number")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		log.Fatal("usage: shittydocker kill [-s signal] <container>...")
+	}
+	sig, err := ParseSignal(*signal)
 	if err != nil {
-		return nil, err
+		log.Fatal(err)
+	}
+	failed := false
+	for _, id := range flags.Args() {
+		c, _, err := FindContainer(id)
+		if err == nil {
+			err = KillContainer(c, sig)
+		}
+		if err == nil && sig == syscall.SIGKILL && waitExited(c, 10*time.Second) {
+			err = CleanupContainer(c.ID, 128+int(sig))
+		}
+		if err != nil {
+			log.Print(err)
+			failed = true
+			continue
+		}
+		fmt.Println(id)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// systemCmd implements the system subcommands.
+func systemCmd(args []string) {
+	if len(args) < 1 {
+		log.Fatal("usage: shittydocker system gc|df|prune|info [flags]")
+	}
+	switch args[0] {
+	case "gc":
+		systemGCCmd(args[1:])
+	case "df":
+		systemDFCmd(args[1:])
+	case "prune":
+		systemPruneCmd(args[1:])
+	case "info":
+		info, err := SystemInfo()
+		if err != nil {
+			log.Fatal(err)
+		}
+		info.Write(os.Stdout)
+	default:
+		log.Fatalf("unknown system command: %s", args[0])
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	res, err := http.DefaultClient.Do(req)
+}
+
+func systemDFCmd(args []string) {
+	flags := flag.NewFlagSet("system df", flag.ExitOnError)
+	verbose := flags.Bool("v", false, "show the usage of every object")
+	flags.Parse(args)
+	report, err := SystemDiskUsage()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := report.Write(os.Stdout, *verbose); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func systemPruneCmd(args []string) {
+	flags := flag.NewFlagSet("system prune", flag.ExitOnError)
+	all := flags.Bool("all", false, "also remove all unused snapshots and the manifest cache")
+	force := flags.Bool("force", false, "don't prompt for confirmation")
+	flags.Parse(args)
+	if !*force {
+		warning := "WARNING! This will remove:\n  - all stopped containers\n  - incomplete layer extractions\n  - blobs not used by a local image\n"
+		if *all {
+			warning += "  - all layer snapshots not used by a running container\n  - the manifest cache\n"
+		}
+		fmt.Print(warning)
+		if !confirm("Are you sure you want to continue?") {
+			return
+		}
+	}
+	report, err := SystemPrune(*all)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, id := range report.Containers {
+		fmt.Printf("deleted container %s\n", id)
+	}
+	for _, id := range report.Snapshots {
+		fmt.Printf("deleted snapshot %s\n", id)
+	}
+	for _, digest := range report.Blobs {
+		fmt.Printf("deleted blob %s\n", digest)
+	}
+	if report.Manifests {
+		fmt.Println("deleted manifest cache")
+	}
+	fmt.Printf("total reclaimed space: %s\n", HumanSize(report.Reclaimed))
+}
+
+func systemGCCmd(args []string) {
+	flags := flag.NewFlagSet("system gc", flag.ExitOnError)
+	var policy GCPolicy
+	var maxSize string
+	flags.DurationVar(&policy.MaxAge, "max-age", 0, "remove snapshots which haven't been used for this long, e.g. 168h")
+	flags.StringVar(&maxSize, "max-size", "", "remove the least recently used snapshots until the cache is at most this size, e.g. 10g")
+	flags.BoolVar(&policy.DryRun, "dry-run", false, "only print what would be removed")
+	flags.Parse(args)
+	if maxSize != "" {
+		var err error
+		policy.MaxSize, err = ParseBytes(maxSize)
+		if err != nil {
+			log.Fatalf("invalid -max-size: %v", err)
+		}
+	}
+	if policy.MaxAge == 0 && maxSize == "" {
+		log.Fatal("at least one of -max-age or -max-size is required")
+	}
+	removed, err := GarbageCollect(policy)
 	if err != nil {
-		return nil, err
+		log.Fatal(err)
 	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	verb := "removed"
+	if policy.DryRun {
+		verb = "would remove"
 	}
-	var body struct {
-		Layers []Layer `json:"layers"`
+	var total int64
+	for _, s := range removed {
+		fmt.Printf("%s snapshot %s (%s)\n", verb, s.ID, HumanSize(s.Size))
+		total += s.Size
 	}
-	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
-		return nil, err
+	fmt.Printf("total reclaimed space: %s\n", HumanSize(total))
+}
+
+// NewContainerID returns a random 64 character hex id.
+func NewContainerID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
 	}
-	return body.Layers, nil
+	return hex.EncodeToString(b)
 }
 
-func FetchLayer(library, image string, l Layer, token string) ([]byte, error) {
-	url := fmt.Sprintf("https://registry.hub.docker.com/v2/%s/%s/blobs/%s", library, image, l.Digest)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+func FetchImageTo(ctx context.Context, library, image, dir string) error {
+	repo := Repository{Registry: registry.DockerHub, Library: library, Image: image}
+	manifest, err := registryClient.ResolveManifest(ctx, Reference{Repository: repo, Tag: "latest"}, registry.HostPlatform())
 	if err != nil {
-		return nil, err
+		return err
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	res, err := http.DefaultClient.Do(req)
+	m, err := registryClient.ImageManifest(ctx, repo, manifest)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	for _, layer := range m.Layers {
+		log.Printf("downloading layer %s: %s", repo, layer.Digest)
+		if err := fetchLayerTo(ctx, repo, layer, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchLayerTo streams the layer into tar so it's never held in memory.
+func fetchLayerTo(ctx context.Context, repo Repository, layer Layer, dir string) error {
+	body, err := openLayer(ctx, repo, layer)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return ExtractLayer(body, dir, "")
+}
+
+// setPullPlatform sets the platform images are pulled for from a -platform flag.
+func setPullPlatform(s string) {
+	p, err := registry.ParsePlatform(s)
+	if err != nil {
+		log.Fatalf("invalid -platform: %v", err)
+	}
+	pullPlatform = p
+}
+
+// withTimeout returns a context which is cancelled after the timeout,
+// or only when cancel is called if the timeout is zero.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// StringList is a flag.Value which collects repeated flags.
+type StringList []string
+
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *StringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// ParseKeyValues parses a list of key=value pairs into a map.
+// A missing value is treated as empty.
+func ParseKeyValues(list []string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, kv := range list {
+		key, value, _ := strings.Cut(kv, "=")
+		if key == "" {
+			return nil, fmt.Errorf("missing key in %q", kv)
+		}
+		m[key] = value
 	}
-	return io.ReadAll(res.Body)
+	return m, nil
 }
@@ -1,12 +1,13 @@
 package main
 
 import (
+	"context"
 	"testing"
 )
 
 func TestFetchImageTo(t *testing.T) {
 	dir := t.TempDir()
-	err := FetchImageTo("library", "busybox", dir)
+	err := FetchImageTo(context.Background(), "library", "busybox", dir)
 	if err != nil {
 		t.Fatal(err)
 	}
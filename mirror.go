@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+// BlobPath returns where a blob is kept in the blob store.
+func BlobPath(digest string) (string, error) {
+	algo, hex, ok := strings.Cut(digest, ":")
+	if !ok || algo != "sha256" || len(hex) != 64 || strings.ContainsAny(hex, "./") {
+		return "", fmt.Errorf("invalid digest: %q", digest)
+	}
+	root, err := DataRoot()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "blobs", algo, hex), nil
+}
+
+// FetchBlob makes sure the blob is in the blob store and returns its path.
+// Downloads are verified against the digest before they're added.
+func FetchBlob(ctx context.Context, repo Repository, digest string) (string, error) {
+	p, err := BlobPath(digest)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(p); err == nil {
+		return p, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	body, err := openLayer(ctx, repo, Layer{Digest: digest})
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".download-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	// openLayer fails the copy if the blob doesn't match the digest
+	if _, err := io.Copy(tmp, body); err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return "", err
+	}
+	return p, os.Rename(tmp.Name(), p)
+}
+
+// Mirror is a read-only registry which serves images from the local cache
+// and pulls anything missing from Docker Hub, so it can be used as a
+// registry mirror for other hosts.
+type Mirror struct{}
+
+// ParseRegistryPath splits a registry api path like
+// /v2/library/alpine/manifests/latest into its parts.
+func ParseRegistryPath(p string) (library, image, kind, reference string, ok bool) {
+	rest, ok := strings.CutPrefix(p, "/v2/")
+	if !ok {
+		return "", "", "", "", false
+	}
+	for _, kind := range []string{"manifests", "blobs"} {
+		name, reference, ok := strings.Cut(rest, "/"+kind+"/")
+		if !ok || reference == "" || strings.Contains(reference, "/") {
+			continue
+		}
+		library, image, ok := strings.Cut(name, "/")
+		if !ok {
+			// official images can be referenced without the library
+			library, image = "library", name
+		}
+		if !validRegistryPath(library, image, kind, reference) {
+			return "", "", "", "", false
+		}
+		return library, image, kind, reference, true
+	}
+	return "", "", "", "", false
+}
+
+// validRegistryPath reports whether the parts of a registry api path make up
+// a valid Docker Hub reference, so they can't reach outside of the cache when
+// they're used in paths. Blobs are only referenced by digest.
+func validRegistryPath(library, image, kind, reference string) bool {
+	if kind == "blobs" && !registry.IsDigest(reference) {
+		return false
+	}
+	s := "docker.io/" + library + "/" + image + ":" + reference
+	if registry.IsDigest(reference) {
+		s = "docker.io/" + library + "/" + image + "@" + reference
+	}
+	ref, err := registry.ParseReference(s)
+	return err == nil && ref.Library == library && ref.Image == image
+}
+
+func (m *Mirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "the mirror is read-only", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	if r.URL.Path == "/v2/" || r.URL.Path == "/v2" {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "{}")
+		return
+	}
+	library, image, kind, reference, ok := ParseRegistryPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	log.Printf("%s %s/%s %s %s", r.Method, library, image, kind, reference)
+	repo := Repository{Registry: registry.DockerHub, Library: library, Image: image}
+	if kind == "blobs" {
+		m.serveBlob(w, r, repo, reference)
+	} else {
+		m.serveManifest(w, r, repo, reference)
+	}
+}
+
+func (m *Mirror) serveBlob(w http.ResponseWriter, r *http.Request, repo Repository, digest string) {
+	p, err := FetchBlob(r.Context(), repo, digest)
+	if err != nil {
+		log.Printf("failed to fetch blob %s: %v", digest, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest)
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
+
+func (m *Mirror) serveManifest(w http.ResponseWriter, r *http.Request, repo Repository, reference string) {
+	data, err := m.fetchManifest(r.Context(), repo, reference, r.Header.Get("Accept"))
+	if err != nil {
+		log.Printf("failed to fetch manifest %s:%s: %v", repo, reference, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	mediaType, err := registry.ManifestMediaType(data)
+	if err != nil {
+		mediaType = registry.MediaTypeDockerManifest
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Docker-Content-Digest", fmt.Sprintf("sha256:%x", sha256.Sum256(data)))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// fetchManifest gets the manifest from Docker Hub and falls
+// back to the cached copy when the hub can't be reached.
+func (m *Mirror) fetchManifest(ctx context.Context, repo Repository, reference, accept string) ([]byte, error) {
+	data, err := registryClient.Manifest(ctx, repo, reference, accept)
+	if err == nil {
+		return data, nil
+	}
+	if cached, _, ok := ReadCachedManifest(repo, reference); ok {
+		log.Printf("WARNING: serving cached manifest for %s:%s: %v", repo, reference, err)
+		return cached, nil
+	}
+	return nil, err
+}
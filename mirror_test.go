@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRegistryPath(t *testing.T) {
+	tests := []struct {
+		path                            string
+		library, image, kind, reference string
+		ok                              bool
+	}{
+		{"/v2/library/alpine/manifests/latest", "library", "alpine", "manifests", "latest", true},
+		{"/v2/alpine/manifests/3.19", "library", "alpine", "manifests", "3.19", true},
+		{"/v2/bitnami/redis/blobs/sha256:abababababababababababababababababababababababababababababababab", "bitnami", "redis", "blobs", "sha256:abababababababababababababababababababababababababababababababab", true},
+		{"/v2/bitnami/redis/blobs/latest", "", "", "", "", false},
+		{"/v2/library/../manifests/latest", "", "", "", "", false},
+		{"/v2/../alpine/manifests/latest", "", "", "", "", false},
+		{"/v2/library/alpine/manifests/..", "", "", "", "", false},
+		{"/v2/library/alpine/manifests/.", "", "", "", "", false},
+		{"/v2/library/Alpine/manifests/latest", "", "", "", "", false},
+		{"/v2/library/alpine/tags/list", "", "", "", "", false},
+		{"/v2/a/b/c/manifests/latest", "", "", "", "", false},
+		{"/v1/library/alpine/manifests/latest", "", "", "", "", false},
+	}
+	for _, tt := range tests {
+		library, image, kind, reference, ok := ParseRegistryPath(tt.path)
+		if ok != tt.ok || library != tt.library || image != tt.image || kind != tt.kind || reference != tt.reference {
+			t.Errorf("ParseRegistryPath(%q) = %q, %q, %q, %q, %v", tt.path, library, image, kind, reference, ok)
+		}
+	}
+}
+
+func TestMirrorServesCachedBlob(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	data := []byte("layer data")
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	p, err := BlobPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(&Mirror{})
+	defer srv.Close()
+	res, err := http.Get(srv.URL + "/v2/library/alpine/blobs/" + digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || string(body) != string(data) {
+		t.Fatalf("unexpected response: %d %q", res.StatusCode, body)
+	}
+	if got := res.Header.Get("Docker-Content-Digest"); got != digest {
+		t.Errorf("unexpected digest header: %q", got)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// MountShm mounts a tmpfs of the given size at /dev/shm in the rootfs
+// and returns the mount point.
+func MountShm(rootfs string, size int64) (string, error) {
+	target := filepath.Join(rootfs, "dev", "shm")
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return "", err
+	}
+	data := fmt.Sprintf("mode=1777,size=%d", size)
+	if err := syscall.Mount("shm", target, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, data); err != nil {
+		return "", fmt.Errorf("failed to mount /dev/shm: %w", err)
+	}
+	return target, nil
+}
+
+// MountOverlay mounts the layers, given lowest first, at target with
+// upper and work holding the container's writable layer.
+func MountOverlay(layers []string, upper, work, target string) error {
+	lower := make([]string, len(layers))
+	for i, dir := range layers {
+		lower[len(layers)-1-i] = dir
+	}
+	data := "lowerdir=" + strings.Join(lower, ":") + ",upperdir=" + upper + ",workdir=" + work
+	if Rootless() {
+		data += ",userxattr"
+	}
+	return mountOverlay(target, 0, data)
+}
+
+// mountOverlay mounts an overlay filesystem, falling back to fuse-overlayfs
+// when the kernel's overlayfs can't be used. That happens without the
+// privileges to mount it, when the module isn't available, or when the
+// layers are themselves on an overlay, like inside another container.
+func mountOverlay(target string, flags uintptr, data string) error {
+	err := syscall.Mount("overlay", target, "overlay", flags, data)
+	if err == nil {
+		return nil
+	}
+	if err != syscall.EPERM && err != syscall.ENODEV && err != syscall.EINVAL {
+		return fmt.Errorf("failed to mount overlay: %w", err)
+	}
+	fuse, lookErr := exec.LookPath("fuse-overlayfs")
+	if lookErr != nil {
+		return fmt.Errorf("failed to mount overlay: %w (and fuse-overlayfs isn't installed)", err)
+	}
+	// fuse-overlayfs doesn't need to be told about the xattrs
+	data = strings.Replace(data, ",userxattr", "", 1)
+	if flags&syscall.MS_RDONLY != 0 {
+		data += ",ro"
+	}
+	// NOTE: shelling out since fuse-overlayfs is a separate daemon, it
+	//       forks into the background once the filesystem is mounted.
+	out, fuseErr := exec.Command(fuse, "-o", data, target).CombinedOutput()
+	if fuseErr != nil {
+		return fmt.Errorf("failed to mount overlay: %w, fuse-overlayfs: %v: %s", err, fuseErr, strings.TrimSpace(string(out)))
+	}
+	log.Printf("kernel overlayfs not available (%v), using fuse-overlayfs", err)
+	return nil
+}
+
+// Unmount unmounts the filesystem at target. Unprivileged users can't
+// unmount fuse filesystems themselves, so that goes through fusermount.
+func Unmount(target string) error {
+	err := syscall.Unmount(target, 0)
+	if err != syscall.EPERM {
+		return err
+	}
+	for _, name := range []string{"fusermount3", "fusermount"} {
+		if p, lookErr := exec.LookPath(name); lookErr == nil {
+			if out, err := exec.Command(p, "-u", target).CombinedOutput(); err != nil {
+				return fmt.Errorf("%s: %v: %s", name, err, strings.TrimSpace(string(out)))
+			}
+			return nil
+		}
+	}
+	return err
+}
+
+// MountReadOnly mounts the layers, given lowest first, at target without a
+// writable layer. Overlayfs needs at least two lower directories for that, so
+// an empty one is added at the bottom.
+func MountReadOnly(layers []string, target string) error {
+	root, err := DataRoot()
+	if err != nil {
+		return err
+	}
+	empty := filepath.Join(root, "empty")
+	if err := os.MkdirAll(empty, 0755); err != nil {
+		return err
+	}
+	lower := []string{}
+	for i := len(layers) - 1; i >= 0; i-- {
+		lower = append(lower, layers[i])
+	}
+	lower = append(lower, empty)
+	data := "lowerdir=" + strings.Join(lower, ":")
+	if Rootless() {
+		data += ",userxattr"
+	}
+	return mountOverlay(target, syscall.MS_RDONLY, data)
+}
+
+// MountedLayers returns the lower directories of all mounted overlays.
+func MountedLayers() (map[string]bool, error) {
+	data, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	layers := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		// the super block options follow the filesystem type and source
+		_, after, ok := strings.Cut(line, " - overlay ")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(after)
+		if len(fields) < 2 {
+			continue
+		}
+		addLowerDirs(layers, fields[1], unescapeMountPath)
+	}
+	// fuse-overlayfs mounts don't show their options in mountinfo,
+	// but they're on the command line of the daemons.
+	procs, _ := filepath.Glob("/proc/[0-9]*/cmdline")
+	for _, p := range procs {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		args := strings.Split(string(data), "\x00")
+		if filepath.Base(args[0]) != "fuse-overlayfs" {
+			continue
+		}
+		for i := 1; i < len(args)-1; i++ {
+			if args[i] == "-o" {
+				addLowerDirs(layers, args[i+1], func(s string) string { return s })
+			}
+		}
+	}
+	return layers, nil
+}
+
+// addLowerDirs adds the lowerdirs in the overlay mount options to layers.
+func addLowerDirs(layers map[string]bool, options string, unescape func(string) string) {
+	for _, opt := range strings.Split(options, ",") {
+		if lower, ok := strings.CutPrefix(opt, "lowerdir="); ok {
+			for _, dir := range strings.Split(lower, ":") {
+				layers[unescape(dir)] = true
+			}
+		}
+	}
+}
+
+// MountPoints returns the set of mount points in the current mount namespace.
+func MountPoints() (map[string]bool, error) {
+	data, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	mounts := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		mounts[unescapeMountPath(fields[4])] = true
+	}
+	return mounts, nil
+}
+
+// unescapeMountPath decodes the octal escapes used in mountinfo, like \040 for space.
+func unescapeMountPath(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"path/filepath"
+	"regexp"
+)
+
+// nameAdjectives and nameScientists are combined into the generated
+// container names, like docker's brave_curie.
+var nameAdjectives = []string{
+	"admiring", "amazing", "angry", "awesome", "blissful", "bold", "brave",
+	"busy", "charming", "clever", "compassionate", "confident", "cool",
+	"dazzling", "determined", "eager", "ecstatic", "elegant", "epic",
+	"festive", "focused", "friendly", "funny", "gallant", "gifted",
+	"goofy", "gracious", "happy", "hopeful", "hungry", "inspiring",
+	"jolly", "keen", "kind", "laughing", "loving", "lucid", "magical",
+	"modest", "musing", "naughty", "nervous", "nice", "nostalgic",
+	"optimistic", "peaceful", "pensive", "practical", "priceless", "quirky",
+	"quizzical", "relaxed", "reverent", "romantic", "serene", "sharp",
+	"silly", "sleepy", "stoic", "strange", "suspicious", "sweet", "tender",
+	"trusting", "upbeat", "vibrant", "vigilant", "wizardly", "wonderful",
+	"xenodochial", "youthful", "zealous", "zen",
+}
+
+var nameScientists = []string{
+	"agnesi", "archimedes", "babbage", "bardeen", "bell", "bohr", "boole",
+	"borg", "carson", "cerf", "chandrasekhar", "curie", "darwin", "dijkstra",
+	"einstein", "euclid", "euler", "faraday", "fermat", "fermi", "feynman",
+	"franklin", "galileo", "gauss", "goldberg", "goodall", "hamilton",
+	"hawking", "heisenberg", "hodgkin", "hopper", "hypatia", "jennings",
+	"johnson", "kepler", "knuth", "lamarr", "lamport", "leakey", "liskov",
+	"lovelace", "maxwell", "mayer", "mcclintock", "meitner", "mendel",
+	"mirzakhani", "morse", "newton", "nobel", "noether", "pascal",
+	"pasteur", "payne", "perlman", "pike", "poincare", "ramanujan",
+	"ritchie", "shannon", "shockley", "sinoussi", "swanson",
+	"tesla", "thompson", "torvalds", "turing", "volhard", "wescoff",
+	"wilbur", "wiles", "williams", "wozniak", "wright", "yalow", "yonath",
+}
+
+// validName matches the names docker accepts for containers.
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// ValidateContainerName checks a -name value.
+func ValidateContainerName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid container name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	return nil
+}
+
+// generateName returns a random adjective_scientist name which isn't in
+// used, with a number appended once the plain ones start colliding.
+func generateName(used map[string]bool) string {
+	for i := 0; ; i++ {
+		name := nameAdjectives[rand.IntN(len(nameAdjectives))] + "_" + nameScientists[rand.IntN(len(nameScientists))]
+		if i >= 10 {
+			name += fmt.Sprint(rand.IntN(10 * i))
+		}
+		if !used[name] {
+			return name
+		}
+	}
+}
+
+// CreateContainer writes the first record of a new container to dir, under
+// a generated name if it doesn't have one. Names have to be unique, which
+// is checked under a lock so containers created at the same time can't
+// end up with the same one.
+func CreateContainer(dir string, c *Container) error {
+	root, err := DataRoot()
+	if err != nil {
+		return err
+	}
+	lock, err := LockFile(filepath.Join(root, "names.lock"), true)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	containers, err := ListContainers()
+	if err != nil {
+		return err
+	}
+	used := map[string]bool{}
+	for _, other := range containers {
+		if c.Name != "" && other.Name == c.Name {
+			return fmt.Errorf("the container name %q is already in use by %s", c.Name, other.ID[:min(12, len(other.ID))])
+		}
+		used[other.Name] = true
+	}
+	if c.Name == "" {
+		c.Name = generateName(used)
+	}
+	return WriteContainer(dir, *c)
+}
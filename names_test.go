@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateContainer(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir, err := ContainersDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(c *Container) error {
+		if err := os.MkdirAll(filepath.Join(dir, c.ID), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return CreateContainer(filepath.Join(dir, c.ID), c)
+	}
+	web := &Container{ID: "abc123", Name: "web"}
+	if err := create(web); err != nil {
+		t.Fatal(err)
+	}
+	if err := create(&Container{ID: "abd456", Name: "web"}); err == nil {
+		t.Error("expected a duplicate name to fail")
+	}
+	generated := &Container{ID: "def789"}
+	if err := create(generated); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateContainerName(generated.Name); err != nil {
+		t.Errorf("generated an invalid name: %v", err)
+	}
+	c, _, err := FindContainer("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != "abc123" {
+		t.Errorf("found %s by name, want abc123", c.ID)
+	}
+}
+
+func TestValidateContainerName(t *testing.T) {
+	for _, name := range []string{"web", "my-app.1", "brave_curie"} {
+		if err := ValidateContainerName(name); err != nil {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "a", "-web", "web/1", "my app"} {
+		if err := ValidateContainerName(name); err == nil {
+			t.Errorf("%q: expected error", name)
+		}
+	}
+}
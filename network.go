@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strings"
+)
+
+// NetworkConfig attaches the container to a host interface with a macvlan
+// or ipvlan link, so it shows up on the LAN with its own address, or to the
+// bridge network with a veth pair.
+type NetworkConfig struct {
+	// Driver is macvlan, ipvlan or bridge.
+	Driver string `json:"driver"`
+	// Parent is the host interface the link is created on.
+	Parent  string       `json:"parent,omitempty"`
+	Address netip.Prefix `json:"address"`
+	Gateway netip.Addr   `json:"gateway"`
+}
+
+// ParseNetwork parses a -network value like macvlan:eth0 or bridge.
+func ParseNetwork(s string) (NetworkConfig, error) {
+	if s == "bridge" {
+		return NetworkConfig{Driver: s}, nil
+	}
+	driver, parent, ok := strings.Cut(s, ":")
+	if !ok || parent == "" {
+		return NetworkConfig{}, fmt.Errorf("invalid network %q: expected bridge, macvlan:<parent> or ipvlan:<parent>", s)
+	}
+	if driver != "macvlan" && driver != "ipvlan" {
+		return NetworkConfig{}, fmt.Errorf("unknown network driver: %s", driver)
+	}
+	return NetworkConfig{Driver: driver, Parent: parent}, nil
+}
+
+// linkArgs returns the ip link type arguments for the driver. macvlan links
+// in bridge mode can talk to each other, and ipvlan l2 is the closest
+// equivalent for parents which only allow one mac address.
+func (n NetworkConfig) linkArgs() []string {
+	if n.Driver == "ipvlan" {
+		return []string{"type", "ipvlan", "mode", "l2"}
+	}
+	return []string{"type", "macvlan", "mode", "bridge"}
+}
+
+// SetupNetwork creates the container's link on the parent interface, or a veth
+// pair on the bridge, moves it into the network namespace of the process, and
+// configures it as eth0. The link is destroyed along with the namespace when
+// the container exits.
+func SetupNetwork(n NetworkConfig, pid int, id string) error {
+	// interface names are limited to 15 characters
+	link := "sd" + id[:12]
+	if n.Driver == "bridge" {
+		if err := addVeth(link, "sdc"+id[:12], pid); err != nil {
+			return err
+		}
+		link = "sdc" + id[:12]
+	} else {
+		args := append([]string{"link", "add", "link", n.Parent, "name", link}, n.linkArgs()...)
+		if err := ipCommand("", args...); err != nil {
+			return err
+		}
+		if err := ipCommand("", "link", "set", link, "netns", fmt.Sprint(pid)); err != nil {
+			ipCommand("", "link", "del", link)
+			return err
+		}
+	}
+	netns := fmt.Sprintf("/proc/%d/ns/net", pid)
+	steps := [][]string{
+		{"link", "set", "lo", "up"},
+		{"link", "set", link, "name", "eth0"},
+		{"addr", "add", n.Address.String(), "dev", "eth0"},
+		{"link", "set", "eth0", "up"},
+	}
+	if n.Gateway.IsValid() {
+		steps = append(steps, []string{"route", "add", "default", "via", n.Gateway.String()})
+	}
+	for _, args := range steps {
+		if err := ipCommand(netns, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ipCommand runs ip, inside the network namespace if netns is set.
+func ipCommand(netns string, args ...string) error {
+	// NOTE: shelling out since netlink isn't in the stdlib
+	name := "ip"
+	if netns != "" {
+		args = append([]string{"--net=" + netns, "ip"}, args...)
+		name = "nsenter"
+	}
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ip %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
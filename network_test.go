@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParseNetwork(t *testing.T) {
+	n, err := ParseNetwork("macvlan:eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Driver != "macvlan" || n.Parent != "eth0" {
+		t.Errorf("unexpected network: %+v", n)
+	}
+	if args := n.linkArgs(); args[1] != "macvlan" || args[3] != "bridge" {
+		t.Errorf("unexpected link args: %v", args)
+	}
+	n, err = ParseNetwork("ipvlan:bond0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args := n.linkArgs(); args[1] != "ipvlan" || args[3] != "l2" {
+		t.Errorf("unexpected link args: %v", args)
+	}
+	if n, err := ParseNetwork("bridge"); err != nil || n.Driver != "bridge" {
+		t.Errorf("unexpected bridge network: %+v, %v", n, err)
+	}
+	for _, s := range []string{"bridge:eth0", "macvlan", "macvlan:"} {
+		if _, err := ParseNetwork(s); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestLeaseBridgeAddress(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	a, lockA, err := LeaseBridgeAddress(netip.Addr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := netip.MustParsePrefix("172.29.0.2/16"); a != want {
+		t.Errorf("got %s, want %s", a, want)
+	}
+	b, lockB, err := LeaseBridgeAddress(netip.Addr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lockB.Unlock()
+	if b == a {
+		t.Errorf("%s was leased twice", a)
+	}
+	if _, _, err := LeaseBridgeAddress(a.Addr()); err == nil {
+		t.Errorf("expected %s to be in use", a.Addr())
+	}
+	// released addresses can be leased again
+	lockA.Unlock()
+	if _, lock, err := LeaseBridgeAddress(a.Addr()); err != nil {
+		t.Error(err)
+	} else {
+		lock.Unlock()
+	}
+	for _, addr := range []string{"172.29.0.1", "10.0.0.2"} {
+		if _, _, err := LeaseBridgeAddress(netip.MustParseAddr(addr)); err == nil {
+			t.Errorf("expected %s to be rejected", addr)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Spec is the subset of the OCI runtime spec needed to hand a
+// container over to an external runtime like runsc.
+type Spec struct {
+	OCIVersion  string            `json:"ociVersion"`
+	Process     SpecProcess       `json:"process"`
+	Root        SpecRoot          `json:"root"`
+	Hostname    string            `json:"hostname,omitempty"`
+	Mounts      []SpecMount       `json:"mounts"`
+	Linux       SpecLinux         `json:"linux"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type SpecProcess struct {
+	Terminal        bool              `json:"terminal"`
+	User            SpecUser          `json:"user"`
+	Args            []string          `json:"args"`
+	Env             []string          `json:"env"`
+	Cwd             string            `json:"cwd"`
+	Capabilities    *SpecCapabilities `json:"capabilities,omitempty"`
+	NoNewPrivileges bool              `json:"noNewPrivileges,omitempty"`
+}
+
+type SpecUser struct {
+	UID            uint32   `json:"uid"`
+	GID            uint32   `json:"gid"`
+	AdditionalGids []uint32 `json:"additionalGids,omitempty"`
+	Umask          *uint32  `json:"umask,omitempty"`
+}
+
+type SpecCapabilities struct {
+	Bounding  []string `json:"bounding"`
+	Effective []string `json:"effective"`
+	Permitted []string `json:"permitted"`
+}
+
+type SpecRoot struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly"`
+}
+
+type SpecMount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type"`
+	Source      string   `json:"source"`
+	Options     []string `json:"options,omitempty"`
+}
+
+type SpecLinux struct {
+	Namespaces    []SpecNamespace   `json:"namespaces"`
+	Sysctl        map[string]string `json:"sysctl,omitempty"`
+	CgroupsPath   string            `json:"cgroupsPath,omitempty"`
+	MaskedPaths   []string          `json:"maskedPaths,omitempty"`
+	ReadonlyPaths []string          `json:"readonlyPaths,omitempty"`
+	Resources     *SpecResources    `json:"resources,omitempty"`
+}
+
+type SpecResources struct {
+	CPU    *SpecCPU    `json:"cpu,omitempty"`
+	Memory *SpecMemory `json:"memory,omitempty"`
+	Pids   *SpecPids   `json:"pids,omitempty"`
+}
+
+type SpecMemory struct {
+	Limit       *int64  `json:"limit,omitempty"`
+	Reservation *int64  `json:"reservation,omitempty"`
+	Swappiness  *uint64 `json:"swappiness,omitempty"`
+}
+
+type SpecCPU struct {
+	Shares *uint64 `json:"shares,omitempty"`
+	Quota  *int64  `json:"quota,omitempty"`
+	Period *uint64 `json:"period,omitempty"`
+}
+
+type SpecPids struct {
+	Limit int64 `json:"limit"`
+}
+
+// NewSpecResources converts the cgroup resources to their spec equivalent.
+func NewSpecResources(r CgroupResources) *SpecResources {
+	var res SpecResources
+	if r.CPUShares != 0 || r.CPUQuota != 0 {
+		res.CPU = &SpecCPU{}
+		if r.CPUShares != 0 {
+			res.CPU.Shares = &r.CPUShares
+		}
+		if r.CPUQuota != 0 {
+			res.CPU.Quota = &r.CPUQuota
+			res.CPU.Period = &r.CPUPeriod
+		}
+	}
+	if r.Memory != 0 || r.MemoryReservation != 0 || r.MemorySwappiness != nil {
+		res.Memory = &SpecMemory{Swappiness: r.MemorySwappiness}
+		if r.Memory != 0 {
+			res.Memory.Limit = &r.Memory
+		}
+		if r.MemoryReservation != 0 {
+			res.Memory.Reservation = &r.MemoryReservation
+		}
+	}
+	if r.PidsLimit != 0 {
+		res.Pids = &SpecPids{Limit: r.PidsLimit}
+	}
+	return &res
+}
+
+type SpecNamespace struct {
+	Type string `json:"type"`
+}
+
+// defaultCapabilities is the capability set docker grants containers by default.
+var defaultCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FSETID",
+	"CAP_FOWNER",
+	"CAP_MKNOD",
+	"CAP_NET_RAW",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETFCAP",
+	"CAP_SETPCAP",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SYS_CHROOT",
+	"CAP_KILL",
+	"CAP_AUDIT_WRITE",
+}
+
+// NewSpec returns a spec which runs args in the bundle's rootfs directory.
+func NewSpec(args, env []string) Spec {
+	return Spec{
+		OCIVersion: "1.0.2",
+		Process: SpecProcess{
+			Args: args,
+			Env:  env,
+			Cwd:  "/",
+			Capabilities: &SpecCapabilities{
+				Bounding:  defaultCapabilities,
+				Effective: defaultCapabilities,
+				Permitted: defaultCapabilities,
+			},
+		},
+		Root: SpecRoot{Path: "rootfs"},
+		Mounts: []SpecMount{
+			{Destination: "/proc", Type: "proc", Source: "proc"},
+			{Destination: "/dev", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid", "strictatime", "mode=755", "size=65536k"}},
+			{Destination: "/dev/pts", Type: "devpts", Source: "devpts", Options: []string{"nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620"}},
+			{Destination: "/dev/shm", Type: "tmpfs", Source: "shm", Options: []string{"nosuid", "noexec", "nodev", "mode=1777", "size=65536k"}},
+			{Destination: "/sys", Type: "sysfs", Source: "sysfs", Options: []string{"nosuid", "noexec", "nodev", "ro"}},
+		},
+		Linux: SpecLinux{
+			Namespaces: []SpecNamespace{
+				{Type: "pid"},
+				{Type: "ipc"},
+				{Type: "uts"},
+				{Type: "mount"},
+			},
+		},
+	}
+}
+
+// WriteBundle writes the spec to the bundle's config.json
+func WriteBundle(bundle string, spec Spec) error {
+	data, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(bundle, "config.json"), data, 0644)
+}
+
+// RunscCommand returns a command which runs the bundle using gVisor.
+// The container shares the host network, same as the native runtime.
+func RunscCommand(bundle, id string) (*exec.Cmd, error) {
+	path, err := exec.LookPath("runsc")
+	if err != nil {
+		return nil, fmt.Errorf("runsc runtime: %w", err)
+	}
+	cmd := exec.Command(path, "--network=host", "run", "--bundle", bundle, id)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	return cmd, nil
+}
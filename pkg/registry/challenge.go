@@ -0,0 +1,146 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AuthChallenge is a parsed WWW-Authenticate header, which tells
+// clients where to get a token for the registry.
+type AuthChallenge struct {
+	// Scheme is lowercased, bearer is the only one that's supported.
+	Scheme  string
+	Realm   string
+	Service string
+}
+
+// ParseAuthChallenge parses a WWW-Authenticate header like
+// Bearer realm="https://ghcr.io/token",service="ghcr.io".
+func ParseAuthChallenge(header string) (AuthChallenge, error) {
+	scheme, params, _ := strings.Cut(strings.TrimSpace(header), " ")
+	if scheme == "" {
+		return AuthChallenge{}, fmt.Errorf("invalid WWW-Authenticate header: %q", header)
+	}
+	c := AuthChallenge{Scheme: strings.ToLower(scheme)}
+	for params = strings.TrimSpace(params); params != ""; {
+		key, rest, ok := strings.Cut(params, "=")
+		if !ok {
+			return AuthChallenge{}, fmt.Errorf("invalid WWW-Authenticate header: %q", header)
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			// quoted values can contain commas and escaped quotes
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				b.WriteByte(rest[i])
+			}
+			if i == len(rest) {
+				return AuthChallenge{}, fmt.Errorf("invalid WWW-Authenticate header: %q", header)
+			}
+			value, rest = b.String(), rest[i+1:]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			rest = "," + rest
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "realm":
+			c.Realm = value
+		case "service":
+			c.Service = value
+		}
+		params = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest), ","))
+	}
+	return c, nil
+}
+
+// dockerHubChallenge is known ahead of time so pulling from Docker Hub
+// doesn't need the extra round trip.
+var dockerHubChallenge = AuthChallenge{Scheme: "bearer", Realm: "https://auth.docker.io/token", Service: "registry.docker.io"}
+
+// AuthChallenge asks the registry how to authenticate by making an
+// unauthenticated request to the api root. The zero AuthChallenge means
+// the registry doesn't require authentication. Challenges are cached for
+// the lifetime of the client.
+func (c *Client) AuthChallenge(ctx context.Context, registry string) (AuthChallenge, error) {
+	c.mu.Lock()
+	if c.challenges == nil {
+		c.challenges = map[string]AuthChallenge{}
+		if c.BaseURL == "" {
+			c.challenges[DockerHub] = dockerHubChallenge
+		}
+	}
+	if ch, ok := c.challenges[registry]; ok {
+		c.mu.Unlock()
+		return ch, nil
+	}
+	// like tokens, the lock isn't held while asking, so a slow registry
+	// doesn't hold up the others
+	f, ok := c.challengeFetches[registry]
+	if !ok {
+		f = &challengeFetch{done: make(chan struct{})}
+		if c.challengeFetches == nil {
+			c.challengeFetches = map[string]*challengeFetch{}
+		}
+		c.challengeFetches[registry] = f
+	}
+	c.mu.Unlock()
+	if ok {
+		select {
+		case <-f.done:
+			return f.challenge, f.err
+		case <-ctx.Done():
+			return AuthChallenge{}, ctx.Err()
+		}
+	}
+	f.challenge, f.err = c.fetchAuthChallenge(ctx, registry)
+	c.mu.Lock()
+	if f.err == nil {
+		c.challenges[registry] = f.challenge
+	}
+	delete(c.challengeFetches, registry)
+	c.mu.Unlock()
+	close(f.done)
+	return f.challenge, f.err
+}
+
+// challengeFetch is a challenge request which other callers can wait for.
+type challengeFetch struct {
+	done      chan struct{}
+	challenge AuthChallenge
+	err       error
+}
+
+// fetchAuthChallenge makes the unauthenticated request to the api root.
+func (c *Client) fetchAuthChallenge(ctx context.Context, registry string) (AuthChallenge, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL(registry)+"/v2/", nil)
+	if err != nil {
+		return AuthChallenge{}, err
+	}
+	res, err := c.do(req)
+	if err != nil {
+		return AuthChallenge{}, err
+	}
+	res.Body.Close()
+	var ch AuthChallenge
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		if ch, err = ParseAuthChallenge(res.Header.Get("WWW-Authenticate")); err != nil {
+			return AuthChallenge{}, fmt.Errorf("%s: %w", registry, err)
+		}
+		if ch.Scheme != "bearer" || ch.Realm == "" {
+			return AuthChallenge{}, fmt.Errorf("%s: unsupported auth scheme: %s", registry, ch.Scheme)
+		}
+	case http.StatusTooManyRequests:
+		return AuthChallenge{}, NewRateLimitError(res)
+	default:
+		return AuthChallenge{}, fmt.Errorf("%s doesn't look like a v2 registry: unexpected status code: %d", registry, res.StatusCode)
+	}
+	return ch, nil
+}
@@ -0,0 +1,160 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseAuthChallenge(t *testing.T) {
+	tests := []struct {
+		header    string
+		challenge AuthChallenge
+	}{
+		{
+			`Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`,
+			AuthChallenge{Scheme: "bearer", Realm: "https://auth.docker.io/token", Service: "registry.docker.io"},
+		},
+		{
+			`Bearer realm="https://ghcr.io/token",service="ghcr.io",scope="repository:owner/image:pull"`,
+			AuthChallenge{Scheme: "bearer", Realm: "https://ghcr.io/token", Service: "ghcr.io"},
+		},
+		{
+			`bearer service="a,b", realm="https://example.com/\"token\""`,
+			AuthChallenge{Scheme: "bearer", Realm: `https://example.com/"token"`, Service: "a,b"},
+		},
+		{
+			`Basic realm=Registry`,
+			AuthChallenge{Scheme: "basic", Realm: "Registry"},
+		},
+	}
+	for _, tt := range tests {
+		c, err := ParseAuthChallenge(tt.header)
+		if err != nil {
+			t.Errorf("ParseAuthChallenge(%q): %v", tt.header, err)
+			continue
+		}
+		if c != tt.challenge {
+			t.Errorf("ParseAuthChallenge(%q) = %+v, want %+v", tt.header, c, tt.challenge)
+		}
+	}
+	for _, header := range []string{"", `Bearer realm`, `Bearer realm="unterminated`} {
+		if _, err := ParseAuthChallenge(header); err == nil {
+			t.Errorf("expected %q to be rejected", header)
+		}
+	}
+}
+
+func TestClientToken(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/token":
+			if got := r.URL.Query().Get("scope"); got != "repository:owner/image:pull" {
+				t.Errorf("unexpected scope: %s", got)
+			}
+			fmt.Fprint(w, `{"token":"abc","expires_in":300}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	c := &Client{HTTPClient: srv.Client()}
+	registry := strings.TrimPrefix(srv.URL, "https://")
+	token, err := c.Token(context.Background(), Repository{Registry: registry, Library: "owner", Image: "image"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "abc" {
+		t.Fatalf("unexpected token: %q", token)
+	}
+}
+
+func TestClientTokenPerRegistry(t *testing.T) {
+	var srv *httptest.Server
+	var requests atomic.Int32
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/token":
+			requests.Add(1)
+			// give the other callers time to wait for this request
+			time.Sleep(50 * time.Millisecond)
+			username, _, _ := r.BasicAuth()
+			fmt.Fprintf(w, `{"token":"%s","expires_in":300}`, username)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	c := &Client{
+		BaseURL: srv.URL,
+		Credentials: func(registry string) (string, string, bool) {
+			return registry, "password", true
+		},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := c.Token(context.Background(), Repository{Registry: "a.example.com", Library: "owner", Image: "image"})
+			if err != nil || token != "a.example.com" {
+				t.Errorf("unexpected token: %q, %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected a single token request, got %d", n)
+	}
+	// a registry with the same service doesn't get the other one's token
+	token, err := c.Token(context.Background(), Repository{Registry: "b.example.com", Library: "owner", Image: "image"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "b.example.com" {
+		t.Fatalf("unexpected token: %q", token)
+	}
+}
+
+func TestAuthChallengeDoesntWait(t *testing.T) {
+	hung := make(chan struct{})
+	slow := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer slow.Close()
+	defer close(hung)
+	fast := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fast.Close()
+	c := &Client{HTTPClient: fast.Client()}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.AuthChallenge(ctx, strings.TrimPrefix(slow.URL, "https://"))
+	// give the slow request time to start
+	time.Sleep(50 * time.Millisecond)
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.AuthChallenge(context.Background(), strings.TrimPrefix(fast.URL, "https://"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the challenge of one registry waited for another")
+	}
+}
@@ -0,0 +1,75 @@
+// Package registry is a client for pulling images from registries which
+// implement the docker registry v2 api, like Docker Hub and ghcr.io.
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Client makes requests to registries and their auth servers. The zero
+// Client is ready to use, and it's safe for concurrent use.
+type Client struct {
+	// HTTPClient sends the requests, http.DefaultClient is used when it's nil.
+	HTTPClient *http.Client
+	// BaseURL replaces https://<registry> in api urls when it's set, for
+	// registries served over plain http or under a path prefix.
+	BaseURL string
+	// Credentials returns the username and password to log in to the
+	// registry with. The username is IdentityTokenUser for identity tokens.
+	// Pulls are anonymous when it's nil or reports no credentials.
+	Credentials func(registry string) (username, password string, ok bool)
+	// Cache keeps manifests so unchanged ones are revalidated with their
+	// ETag instead of downloaded again, it's optional.
+	Cache ManifestCache
+	// OnRateLimit is called with the pull rate limit reported by manifest
+	// responses, which only Docker Hub does.
+	OnRateLimit func(RateLimit)
+
+	mu         sync.Mutex
+	challenges map[string]AuthChallenge
+	tokens     map[string]registryToken
+	// challengeFetches and tokenFetches are the requests in flight
+	challengeFetches map[string]*challengeFetch
+	tokenFetches     map[string]*tokenFetch
+}
+
+// ErrCredentialsRejected is returned when the auth server doesn't accept
+// the credentials for a registry.
+var ErrCredentialsRejected = errors.New("credentials were rejected")
+
+// ManifestCache stores manifests along with their ETag. A manifest which
+// can't be cached just gets downloaded again, so Put doesn't return errors.
+type ManifestCache interface {
+	Get(repo Repository, reference string) (data []byte, etag string, ok bool)
+	Put(repo Repository, reference, etag string, data []byte)
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.HTTPClient == nil {
+		return http.DefaultClient.Do(req)
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// baseURL returns the url the registry's api is under.
+func (c *Client) baseURL(registry string) string {
+	if c.BaseURL != "" {
+		return strings.TrimSuffix(c.BaseURL, "/")
+	}
+	return "https://" + registry
+}
+
+// url returns the api url for a path within the repository.
+func (c *Client) url(repo Repository, p string) string {
+	return c.baseURL(repo.Registry) + "/v2/" + repo.Path() + "/" + p
+}
+
+// setToken authorizes the request with the bearer token, if there is one.
+func setToken(req *http.Request, token string) {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
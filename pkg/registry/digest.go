@@ -0,0 +1,206 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// VerifyDigest checks that data hashes to the digest.
+func VerifyDigest(data []byte, digest string) error {
+	if got := fmt.Sprintf("sha256:%x", sha256.Sum256(data)); got != digest {
+		return fmt.Errorf("digest mismatch: got %s, expected %s", got, digest)
+	}
+	return nil
+}
+
+// digestReader hashes everything read through it and reports a mismatch with
+// the digest in place of io.EOF, so a consumer which reads to the end can't
+// mistake a corrupted or tampered blob for a complete one.
+type digestReader struct {
+	io.ReadCloser
+	h      hash.Hash
+	digest string
+	err    error
+}
+
+// newDigestReader returns a reader which verifies r against the digest.
+func newDigestReader(r io.ReadCloser, digest string) io.ReadCloser {
+	return &digestReader{ReadCloser: r, h: sha256.New(), digest: digest}
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	n, err := d.ReadCloser.Read(p)
+	d.h.Write(p[:n])
+	if err == io.EOF {
+		if got := fmt.Sprintf("sha256:%x", d.h.Sum(nil)); got != d.digest {
+			err = fmt.Errorf("digest mismatch: got %s, expected %s", got, d.digest)
+		}
+	}
+	if err != nil {
+		// a mismatch has to keep failing, readers are allowed to retry at EOF
+		d.err = err
+	}
+	return n, err
+}
+
+// Blob reads a whole blob into memory, which is only meant for small
+// ones like the image config. Use OpenBlob for layers.
+func (c *Client) Blob(ctx context.Context, repo Repository, digest string) ([]byte, error) {
+	body, err := c.OpenBlob(ctx, repo, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// OpenBlob starts downloading a blob and returns its body so it can be
+// processed while the rest of it is still arriving. The body is verified
+// against the digest as it's read, and reading to the end of a blob which
+// doesn't match fails instead of returning io.EOF.
+func (c *Client) OpenBlob(ctx context.Context, repo Repository, digest string) (io.ReadCloser, error) {
+	body, err := c.OpenBlobAt(ctx, repo, digest, 0)
+	if err != nil {
+		return nil, err
+	}
+	return newDigestReader(body, digest), nil
+}
+
+// OpenBlobAt starts downloading a blob from offset bytes in, to resume a
+// download which was interrupted. The body isn't verified, that's up to the
+// caller since it has the start of the blob.
+func (c *Client) OpenBlobAt(ctx context.Context, repo Repository, digest string, offset int64) (io.ReadCloser, error) {
+	if !IsDigest(digest) {
+		return nil, fmt.Errorf("invalid digest: %q", digest)
+	}
+	token, err := c.Token(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(repo, "blobs/"+digest), nil)
+	if err != nil {
+		return nil, err
+	}
+	setToken(req, token)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	res, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, fmt.Errorf("blob %s: %w", digest, ErrBlobUnknown)
+	}
+	return rangeBody(res, offset)
+}
+
+// ErrBlobUnknown is returned when the registry doesn't have a blob.
+var ErrBlobUnknown = errors.New("blob unknown to registry")
+
+// OpenLayer is OpenBlob for a layer, which is downloaded from the urls in
+// its descriptor when the registry doesn't have it. That's the case for the
+// foreign layers of windows images, which can't be redistributed.
+func (c *Client) OpenLayer(ctx context.Context, repo Repository, layer Layer) (io.ReadCloser, error) {
+	body, err := c.OpenLayerAt(ctx, repo, layer, 0)
+	if err != nil {
+		return nil, err
+	}
+	return newDigestReader(body, layer.Digest), nil
+}
+
+// OpenLayerAt is OpenBlobAt for a layer, with the same fallback to its urls
+// as OpenLayer.
+func (c *Client) OpenLayerAt(ctx context.Context, repo Repository, layer Layer, offset int64) (io.ReadCloser, error) {
+	body, err := c.OpenBlobAt(ctx, repo, layer.Digest, offset)
+	if len(layer.URLs) == 0 || !errors.Is(err, ErrBlobUnknown) {
+		return body, err
+	}
+	errs := []error{err}
+	for _, u := range layer.URLs {
+		body, err := c.openURLAt(ctx, u, offset)
+		if err == nil {
+			return body, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// openURLAt downloads a layer from one of its urls, which are outside of
+// the registry so the registry's token isn't sent to them.
+func (c *Client) openURLAt(ctx context.Context, rawURL string, offset int64) (io.ReadCloser, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported layer url: %s", u.Redacted())
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	res, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := rangeBody(res, offset)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", u.Redacted(), err)
+	}
+	return body, nil
+}
+
+// rangeBody returns the body of a response to a request for everything
+// from offset on. Servers which don't support ranges send everything, and
+// the part before offset is skipped.
+func rangeBody(res *http.Response, offset int64) (io.ReadCloser, error) {
+	switch res.StatusCode {
+	case http.StatusOK:
+		if _, err := io.CopyN(io.Discard, res.Body, offset); err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+		return res.Body, nil
+	case http.StatusPartialContent:
+		if start, ok := contentRangeStart(res.Header.Get("Content-Range")); !ok || start != offset {
+			res.Body.Close()
+			return nil, fmt.Errorf("unexpected content range: %q", res.Header.Get("Content-Range"))
+		}
+		return res.Body, nil
+	default:
+		res.Body.Close()
+		return nil, statusError(res)
+	}
+}
+
+// contentRangeStart returns the first byte of a Content-Range header
+// like "bytes 100-199/200".
+func contentRangeStart(v string) (int64, bool) {
+	r, ok := strings.CutPrefix(v, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(r, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	return n, err == nil
+}
@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDigestReader(t *testing.T) {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("layer")))
+	data, err := io.ReadAll(newDigestReader(io.NopCloser(strings.NewReader("layer")), digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "layer" {
+		t.Fatalf("unexpected data: %q", data)
+	}
+	r := newDigestReader(io.NopCloser(strings.NewReader("tampered")), digest)
+	if _, err := io.ReadAll(r); err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Fatalf("expected a digest mismatch, got %v", err)
+	}
+	if _, err := r.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the mismatch to be reported again")
+	}
+}
+
+func TestOpenBlobAt(t *testing.T) {
+	blob := "0123456789"
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(blob)))
+	ranges := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+		case r.URL.Path == "/v2/owner/image/blobs/"+digest && ranges:
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(blob))
+		case r.URL.Path == "/v2/owner/image/blobs/"+digest:
+			io.WriteString(w, blob)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+	repo := Repository{Registry: "example.com", Library: "owner", Image: "image"}
+	for _, ranges = range []bool{true, false} {
+		body, err := c.OpenBlobAt(context.Background(), repo, digest, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "456789" {
+			t.Errorf("ranges=%v: got %q", ranges, data)
+		}
+	}
+}
+
+func TestOpenLayerURLs(t *testing.T) {
+	blob := "foreign layer"
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(blob)))
+	foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/layer" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(blob))
+	}))
+	defer foreign.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+	repo := Repository{Registry: "example.com", Library: "owner", Image: "image"}
+	layer := Layer{MediaType: MediaTypeDockerForeignLayer, Digest: digest}
+	if _, err := c.OpenLayer(context.Background(), repo, layer); !errors.Is(err, ErrBlobUnknown) {
+		t.Fatalf("expected the blob to be missing without urls, got %v", err)
+	}
+	layer.URLs = []string{"ftp://example.com/layer", foreign.URL + "/missing", foreign.URL + "/layer"}
+	body, err := c.OpenLayer(context.Background(), repo, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(data) != blob {
+		t.Fatalf("got %q, %v", data, err)
+	}
+	body, err = c.OpenLayerAt(context.Background(), repo, layer, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = io.ReadAll(body)
+	body.Close()
+	if string(data) != "layer" {
+		t.Fatalf("got %q from offset 8", data)
+	}
+}
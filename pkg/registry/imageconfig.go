@@ -0,0 +1,55 @@
+package registry
+
+import "strings"
+
+// ImageRuntimeConfig holds the defaults for containers created from an image.
+type ImageRuntimeConfig struct {
+	User       string   `json:"User,omitempty"`
+	Env        []string `json:"Env,omitempty"`
+	Entrypoint []string `json:"Entrypoint,omitempty"`
+	Cmd        []string `json:"Cmd,omitempty"`
+	WorkingDir string   `json:"WorkingDir,omitempty"`
+}
+
+// defaultPath is used when the image doesn't set PATH itself.
+const defaultPath = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// Command returns the command to run from the image's entrypoint and cmd.
+// Arguments given on the command line replace the cmd, and a non-nil
+// entrypoint replaces the image's, with an empty one removing it.
+func (c ImageRuntimeConfig) Command(entrypoint *string, args []string) []string {
+	command := c.Entrypoint
+	if entrypoint != nil {
+		command = nil
+		if *entrypoint != "" {
+			command = []string{*entrypoint}
+		}
+	}
+	if len(args) == 0 {
+		// docker drops the image's cmd along with its entrypoint
+		if entrypoint != nil {
+			return command
+		}
+		args = c.Cmd
+	}
+	return append(append([]string{}, command...), args...)
+}
+
+// Environ returns the image's environment, with a default PATH added.
+func (c ImageRuntimeConfig) Environ() []string {
+	env := append([]string{}, c.Env...)
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PATH=") {
+			return env
+		}
+	}
+	return append([]string{defaultPath}, env...)
+}
+
+// Dir returns the working directory, which defaults to the root.
+func (c ImageRuntimeConfig) Dir() string {
+	if c.WorkingDir == "" {
+		return "/"
+	}
+	return c.WorkingDir
+}
@@ -0,0 +1,44 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImageRuntimeConfigCommand(t *testing.T) {
+	empty, sh := "", "/bin/sh"
+	nginx := ImageRuntimeConfig{
+		Entrypoint: []string{"/docker-entrypoint.sh"},
+		Cmd:        []string{"nginx", "-g", "daemon off;"},
+	}
+	tests := []struct {
+		config     ImageRuntimeConfig
+		entrypoint *string
+		args       []string
+		want       []string
+	}{
+		{nginx, nil, nil, []string{"/docker-entrypoint.sh", "nginx", "-g", "daemon off;"}},
+		{nginx, nil, []string{"nginx", "-t"}, []string{"/docker-entrypoint.sh", "nginx", "-t"}},
+		{nginx, &empty, []string{"ls"}, []string{"ls"}},
+		{nginx, &sh, nil, []string{"/bin/sh"}},
+		{ImageRuntimeConfig{Cmd: []string{"sh"}}, nil, nil, []string{"sh"}},
+		{ImageRuntimeConfig{}, nil, nil, []string{}},
+	}
+	for _, tt := range tests {
+		got := tt.config.Command(tt.entrypoint, tt.args)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Command(%v, %q): got %q, want %q", tt.entrypoint, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestImageRuntimeConfigEnviron(t *testing.T) {
+	env := ImageRuntimeConfig{Env: []string{"LANG=C.UTF-8"}}.Environ()
+	if want := []string{defaultPath, "LANG=C.UTF-8"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("got %q, want %q", env, want)
+	}
+	env = ImageRuntimeConfig{Env: []string{"PATH=/opt/bin"}}.Environ()
+	if want := []string{"PATH=/opt/bin"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("got %q, want %q", env, want)
+	}
+}
@@ -0,0 +1,206 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	// Variant is the cpu variant, like v7 for arm/v7.
+	Variant string `json:"variant,omitempty"`
+	// OSVersion is the os build, which only windows images set.
+	OSVersion string `json:"os.version,omitempty"`
+}
+
+// String formats the platform like linux/arm/v7.
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	if p.OSVersion != "" {
+		s += " " + p.OSVersion
+	}
+	return s
+}
+
+type Layer struct {
+	MediaType string `json:"mediaType"`
+	Size      int    `json:"size"`
+	Digest    string `json:"digest"`
+	// URLs are where non-distributable layers can be downloaded from.
+	URLs []string `json:"urls,omitempty"`
+}
+
+type Manifest struct {
+	Annotations map[string]string `json:"annotations"`
+	Digest      string            `json:"digest"`
+	MediaType   string            `json:"mediaType"`
+	Platform    Platform          `json:"platform"`
+	Size        int               `json:"size"`
+}
+
+// ImageManifest is the manifest of a single platform image.
+type ImageManifest struct {
+	MediaType string `json:"mediaType,omitempty"`
+	// Config is the descriptor of the image config blob.
+	Config Layer   `json:"config"`
+	Layers []Layer `json:"layers"`
+}
+
+// ImageConfig is the image config blob referenced by the manifest.
+type ImageConfig struct {
+	// Created is when the image was built, it's zero when the config
+	// doesn't say.
+	Created time.Time `json:"created"`
+	RootFS  struct {
+		Type string `json:"type"`
+		// DiffIDs are the digests of the uncompressed layers.
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+	Config ImageRuntimeConfig `json:"config"`
+}
+
+// Manifest fetches the manifest for a tag or digest. Manifests fetched by
+// digest are checked against it.
+func (c *Client) Manifest(ctx context.Context, repo Repository, reference, accept string) ([]byte, error) {
+	data, err := c.downloadManifest(ctx, repo, reference, accept)
+	if err != nil {
+		return nil, err
+	}
+	if IsDigest(reference) {
+		digest, err := ManifestDigest(data)
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s: %w", reference, err)
+		}
+		if digest != reference {
+			return nil, fmt.Errorf("manifest %s: digest mismatch: got %s, expected %s", reference, digest, reference)
+		}
+	}
+	return data, nil
+}
+
+func (c *Client) downloadManifest(ctx context.Context, repo Repository, reference, accept string) ([]byte, error) {
+	token, err := c.Token(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(repo, "manifests/"+reference), nil)
+	if err != nil {
+		return nil, err
+	}
+	setToken(req, token)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	var cached []byte
+	var ok bool
+	if c.Cache != nil {
+		var etag string
+		if cached, etag, ok = c.Cache.Get(repo, reference); ok {
+			req.Header.Set("If-None-Match", etag)
+		}
+	}
+	res, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if rl, found := ParseRateLimit(res.Header); found && c.OnRateLimit != nil {
+		c.OnRateLimit(rl)
+	}
+	if ok && res.StatusCode == http.StatusNotModified {
+		return cached, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, statusError(res)
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if etag := res.Header.Get("ETag"); etag != "" && c.Cache != nil {
+		c.Cache.Put(repo, reference, etag, data)
+	}
+	return data, nil
+}
+
+// ResolveManifest finds the image's manifest for the platform. The tag or
+// digest can point either at an index or directly at a single platform
+// manifest, which is what most images outside of Docker Hub are, or even a
+// legacy schema 1 one.
+func (c *Client) ResolveManifest(ctx context.Context, ref Reference, platform Platform) (Manifest, error) {
+	reference := ref.Tag
+	if ref.Digest != "" {
+		reference = ref.Digest
+	}
+	data, err := c.Manifest(ctx, ref.Repository, reference, ManifestAccept)
+	if err != nil {
+		return Manifest{}, err
+	}
+	mediaType, err := ManifestMediaType(data)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if IsImageManifest(mediaType) || IsSchema1(mediaType) {
+		digest, err := ManifestDigest(data)
+		if err != nil {
+			return Manifest{}, err
+		}
+		return Manifest{
+			Digest:    digest,
+			MediaType: mediaType,
+			Size:      len(data),
+		}, nil
+	}
+	if !IsIndex(mediaType) {
+		return Manifest{}, fmt.Errorf("%s: unsupported manifest media type: %s", ref, mediaType)
+	}
+	var body struct {
+		Manifests []Manifest `json:"manifests"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return Manifest{}, err
+	}
+	manifest, ok := FindManifest(body.Manifests, platform)
+	if !ok {
+		var available []string
+		for _, m := range body.Manifests {
+			// attestation manifests use unknown/unknown
+			if m.Platform.OS != "unknown" {
+				available = append(available, m.Platform.String())
+			}
+		}
+		return Manifest{}, fmt.Errorf("no manifest for %s in %s, available platforms: %s", platform, ref, strings.Join(available, ", "))
+	}
+	return manifest, nil
+}
+
+// ImageManifest fetches the single platform manifest m describes.
+func (c *Client) ImageManifest(ctx context.Context, repo Repository, m Manifest) (ImageManifest, error) {
+	data, err := c.Manifest(ctx, repo, m.Digest, ManifestAccept)
+	if err != nil {
+		return ImageManifest{}, err
+	}
+	return ParseImageManifest(data)
+}
+
+// ImageConfig fetches the image config blob referenced by the manifest.
+func (c *Client) ImageConfig(ctx context.Context, repo Repository, m ImageManifest) (ImageConfig, error) {
+	data, err := c.Blob(ctx, repo, m.Config.Digest)
+	if err != nil {
+		return ImageConfig{}, err
+	}
+	var config ImageConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return ImageConfig{}, err
+	}
+	return config, nil
+}
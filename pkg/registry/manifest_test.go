@@ -0,0 +1,54 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type mapCache map[string][2]string
+
+func (m mapCache) Get(repo Repository, reference string) ([]byte, string, bool) {
+	e, ok := m[repo.Path()+":"+reference]
+	return []byte(e[1]), e[0], ok
+}
+
+func (m mapCache) Put(repo Repository, reference, etag string, data []byte) {
+	m[repo.Path()+":"+reference] = [2]string{etag, string(data)}
+}
+
+func TestClientManifestCache(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/owner/image/manifests/latest":
+			requests++
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte(`{"layers":[]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	cache := mapCache{}
+	c := &Client{HTTPClient: srv.Client(), BaseURL: srv.URL, Cache: cache}
+	repo := Repository{Registry: "example.com", Library: "owner", Image: "image"}
+	for i := 0; i < 2; i++ {
+		data, err := c.Manifest(context.Background(), repo, "latest", ManifestAccept)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != `{"layers":[]}` {
+			t.Fatalf("unexpected manifest: %s", data)
+		}
+	}
+	if requests != 2 || len(cache) != 1 {
+		t.Fatalf("expected the second request to be revalidated, got %d requests and %d cached", requests, len(cache))
+	}
+}
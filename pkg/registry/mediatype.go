@@ -0,0 +1,130 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// The media types of the manifests and blobs which make up an image, in
+// both the OCI and the older Docker flavours.
+const (
+	MediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIConfig    = "application/vnd.oci.image.config.v1+json"
+	MediaTypeOCILayer     = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeOCILayerGzip = MediaTypeOCILayer + "+gzip"
+	MediaTypeOCILayerZstd = MediaTypeOCILayer + "+zstd"
+
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerConfig       = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer        = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+
+	// Non-distributable layers, which registries usually don't have and
+	// which are downloaded from the urls in their descriptor instead.
+	MediaTypeOCINondistributableLayer     = "application/vnd.oci.image.layer.nondistributable.v1.tar"
+	MediaTypeOCINondistributableLayerGzip = MediaTypeOCINondistributableLayer + "+gzip"
+	MediaTypeOCINondistributableLayerZstd = MediaTypeOCINondistributableLayer + "+zstd"
+	MediaTypeDockerForeignLayer           = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+
+	// Legacy schema 1 manifests, which are signed unless they were pushed
+	// by very old clients.
+	MediaTypeDockerManifestV1       = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeDockerManifestV1Signed = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+)
+
+// ManifestAccept is the Accept header for manifests which may be either
+// an index or a single platform image. Schema 1 comes last, for registries
+// which still have images which were never pushed as anything newer.
+var ManifestAccept = strings.Join([]string{
+	MediaTypeOCIIndex,
+	MediaTypeDockerManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeDockerManifest,
+	MediaTypeDockerManifestV1Signed,
+	MediaTypeDockerManifestV1,
+}, ", ")
+
+// ManifestMediaType returns the media type of a manifest. It's optional
+// in OCI manifests, so when it's missing the type is worked out from
+// whether the manifest lists other manifests or layers.
+func ManifestMediaType(data []byte) (string, error) {
+	var body struct {
+		SchemaVersion int               `json:"schemaVersion"`
+		MediaType     string            `json:"mediaType"`
+		Manifests     []json.RawMessage `json:"manifests"`
+		Signatures    []json.RawMessage `json:"signatures"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return "", err
+	}
+	switch {
+	// only manifests with signatures have a payload to strip for the digest
+	case body.SchemaVersion == 1 && len(body.Signatures) > 0:
+		return MediaTypeDockerManifestV1Signed, nil
+	case body.SchemaVersion == 1:
+		return MediaTypeDockerManifestV1, nil
+	case body.MediaType != "":
+		return body.MediaType, nil
+	case body.Manifests != nil:
+		return MediaTypeOCIIndex, nil
+	default:
+		return MediaTypeOCIManifest, nil
+	}
+}
+
+// IsIndex reports whether the media type is a multi-platform index.
+func IsIndex(mediaType string) bool {
+	return mediaType == MediaTypeOCIIndex || mediaType == MediaTypeDockerManifestList
+}
+
+// IsImageManifest reports whether the media type is a single platform
+// image manifest.
+func IsImageManifest(mediaType string) bool {
+	return mediaType == MediaTypeOCIManifest || mediaType == MediaTypeDockerManifest
+}
+
+// IsSchema1 reports whether the media type is a legacy schema 1 manifest,
+// which has to be converted with ParseSchema1.
+func IsSchema1(mediaType string) bool {
+	return mediaType == MediaTypeDockerManifestV1 || mediaType == MediaTypeDockerManifestV1Signed
+}
+
+// IsLayer reports whether the media type is a layer which can be
+// extracted, including non-distributable ones.
+func IsLayer(mediaType string) bool {
+	switch mediaType {
+	case MediaTypeOCILayer, MediaTypeOCILayerGzip, MediaTypeOCILayerZstd, MediaTypeDockerLayer,
+		MediaTypeOCINondistributableLayer, MediaTypeOCINondistributableLayerGzip,
+		MediaTypeOCINondistributableLayerZstd, MediaTypeDockerForeignLayer:
+		return true
+	}
+	return false
+}
+
+// ParseImageManifest parses a single platform image manifest and checks
+// that it's a container image made of layers which can be extracted,
+// rather than some other artifact stored in the registry.
+func ParseImageManifest(data []byte) (ImageManifest, error) {
+	mediaType, err := ManifestMediaType(data)
+	if err != nil {
+		return ImageManifest{}, err
+	}
+	if !IsImageManifest(mediaType) {
+		return ImageManifest{}, fmt.Errorf("unsupported manifest media type: %s", mediaType)
+	}
+	var m ImageManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return ImageManifest{}, err
+	}
+	if m.Config.MediaType != MediaTypeOCIConfig && m.Config.MediaType != MediaTypeDockerConfig {
+		return ImageManifest{}, fmt.Errorf("not a container image, the config media type is %s", m.Config.MediaType)
+	}
+	for _, l := range m.Layers {
+		if !IsLayer(l.MediaType) {
+			return ImageManifest{}, fmt.Errorf("layer %s: unsupported media type: %s", l.Digest, l.MediaType)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,64 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestManifestMediaType(t *testing.T) {
+	tests := []struct {
+		manifest  string
+		mediaType string
+	}{
+		{`{"mediaType":"application/vnd.docker.distribution.manifest.list.v2+json","manifests":[]}`, MediaTypeDockerManifestList},
+		{`{"schemaVersion":2,"manifests":[{"digest":"sha256:abc"}]}`, MediaTypeOCIIndex},
+		{`{"schemaVersion":2,"config":{},"layers":[]}`, MediaTypeOCIManifest},
+		{`{"schemaVersion":1,"fsLayers":[]}`, MediaTypeDockerManifestV1},
+		{`{"schemaVersion":1,"fsLayers":[],"signatures":[]}`, MediaTypeDockerManifestV1},
+		{`{"schemaVersion":1,"fsLayers":[],"signatures":[{"protected":"e30"}]}`, MediaTypeDockerManifestV1Signed},
+	}
+	for _, tt := range tests {
+		mediaType, err := ManifestMediaType([]byte(tt.manifest))
+		if err != nil || mediaType != tt.mediaType {
+			t.Errorf("ManifestMediaType(%s) = %q, %v, want %q", tt.manifest, mediaType, err, tt.mediaType)
+		}
+	}
+}
+
+func TestManifestAccept(t *testing.T) {
+	for _, mediaType := range []string{MediaTypeDockerManifestV1Signed, MediaTypeDockerManifestV1} {
+		if !strings.Contains(ManifestAccept, mediaType) {
+			t.Errorf("ManifestAccept doesn't include %s", mediaType)
+		}
+	}
+}
+
+func TestParseImageManifest(t *testing.T) {
+	m, err := ParseImageManifest([]byte(`{
+		"schemaVersion": 2,
+		"config": {"mediaType": "application/vnd.oci.image.config.v1+json", "digest": "sha256:c"},
+		"layers": [
+			{"mediaType": "application/vnd.oci.image.layer.v1.tar+zstd", "digest": "sha256:a"},
+			{"mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip", "digest": "sha256:b"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Layers) != 2 || m.Layers[0].MediaType != MediaTypeOCILayerZstd {
+		t.Fatalf("unexpected layers: %+v", m.Layers)
+	}
+	tests := []struct {
+		manifest string
+		err      string
+	}{
+		{`{"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`, "unsupported manifest media type"},
+		{`{"config":{"mediaType":"application/vnd.cncf.helm.config.v1+json"},"layers":[]}`, "not a container image"},
+		{`{"config":{"mediaType":"application/vnd.oci.image.config.v1+json"},"layers":[{"mediaType":"application/vnd.cncf.helm.chart.content.v1.tar+gzip"}]}`, "unsupported media type"},
+	}
+	for _, tt := range tests {
+		if _, err := ParseImageManifest([]byte(tt.manifest)); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ParseImageManifest(%s) = %v, want %q", tt.manifest, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package registry
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// HostPlatform returns the platform of the running binary.
+func HostPlatform() Platform {
+	p := Platform{Architecture: runtime.GOARCH, OS: runtime.GOOS}
+	switch p.Architecture {
+	case "arm":
+		p.Variant = "v7"
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, s := range info.Settings {
+				if s.Key == "GOARM" {
+					version, _, _ := strings.Cut(s.Value, ",")
+					p.Variant = "v" + version
+				}
+			}
+		}
+	case "arm64":
+		p.Variant = "v8"
+	}
+	return p
+}
+
+// ParsePlatform parses a platform like linux/arm64 or linux/arm/v7. The
+// variant defaults to the architecture's usual one, the way registries
+// leave it out, and common aliases like x86_64 and aarch64 are accepted.
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(strings.ToLower(s), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q: expected os/arch[/variant]", s)
+	}
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	switch p.Architecture {
+	case "x86_64", "x86-64":
+		p.Architecture = "amd64"
+	case "aarch64":
+		p.Architecture = "arm64"
+	case "armhf":
+		p.Architecture, p.Variant = "arm", "v7"
+	case "armel":
+		p.Architecture, p.Variant = "arm", "v6"
+	}
+	if p.Architecture == "arm" && p.Variant != "" && armVersion(p.Variant) == 0 {
+		return Platform{}, fmt.Errorf("invalid platform %q: unknown arm variant %s", s, p.Variant)
+	}
+	p.Variant = normalizeVariant(p)
+	return p, nil
+}
+
+// FindManifest returns the manifest which best matches the platform. Exact
+// variant matches are preferred, but arm images built for an older variant
+// are used when there isn't one.
+func FindManifest(manifests []Manifest, platform Platform) (Manifest, bool) {
+	var best Manifest
+	var bestScore int
+	for _, m := range manifests {
+		if score := platformScore(platform, m.Platform); score > bestScore {
+			best, bestScore = m, score
+		}
+	}
+	return best, bestScore > 0
+}
+
+// normalizeVariant fills in the variant registries leave out
+// for the architecture's default.
+func normalizeVariant(p Platform) string {
+	if p.Variant == "" {
+		switch p.Architecture {
+		case "arm":
+			return "v7"
+		case "arm64":
+			return "v8"
+		}
+	}
+	return p.Variant
+}
+
+// armVersion returns the version number of an arm variant like "v6".
+func armVersion(variant string) int {
+	if len(variant) == 2 && variant[0] == 'v' && variant[1] >= '5' && variant[1] <= '8' {
+		return int(variant[1] - '0')
+	}
+	return 0
+}
+
+// platformScore ranks how well the candidate matches the wanted platform.
+// It returns zero when the candidate can't run on it at all.
+func platformScore(want, have Platform) int {
+	if want.OS != have.OS || want.Architecture != have.Architecture {
+		return 0
+	}
+	score := 1
+	if wantVariant, haveVariant := normalizeVariant(want), normalizeVariant(have); wantVariant != haveVariant {
+		// newer arm cpus can run code built for older ones
+		w, h := armVersion(wantVariant), armVersion(haveVariant)
+		if want.Architecture != "arm" || w == 0 || h == 0 || h > w {
+			return 0
+		}
+		score += h
+	} else {
+		score += 10
+	}
+	// windows images have to match the host's build, and
+	// an exact match also gets the right patch level.
+	if want.OSVersion != "" && have.OSVersion != "" {
+		switch {
+		case want.OSVersion == have.OSVersion:
+			score += 100
+		case windowsBuild(want.OSVersion) == windowsBuild(have.OSVersion):
+			score += 50
+		default:
+			return 0
+		}
+	}
+	return score
+}
+
+// windowsBuild returns the major.minor.build prefix of a windows os.version.
+func windowsBuild(version string) string {
+	parts := strings.SplitN(version, ".", 4)
+	return strings.Join(parts[:min(3, len(parts))], ".")
+}
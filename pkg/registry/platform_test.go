@@ -0,0 +1,71 @@
+package registry
+
+import "testing"
+
+func TestFindManifest(t *testing.T) {
+	manifests := []Manifest{
+		{Digest: "amd64", Platform: Platform{OS: "linux", Architecture: "amd64"}},
+		{Digest: "armv6", Platform: Platform{OS: "linux", Architecture: "arm", Variant: "v6"}},
+		{Digest: "armv7", Platform: Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		{Digest: "arm64", Platform: Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+		{Digest: "ltsc2019", Platform: Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.5458"}},
+		{Digest: "ltsc2022", Platform: Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.2227"}},
+		{Digest: "attestation", Platform: Platform{OS: "unknown", Architecture: "unknown"}},
+	}
+	tests := []struct {
+		platform Platform
+		digest   string
+	}{
+		{Platform{OS: "linux", Architecture: "amd64"}, "amd64"},
+		{Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, "armv7"},
+		{Platform{OS: "linux", Architecture: "arm", Variant: "v6"}, "armv6"},
+		{Platform{OS: "linux", Architecture: "arm", Variant: "v8"}, "armv7"},
+		{Platform{OS: "linux", Architecture: "arm", Variant: "v5"}, ""},
+		{Platform{OS: "linux", Architecture: "arm64"}, "arm64"},
+		{Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.1000"}, "ltsc2022"},
+		{Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.5458"}, "ltsc2019"},
+		{Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.14393.1"}, ""},
+		{Platform{OS: "linux", Architecture: "s390x"}, ""},
+	}
+	for _, tt := range tests {
+		m, ok := FindManifest(manifests, tt.platform)
+		if ok != (tt.digest != "") || m.Digest != tt.digest {
+			t.Errorf("FindManifest(%s) = %q, %v, want %q", tt.platform, m.Digest, ok, tt.digest)
+		}
+	}
+}
+
+func TestFindManifestDefaultVariant(t *testing.T) {
+	// registries often leave the variant out for the default
+	manifests := []Manifest{
+		{Digest: "arm64", Platform: Platform{OS: "linux", Architecture: "arm64"}},
+	}
+	if m, ok := FindManifest(manifests, Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}); !ok || m.Digest != "arm64" {
+		t.Errorf("expected arm64 without a variant to match v8, got %q", m.Digest)
+	}
+}
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		s        string
+		platform Platform
+	}{
+		{"linux/amd64", Platform{OS: "linux", Architecture: "amd64"}},
+		{"linux/arm64", Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+		{"linux/arm/v6", Platform{OS: "linux", Architecture: "arm", Variant: "v6"}},
+		{"linux/arm", Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		{"Linux/x86_64", Platform{OS: "linux", Architecture: "amd64"}},
+		{"linux/aarch64", Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+	}
+	for _, tt := range tests {
+		p, err := ParsePlatform(tt.s)
+		if err != nil || p != tt.platform {
+			t.Errorf("ParsePlatform(%q) = %+v, %v, want %+v", tt.s, p, err, tt.platform)
+		}
+	}
+	for _, s := range []string{"", "linux", "arm64", "linux/arm/v9", "linux/arm64/v8/x", "/amd64"} {
+		if _, err := ParsePlatform(s); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimit is Docker Hub's pull rate limit as reported in response headers.
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Window    time.Duration
+	Source    string
+}
+
+// ParseRateLimit parses the ratelimit-limit and ratelimit-remaining headers.
+// The values look like "100;w=21600" where w is the window in seconds.
+func ParseRateLimit(h http.Header) (RateLimit, bool) {
+	limit, window, ok := parseRateLimitHeader(h.Get("ratelimit-limit"))
+	if !ok {
+		return RateLimit{}, false
+	}
+	remaining, _, ok := parseRateLimitHeader(h.Get("ratelimit-remaining"))
+	if !ok {
+		return RateLimit{}, false
+	}
+	return RateLimit{
+		Limit:     limit,
+		Remaining: remaining,
+		Window:    window,
+		Source:    h.Get("docker-ratelimit-source"),
+	}, true
+}
+
+func parseRateLimitHeader(v string) (int, time.Duration, bool) {
+	if v == "" {
+		return 0, 0, false
+	}
+	count, params, _ := strings.Cut(v, ";")
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil {
+		return 0, 0, false
+	}
+	var window time.Duration
+	for _, p := range strings.Split(params, ";") {
+		if w, ok := strings.CutPrefix(strings.TrimSpace(p), "w="); ok {
+			if secs, err := strconv.Atoi(w); err == nil {
+				window = time.Duration(secs) * time.Second
+			}
+		}
+	}
+	return n, window, true
+}
+
+func (rl RateLimit) String() string {
+	if rl.Window == 0 {
+		return fmt.Sprintf("%d of %d pulls remaining", rl.Remaining, rl.Limit)
+	}
+	return fmt.Sprintf("%d of %d pulls remaining per %s", rl.Remaining, rl.Limit, rl.Window)
+}
+
+// Low reports whether less than 10% of the limit remains.
+func (rl RateLimit) Low() bool {
+	return rl.Remaining*10 < rl.Limit
+}
+
+// RateLimit checks the current Docker Hub pull rate limit. It uses a HEAD
+// request against Docker's preview repository which doesn't count as a pull.
+func (c *Client) RateLimit(ctx context.Context) (RateLimit, error) {
+	token, err := c.Token(ctx, Repository{Registry: DockerHub, Library: "ratelimitpreview", Image: "test"})
+	if err != nil {
+		return RateLimit{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, "https://registry-1.docker.io/v2/ratelimitpreview/test/manifests/latest", nil)
+	if err != nil {
+		return RateLimit{}, err
+	}
+	setToken(req, token)
+	res, err := c.do(req)
+	if err != nil {
+		return RateLimit{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return RateLimit{}, statusError(res)
+	}
+	rl, ok := ParseRateLimit(res.Header)
+	if !ok {
+		return RateLimit{}, fmt.Errorf("registry did not report a rate limit")
+	}
+	return rl, nil
+}
+
+// RateLimitError is returned for 429 Too Many Requests responses.
+type RateLimitError struct {
+	Host string
+	// RetryAfter is how long the registry asked to wait, it's zero when
+	// the response didn't have a Retry-After header.
+	RetryAfter time.Duration
+	// RateLimit is set when the response reported the pull rate limit.
+	RateLimit *RateLimit
+}
+
+// NewRateLimitError makes the error for a 429 response.
+func NewRateLimitError(res *http.Response) *RateLimitError {
+	e := &RateLimitError{}
+	if res.Request != nil {
+		e.Host = res.Request.URL.Host
+	}
+	if d, ok := ParseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+		e.RetryAfter = d
+	}
+	if rl, ok := ParseRateLimit(res.Header); ok {
+		e.RateLimit = &rl
+	}
+	return e
+}
+
+func (e *RateLimitError) Error() string {
+	msg := "rate limited"
+	if e.Host != "" {
+		msg += " by " + e.Host
+	}
+	if e.RateLimit != nil {
+		msg += " (" + e.RateLimit.String() + ")"
+	}
+	switch {
+	case e.RetryAfter > 0:
+		return msg + ", retry after " + e.RetryAfter.Round(time.Second).String()
+	case e.RateLimit != nil && e.RateLimit.Window > 0:
+		// docker hub's limit is over a rolling window, without saying when
+		// the next pull frees up
+		return msg + ", retry within " + e.RateLimit.Window.String()
+	default:
+		return msg + ", retry later"
+	}
+}
+
+// ParseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an http date.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// statusError is the error for a response with an unexpected status code.
+func statusError(res *http.Response) error {
+	if res.StatusCode == http.StatusTooManyRequests {
+		return NewRateLimitError(res)
+	}
+	return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+}
@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	h := http.Header{}
+	h.Set("ratelimit-limit", "100;w=21600")
+	h.Set("ratelimit-remaining", "7;w=21600")
+	h.Set("docker-ratelimit-source", "192.0.2.1")
+	rl, ok := ParseRateLimit(h)
+	if !ok {
+		t.Fatal("expected rate limit")
+	}
+	want := RateLimit{Limit: 100, Remaining: 7, Window: 6 * time.Hour, Source: "192.0.2.1"}
+	if rl != want {
+		t.Fatalf("got %+v, want %+v", rl, want)
+	}
+	if !rl.Low() {
+		t.Error("expected 7/100 to be low")
+	}
+	if _, ok := ParseRateLimit(http.Header{}); ok {
+		t.Error("expected no rate limit without headers")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{value: "", ok: false},
+		{value: "30", want: 30 * time.Second, ok: true},
+		{value: "Mon, 01 Jan 2024 12:00:10 GMT", want: 10 * time.Second, ok: true},
+		{value: "Mon, 01 Jan 2024 11:00:00 GMT", want: 0, ok: true},
+		{value: "soon", ok: false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseRetryAfter(%q) = %s, %v, want %s, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRateLimitError(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://registry-1.docker.io/v2/library/alpine/manifests/latest", nil)
+	tests := []struct {
+		header http.Header
+		want   string
+	}{
+		{
+			header: http.Header{"Retry-After": {"90"}},
+			want:   "rate limited by registry-1.docker.io, retry after 1m30s",
+		},
+		{
+			header: http.Header{"Ratelimit-Limit": {"100;w=21600"}, "Ratelimit-Remaining": {"0;w=21600"}},
+			want:   "rate limited by registry-1.docker.io (0 of 100 pulls remaining per 6h0m0s), retry within 6h0m0s",
+		},
+		{
+			header: http.Header{},
+			want:   "rate limited by registry-1.docker.io, retry later",
+		},
+	}
+	for _, tt := range tests {
+		res := &http.Response{StatusCode: http.StatusTooManyRequests, Header: tt.header, Request: req}
+		err := statusError(res)
+		var rle *RateLimitError
+		if !errors.As(err, &rle) {
+			t.Fatalf("expected a RateLimitError, got %v", err)
+		}
+		if got := err.Error(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
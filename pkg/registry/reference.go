@@ -0,0 +1,145 @@
+package registry
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DockerHub is the registry host images without one are pulled from.
+const DockerHub = "registry.hub.docker.com"
+
+// IsDockerHub reports whether the server name refers to Docker Hub.
+func IsDockerHub(server string) bool {
+	switch strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://"), "/") {
+	case "docker.io", "index.docker.io", "index.docker.io/v1", "registry-1.docker.io", "registry.hub.docker.com":
+		return true
+	}
+	return false
+}
+
+// Repository is an image repository on a registry.
+type Repository struct {
+	// Registry is the registry host, optionally with a port.
+	Registry string
+	// Library is everything in the repository name before the image,
+	// it's empty for single component names outside of Docker Hub.
+	Library string
+	Image   string
+}
+
+// Path returns the repository name used in registry api paths.
+func (r Repository) Path() string {
+	if r.Library == "" {
+		return r.Image
+	}
+	return r.Library + "/" + r.Image
+}
+
+// URL returns the registry api url for a path within the repository.
+func (r Repository) URL(p string) string {
+	return "https://" + r.Registry + "/v2/" + r.Path() + "/" + p
+}
+
+// String formats the repository the way docker displays it, leaving out
+// Docker Hub and the library namespace of official images.
+func (r Repository) String() string {
+	if r.Registry != DockerHub {
+		return r.Registry + "/" + r.Path()
+	}
+	if r.Library == "library" {
+		return r.Image
+	}
+	return r.Path()
+}
+
+// Reference identifies an image: its repository and tag or digest.
+type Reference struct {
+	Repository
+	Tag string
+	// Digest pins the reference to a manifest, the tag is ignored when it's set.
+	Digest string
+}
+
+var (
+	// nameComponent matches the parts of a repository name
+	nameComponent = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]+)[a-z0-9]+)*$`)
+	tagPattern    = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+	hostPattern   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?(:[0-9]+)?$`)
+)
+
+// ParseReference parses an image reference like alpine, alpine:3.19,
+// bitnami/redis:7, ghcr.io/owner/image:tag or alpine@sha256:<hex>. The
+// tag defaults to latest unless the reference has a digest.
+func ParseReference(s string) (Reference, error) {
+	name, digest, hasDigest := strings.Cut(s, "@")
+	if hasDigest && !digestPattern.MatchString(digest) {
+		return Reference{}, fmt.Errorf("invalid image reference %q: bad digest %q", s, digest)
+	}
+	var tag string
+	// a colon after the last slash separates the tag
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+		if !tagPattern.MatchString(tag) {
+			return Reference{}, fmt.Errorf("invalid image reference %q: bad tag %q", s, tag)
+		}
+	} else if !hasDigest {
+		tag = "latest"
+	}
+	repo, err := parseRepository(name)
+	if err != nil {
+		return Reference{}, fmt.Errorf("invalid image reference %q: %w", s, err)
+	}
+	return Reference{Repository: repo, Tag: tag, Digest: digest}, nil
+}
+
+// parseRepository parses a repository name. Like docker, the first component
+// is the registry host when it looks like one, meaning it has a dot or a port
+// or is localhost.
+func parseRepository(name string) (Repository, error) {
+	registry := DockerHub
+	if host, rest, ok := strings.Cut(name, "/"); ok && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		if !hostPattern.MatchString(host) {
+			return Repository{}, fmt.Errorf("bad registry host %q", host)
+		}
+		if !IsDockerHub(host) {
+			registry = host
+		}
+		name = rest
+	}
+	var repo Repository
+	if registry == DockerHub {
+		library, image := SplitRepository(name)
+		repo = Repository{Registry: registry, Library: library, Image: image}
+	} else {
+		repo = Repository{Registry: registry, Image: name}
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			repo.Library, repo.Image = name[:i], name[i+1:]
+		}
+	}
+	for _, part := range strings.Split(repo.Path(), "/") {
+		if !nameComponent.MatchString(part) {
+			return Repository{}, fmt.Errorf("repository names must be lowercase alphanumeric, optionally separated by . _ or -")
+		}
+	}
+	// Docker Hub repositories are always namespace/name
+	if registry == DockerHub && strings.Contains(repo.Image, "/") {
+		return Repository{}, fmt.Errorf("too many components in Docker Hub repository %q", name)
+	}
+	return repo, nil
+}
+
+// String formats the reference the way docker displays it.
+func (r Reference) String() string {
+	name := r.Repository.String()
+	if r.Digest != "" {
+		return name + "@" + r.Digest
+	}
+	return name + ":" + r.Tag
+}
+
+// IsDigest reports whether a manifest reference is a digest rather than a tag.
+func IsDigest(reference string) bool {
+	return digestPattern.MatchString(reference)
+}
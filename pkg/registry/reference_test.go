@@ -0,0 +1,76 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	hub := func(library, image string) Repository {
+		return Repository{Registry: DockerHub, Library: library, Image: image}
+	}
+	tests := []struct {
+		input string
+		ref   Reference
+		str   string
+	}{
+		{"alpine", Reference{Repository: hub("library", "alpine"), Tag: "latest"}, "alpine:latest"},
+		{"alpine:3.19", Reference{Repository: hub("library", "alpine"), Tag: "3.19"}, "alpine:3.19"},
+		{"nginx:1.25-alpine", Reference{Repository: hub("library", "nginx"), Tag: "1.25-alpine"}, "nginx:1.25-alpine"},
+		{"library/busybox:musl", Reference{Repository: hub("library", "busybox"), Tag: "musl"}, "busybox:musl"},
+		{"bitnami/redis:7.2", Reference{Repository: hub("bitnami", "redis"), Tag: "7.2"}, "bitnami/redis:7.2"},
+		{"docker.io/library/alpine", Reference{Repository: hub("library", "alpine"), Tag: "latest"}, "alpine:latest"},
+		{"alpine@" + digest, Reference{Repository: hub("library", "alpine"), Digest: digest}, "alpine@" + digest},
+		{"alpine:3.19@" + digest, Reference{Repository: hub("library", "alpine"), Tag: "3.19", Digest: digest}, "alpine@" + digest},
+		{
+			"ghcr.io/owner/image:tag",
+			Reference{Repository: Repository{Registry: "ghcr.io", Library: "owner", Image: "image"}, Tag: "tag"},
+			"ghcr.io/owner/image:tag",
+		},
+		{
+			"quay.io/foo/bar",
+			Reference{Repository: Repository{Registry: "quay.io", Library: "foo", Image: "bar"}, Tag: "latest"},
+			"quay.io/foo/bar:latest",
+		},
+		{
+			"registry.gitlab.com/group/project/app:v1",
+			Reference{Repository: Repository{Registry: "registry.gitlab.com", Library: "group/project", Image: "app"}, Tag: "v1"},
+			"registry.gitlab.com/group/project/app:v1",
+		},
+		{
+			"localhost:5000/app",
+			Reference{Repository: Repository{Registry: "localhost:5000", Image: "app"}, Tag: "latest"},
+			"localhost:5000/app:latest",
+		},
+	}
+	for _, tt := range tests {
+		ref, err := ParseReference(tt.input)
+		if err != nil {
+			t.Errorf("ParseReference(%q): %v", tt.input, err)
+			continue
+		}
+		if ref != tt.ref {
+			t.Errorf("ParseReference(%q) = %+v, want %+v", tt.input, ref, tt.ref)
+		}
+		if s := ref.String(); s != tt.str {
+			t.Errorf("%+v.String() = %q, want %q", ref, s, tt.str)
+		}
+	}
+	for _, input := range []string{
+		"", "Alpine", "alpine:", "alpine:-bad", "alpine:a/b", "a/b/c",
+		"alpine@sha256:abc", "alpine@md5:" + strings.Repeat("ab", 16), "alpine:@" + digest,
+		"ghcr.io/", "ghcr.io/Owner/image", "bad_host.io/image",
+	} {
+		if _, err := ParseReference(input); err == nil {
+			t.Errorf("expected %q to be rejected", input)
+		}
+	}
+}
+
+func TestRepositoryURL(t *testing.T) {
+	repo := Repository{Registry: "ghcr.io", Library: "owner", Image: "image"}
+	if u := repo.URL("manifests/latest"); u != "https://ghcr.io/v2/owner/image/manifests/latest" {
+		t.Errorf("unexpected url: %s", u)
+	}
+}
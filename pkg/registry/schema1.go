@@ -0,0 +1,174 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// schema1Manifest is a legacy manifest. It lists the layers newest first,
+// each with the v1 image json of the image up to that layer.
+type schema1Manifest struct {
+	FSLayers []struct {
+		BlobSum string `json:"blobSum"`
+	} `json:"fsLayers"`
+	History []struct {
+		V1Compatibility string `json:"v1Compatibility"`
+	} `json:"history"`
+	Signatures []struct {
+		Protected string `json:"protected"`
+	} `json:"signatures"`
+}
+
+// v1Image is the part of the v1 image json which is kept by the conversion.
+type v1Image struct {
+	Created      time.Time          `json:"created"`
+	Architecture string             `json:"architecture"`
+	OS           string             `json:"os"`
+	Config       ImageRuntimeConfig `json:"config"`
+	// Throwaway is set on the entries of build steps which didn't add a layer.
+	Throwaway bool `json:"throwaway"`
+}
+
+// Schema1Image is an image described by a schema 1 manifest.
+type Schema1Image struct {
+	Platform Platform
+	Created  time.Time
+	Config   ImageRuntimeConfig
+	// Layers are the image's layers, lowest first. Their sizes aren't known.
+	Layers []Layer
+}
+
+// ParseSchema1 parses a schema 1 manifest.
+func ParseSchema1(data []byte) (Schema1Image, error) {
+	var m schema1Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Schema1Image{}, err
+	}
+	if len(m.FSLayers) == 0 || len(m.FSLayers) != len(m.History) {
+		return Schema1Image{}, fmt.Errorf("schema 1 manifest has %d layers and %d history entries", len(m.FSLayers), len(m.History))
+	}
+	var img Schema1Image
+	for i := len(m.FSLayers) - 1; i >= 0; i-- {
+		var v1 v1Image
+		if err := json.Unmarshal([]byte(m.History[i].V1Compatibility), &v1); err != nil {
+			return Schema1Image{}, fmt.Errorf("invalid v1 image json: %w", err)
+		}
+		if i == 0 {
+			img.Platform = Platform{OS: v1.OS, Architecture: v1.Architecture}
+			img.Created = v1.Created
+			img.Config = v1.Config
+		}
+		if v1.Throwaway {
+			continue
+		}
+		digest := m.FSLayers[i].BlobSum
+		if !IsDigest(digest) {
+			return Schema1Image{}, fmt.Errorf("invalid layer digest: %q", digest)
+		}
+		img.Layers = append(img.Layers, Layer{MediaType: MediaTypeDockerLayer, Digest: digest})
+	}
+	return img, nil
+}
+
+// Convert returns a schema 2 manifest and image config for the image, given
+// the diff ids and sizes of its layers which schema 1 doesn't record.
+func (img Schema1Image) Convert(diffIDs []string, sizes []int) (manifest, config []byte, err error) {
+	if len(diffIDs) != len(img.Layers) || len(sizes) != len(img.Layers) {
+		return nil, nil, fmt.Errorf("got %d diff ids and %d sizes for %d layers", len(diffIDs), len(sizes), len(img.Layers))
+	}
+	c := struct {
+		Architecture string             `json:"architecture"`
+		OS           string             `json:"os"`
+		Created      time.Time          `json:"created"`
+		Config       ImageRuntimeConfig `json:"config"`
+		RootFS       struct {
+			Type    string   `json:"type"`
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+	}{
+		Architecture: img.Platform.Architecture,
+		OS:           img.Platform.OS,
+		Created:      img.Created,
+		Config:       img.Config,
+	}
+	c.RootFS.Type = "layers"
+	c.RootFS.DiffIDs = diffIDs
+	if config, err = json.Marshal(c); err != nil {
+		return nil, nil, err
+	}
+	m := struct {
+		SchemaVersion int `json:"schemaVersion"`
+		ImageManifest
+	}{
+		SchemaVersion: 2,
+		ImageManifest: ImageManifest{
+			MediaType: MediaTypeDockerManifest,
+			Config: Layer{
+				MediaType: MediaTypeDockerConfig,
+				Size:      len(config),
+				Digest:    fmt.Sprintf("sha256:%x", sha256.Sum256(config)),
+			},
+		},
+	}
+	for i, l := range img.Layers {
+		l.Size = sizes[i]
+		m.Layers = append(m.Layers, l)
+	}
+	if manifest, err = json.Marshal(m); err != nil {
+		return nil, nil, err
+	}
+	return manifest, config, nil
+}
+
+// ManifestDigest returns the digest a registry knows the manifest by. That's
+// the digest of the data, except for signed schema 1 manifests where it's
+// the digest of the payload without the signatures.
+func ManifestDigest(data []byte) (string, error) {
+	mediaType, err := ManifestMediaType(data)
+	if err != nil {
+		return "", err
+	}
+	if mediaType != MediaTypeDockerManifestV1Signed {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256(data)), nil
+	}
+	payload, err := schema1Payload(data)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(payload)), nil
+}
+
+// schema1Payload strips the signatures from a signed schema 1 manifest. The
+// protected header of the signatures says how much of the manifest comes
+// before them, and what it ended with when it was signed.
+func schema1Payload(data []byte) ([]byte, error) {
+	var m schema1Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if len(m.Signatures) == 0 {
+		return nil, fmt.Errorf("schema 1 manifest isn't signed")
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(m.Signatures[0].Protected)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema 1 signature: %w", err)
+	}
+	var header struct {
+		FormatLength int    `json:"formatLength"`
+		FormatTail   string `json:"formatTail"`
+	}
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return nil, fmt.Errorf("invalid schema 1 signature: %w", err)
+	}
+	tail, err := base64.RawURLEncoding.DecodeString(header.FormatTail)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema 1 signature: %w", err)
+	}
+	if header.FormatLength < 0 || header.FormatLength > len(data) {
+		return nil, fmt.Errorf("invalid schema 1 signature: format length %d is out of range", header.FormatLength)
+	}
+	return append(data[:header.FormatLength:header.FormatLength], tail...), nil
+}
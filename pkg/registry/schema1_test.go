@@ -0,0 +1,88 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSchema1Manifest = `{
+   "schemaVersion": 1,
+   "name": "library/test",
+   "tag": "latest",
+   "architecture": "amd64",
+   "fsLayers": [
+      {"blobSum": "sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4"},
+      {"blobSum": "sha256:2222222222222222222222222222222222222222222222222222222222222222"},
+      {"blobSum": "sha256:1111111111111111111111111111111111111111111111111111111111111111"}
+   ],
+   "history": [
+      {"v1Compatibility": "{\"architecture\":\"amd64\",\"os\":\"linux\",\"created\":\"2016-01-02T03:04:05Z\",\"config\":{\"Env\":[\"A=b\"],\"Cmd\":[\"sh\"]},\"throwaway\":true}"},
+      {"v1Compatibility": "{\"created\":\"2016-01-01T00:00:00Z\"}"},
+      {"v1Compatibility": "{\"created\":\"2015-12-31T00:00:00Z\"}"}
+   ]
+}`
+
+func TestParseSchema1(t *testing.T) {
+	img, err := ParseSchema1([]byte(testSchema1Manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Schema1Image{
+		Platform: Platform{OS: "linux", Architecture: "amd64"},
+		Created:  time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC),
+		Config:   ImageRuntimeConfig{Env: []string{"A=b"}, Cmd: []string{"sh"}},
+		Layers: []Layer{
+			{MediaType: MediaTypeDockerLayer, Digest: "sha256:1111111111111111111111111111111111111111111111111111111111111111"},
+			{MediaType: MediaTypeDockerLayer, Digest: "sha256:2222222222222222222222222222222222222222222222222222222222222222"},
+		},
+	}
+	if !reflect.DeepEqual(img, want) {
+		t.Fatalf("got %+v, want %+v", img, want)
+	}
+	manifest, config, err := img.Convert([]string{"sha256:diff1", "sha256:diff2"}, []int{10, 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := ParseImageManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Config.Digest != fmt.Sprintf("sha256:%x", sha256.Sum256(config)) {
+		t.Errorf("config digest %s doesn't match the config", m.Config.Digest)
+	}
+	if len(m.Layers) != 2 || m.Layers[0].Size != 10 || m.Layers[1].Digest != want.Layers[1].Digest {
+		t.Errorf("unexpected layers: %+v", m.Layers)
+	}
+	var c ImageConfig
+	if err := json.Unmarshal(config, &c); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.RootFS.DiffIDs, []string{"sha256:diff1", "sha256:diff2"}) || !reflect.DeepEqual(c.Config, want.Config) {
+		t.Errorf("unexpected config: %s", config)
+	}
+}
+
+func TestManifestDigestSchema1(t *testing.T) {
+	// signing cuts the manifest before its closing brace and puts the
+	// signatures there, the protected header says where
+	n := strings.LastIndex(testSchema1Manifest, "\n}")
+	protected, _ := json.Marshal(map[string]any{
+		"formatLength": n,
+		"formatTail":   base64.RawURLEncoding.EncodeToString([]byte(testSchema1Manifest[n:])),
+	})
+	signed := testSchema1Manifest[:n] + `,
+   "signatures": [{"protected": "` + base64.RawURLEncoding.EncodeToString(protected) + `", "signature": "abc"}]` + testSchema1Manifest[n:]
+	digest, err := ManifestDigest([]byte(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(testSchema1Manifest))); digest != want {
+		t.Errorf("got %s, want %s", digest, want)
+	}
+}
@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Tags returns every tag in the repository, following the
+// registry's pagination links.
+func (c *Client) Tags(ctx context.Context, repo Repository) ([]string, error) {
+	token, err := c.Token(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	next := c.url(repo, "tags/list?n=1000")
+	var tags []string
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		setToken(req, token)
+		res, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var body struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, statusError(res)
+		}
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, body.Tags...)
+		if next, err = nextLink(req.URL, res.Header.Get("Link")); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// nextLink returns the rel="next" target of a Link header resolved
+// against the request url, or an empty string on the last page.
+func nextLink(base *url.URL, header string) (string, error) {
+	for _, link := range strings.Split(header, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+		if !ok || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if strings.ReplaceAll(strings.TrimSpace(param), " ", "") != `rel="next"` {
+				continue
+			}
+			u, err := base.Parse(target[1 : len(target)-1])
+			if err != nil {
+				return "", fmt.Errorf("invalid Link header: %w", err)
+			}
+			return u.String(), nil
+		}
+	}
+	return "", nil
+}
+
+// SplitRepository splits a repository like "bitnami/redis" into the library
+// and image names. Official images like "alpine" are in the library library.
+func SplitRepository(repo string) (string, string) {
+	if library, image, ok := strings.Cut(repo, "/"); ok {
+		return library, image
+	}
+	return "library", repo
+}
@@ -0,0 +1,36 @@
+package registry
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNextLink(t *testing.T) {
+	base, _ := url.Parse("https://registry.hub.docker.com/v2/library/alpine/tags/list?n=2")
+	tests := []struct {
+		header, want string
+	}{
+		{"", ""},
+		{`</v2/library/alpine/tags/list?last=3.19&n=2>; rel="next"`, "https://registry.hub.docker.com/v2/library/alpine/tags/list?last=3.19&n=2"},
+		{`<https://other/v2/x/tags/list?last=a>; rel="next"`, "https://other/v2/x/tags/list?last=a"},
+		{`</v2/library/alpine/tags/list?last=1>; rel="prev"`, ""},
+	}
+	for _, tt := range tests {
+		got, err := nextLink(base, tt.header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("nextLink(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestSplitRepository(t *testing.T) {
+	if library, image := SplitRepository("alpine"); library != "library" || image != "alpine" {
+		t.Errorf("unexpected split: %s/%s", library, image)
+	}
+	if library, image := SplitRepository("bitnami/redis"); library != "bitnami" || image != "redis" {
+		t.Errorf("unexpected split: %s/%s", library, image)
+	}
+}
@@ -0,0 +1,167 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// IdentityTokenUser is the username which marks the password as an
+// identity token rather than a real password, the way docker's credential
+// helpers do.
+const IdentityTokenUser = "<token>"
+
+// registryToken is a cached bearer token.
+type registryToken struct {
+	Token string
+	// Refresh is when the token should be replaced, which
+	// is a while before it actually expires.
+	Refresh time.Time
+}
+
+// Token returns a pull token for the repository. Tokens are cached by the
+// client and refreshed once three quarters of their lifetime has passed.
+// The token is empty for registries which don't require authentication.
+func (c *Client) Token(ctx context.Context, repo Repository) (string, error) {
+	challenge, err := c.AuthChallenge(ctx, repo.Registry)
+	if err != nil {
+		return "", err
+	}
+	if challenge.Realm == "" {
+		return "", nil
+	}
+	scope := fmt.Sprintf("repository:%s:pull", repo.Path())
+	// the same service can be behind more than one registry, with different credentials
+	key := repo.Registry + " " + challenge.Service + " " + scope
+	c.mu.Lock()
+	if t, ok := c.tokens[key]; ok && time.Now().Before(t.Refresh) {
+		c.mu.Unlock()
+		return t.Token, nil
+	}
+	// concurrent pulls of the same repository wait for a single token request
+	f, ok := c.tokenFetches[key]
+	if !ok {
+		f = &tokenFetch{done: make(chan struct{})}
+		if c.tokenFetches == nil {
+			c.tokenFetches = map[string]*tokenFetch{}
+		}
+		c.tokenFetches[key] = f
+	}
+	c.mu.Unlock()
+	if ok {
+		select {
+		case <-f.done:
+			return f.token.Token, f.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	f.token, f.err = c.fetchToken(ctx, repo, challenge, scope)
+	c.mu.Lock()
+	if f.err == nil {
+		if c.tokens == nil {
+			c.tokens = map[string]registryToken{}
+		}
+		c.tokens[key] = f.token
+	}
+	delete(c.tokenFetches, key)
+	c.mu.Unlock()
+	close(f.done)
+	return f.token.Token, f.err
+}
+
+// tokenFetch is a token request which other callers can wait for.
+type tokenFetch struct {
+	done  chan struct{}
+	token registryToken
+	err   error
+}
+
+// fetchToken requests a token for the scope from the auth server.
+func (c *Client) fetchToken(ctx context.Context, repo Repository, challenge AuthChallenge, scope string) (registryToken, error) {
+	var body struct {
+		Token       string    `json:"token"`
+		AccessToken string    `json:"access_token"`
+		ExpiresIn   int       `json:"expires_in"`
+		IssuedAt    time.Time `json:"issued_at"`
+	}
+	realm, err := url.Parse(challenge.Realm)
+	if err != nil {
+		return registryToken{}, fmt.Errorf("invalid auth realm: %w", err)
+	}
+	query := realm.Query()
+	if challenge.Service != "" {
+		query.Set("service", challenge.Service)
+	}
+	query.Set("scope", scope)
+	var username, password string
+	var ok bool
+	if c.Credentials != nil {
+		username, password, ok = c.Credentials(repo.Registry)
+	}
+	var req *http.Request
+	if ok && username == IdentityTokenUser {
+		// identity tokens are exchanged for an access token with the oauth2 refresh flow
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {password},
+			"service":       {challenge.Service},
+			"scope":         {scope},
+			"client_id":     {"shittydocker"},
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm.String(), strings.NewReader(form.Encode()))
+		if err != nil {
+			return registryToken{}, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		realm.RawQuery = query.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return registryToken{}, err
+		}
+		// logged in users get their own pull limits and private repositories
+		if ok {
+			req.SetBasicAuth(username, password)
+		}
+	}
+	now := time.Now()
+	res, err := c.do(req)
+	if err != nil {
+		return registryToken{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusUnauthorized {
+		return registryToken{}, fmt.Errorf("%s: %w", repo.Registry, ErrCredentialsRejected)
+	}
+	if res.StatusCode == http.StatusTooManyRequests {
+		return registryToken{}, NewRateLimitError(res)
+	}
+	if res.StatusCode != http.StatusOK {
+		return registryToken{}, fmt.Errorf("unexpected status code from auth server: %d", res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return registryToken{}, err
+	}
+	if body.Token == "" {
+		body.Token = body.AccessToken
+	}
+	// the spec says to assume 60 seconds when expires_in is missing
+	if body.ExpiresIn <= 0 {
+		body.ExpiresIn = 60
+	}
+	// use whichever clock is earlier so skew can't make us hold on to tokens too long
+	issued := now
+	if !body.IssuedAt.IsZero() && body.IssuedAt.Before(now) {
+		issued = body.IssuedAt
+	}
+	lifetime := time.Duration(body.ExpiresIn) * time.Second
+	return registryToken{
+		Token:   body.Token,
+		Refresh: issued.Add(lifetime * 3 / 4),
+	}, nil
+}
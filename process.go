@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// pidfd_open and pidfd_send_signal have the same numbers on every architecture.
+const (
+	sysPidfdSendSignal = 424
+	sysPidfdOpen       = 434
+)
+
+// ProcessStartTime returns when the process started, in clock ticks since
+// boot, from field 22 of /proc/<pid>/stat. Pids are reused, so it takes
+// both to tell whether a pid is still the same process.
+func ProcessStartTime(pid int) (uint64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// the command name in field 2 is in parens and can contain anything
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return 0, fmt.Errorf("invalid /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("invalid /proc/%d/stat", pid)
+	}
+	// fields[0] is field 3
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// isProcess reports whether pid is still the process which started at start.
+func isProcess(pid int, start uint64) bool {
+	got, err := ProcessStartTime(pid)
+	return err == nil && got == start
+}
+
+// signalProcess sends sig to pid if it's still the process which started at
+// start, and fails with ESRCH otherwise. The check is made through a pidfd,
+// so the pid can't be reused between it and sending the signal.
+func signalProcess(pid int, start uint64, sig syscall.Signal) error {
+	fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+	if errno == syscall.ENOSYS {
+		// kernels before 5.3, where the check and kill can race
+		if !isProcess(pid, start) {
+			return syscall.ESRCH
+		}
+		return syscall.Kill(pid, sig)
+	}
+	if errno != 0 {
+		return errno
+	}
+	defer syscall.Close(int(fd))
+	if !isProcess(pid, start) {
+		return syscall.ESRCH
+	}
+	if _, _, errno := syscall.Syscall6(sysPidfdSendSignal, fd, uintptr(sig), 0, 0, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
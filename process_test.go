@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestProcessIdentity(t *testing.T) {
+	start, err := ProcessStartTime(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := ProcessStartTime(os.Getpid()); err != nil || again != start {
+		t.Fatalf("start time changed from %d to %d, %v", start, again, err)
+	}
+	c := Container{ID: "abc123", State: &ContainerState{Pid: os.Getpid(), StartTime: start, StartedAt: time.Now()}}
+	if !c.Running() {
+		t.Error("expected the container to be running")
+	}
+	if err := signalProcess(os.Getpid(), start, 0); err != nil {
+		t.Errorf("failed to signal ourselves: %v", err)
+	}
+	// the pid was reused by another process
+	c.State.StartTime = start + 1
+	if c.Running() {
+		t.Error("expected a reused pid not to count as running")
+	}
+	if err := signalProcess(os.Getpid(), start+1, 0); err != syscall.ESRCH {
+		t.Errorf("expected ESRCH for a reused pid, got %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pullProgress reports layer downloads, it's nil with -quiet.
+var pullProgress *Progress
+
+// Progress shows the layers being downloaded. On a terminal every layer
+// gets a progress bar which is redrawn in place like docker pull does,
+// otherwise there's just a line when a download starts and finishes.
+type Progress struct {
+	w   io.Writer
+	tty bool
+
+	mu    sync.Mutex
+	bars  []*ProgressBar
+	drawn int
+	last  time.Time
+}
+
+// NewProgress returns a Progress writing to w.
+func NewProgress(w io.Writer, tty bool) *Progress {
+	return &Progress{w: w, tty: tty}
+}
+
+// progressRedraw is the minimum time between redraws on a terminal.
+const progressRedraw = 100 * time.Millisecond
+
+// ProgressBar tracks the download of a single layer.
+type ProgressBar struct {
+	p       *Progress
+	id      string
+	total   int64
+	current int64
+	start   time.Time
+	status  string
+}
+
+// Add starts tracking a download of total bytes.
+func (p *Progress) Add(digest string, total int64) *ProgressBar {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b := &ProgressBar{p: p, id: shortDigest(digest), total: total, start: time.Now()}
+	p.bars = append(p.bars, b)
+	if p.tty {
+		p.draw()
+	} else {
+		fmt.Fprintf(p.w, "%s: Downloading %s\n", b.id, HumanSize(total))
+	}
+	return b
+}
+
+// Reader returns a reader which counts the bytes read from r towards the bar.
+func (b *ProgressBar) Reader(r io.ReadCloser) io.ReadCloser {
+	return &progressReader{ReadCloser: r, bar: b}
+}
+
+// Done marks the download as finished with the status, like Pull complete.
+func (b *ProgressBar) Done(status string) {
+	p := b.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b.status = status
+	if p.tty {
+		p.draw()
+	} else {
+		fmt.Fprintf(p.w, "%s: %s\n", b.id, status)
+	}
+}
+
+func (b *ProgressBar) advance(n int) {
+	p := b.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b.current += int64(n)
+	if p.tty && time.Since(p.last) >= progressRedraw {
+		p.draw()
+	}
+}
+
+// draw redraws every bar by moving the cursor back up over the previous
+// ones. It must be called with mu held.
+func (p *Progress) draw() {
+	var sb strings.Builder
+	if p.drawn > 0 {
+		fmt.Fprintf(&sb, "\x1b[%dA", p.drawn)
+	}
+	now := time.Now()
+	for _, b := range p.bars {
+		sb.WriteString("\x1b[2K")
+		if b.status != "" {
+			fmt.Fprintf(&sb, "%s: %s\n", b.id, b.status)
+		} else {
+			fmt.Fprintf(&sb, "%s: Downloading %s\n", b.id, FormatProgress(b.current, b.total, now.Sub(b.start)))
+		}
+	}
+	io.WriteString(p.w, sb.String())
+	p.drawn = len(p.bars)
+	p.last = now
+}
+
+// progressWidth is the number of characters inside the brackets of a bar.
+const progressWidth = 40
+
+// FormatProgress formats a bar with the bytes downloaded so far, the speed
+// and the estimated time left, like [====>    ] 1.5MB/3MB 500kB/s ETA 3s.
+func FormatProgress(current, total int64, elapsed time.Duration) string {
+	filled := 0
+	if total > 0 {
+		filled = int(min(current, total) * progressWidth / total)
+	}
+	bar := strings.Repeat("=", filled)
+	if filled < progressWidth {
+		bar += ">" + strings.Repeat(" ", progressWidth-filled-1)
+	}
+	s := fmt.Sprintf("[%s] %s/%s", bar, HumanSize(current), HumanSize(total))
+	if elapsed < time.Second || current == 0 {
+		return s
+	}
+	rate := float64(current) / elapsed.Seconds()
+	s += fmt.Sprintf(" %s/s", HumanSize(int64(rate)))
+	if current < total {
+		eta := time.Duration(float64(total-current) / rate * float64(time.Second))
+		s += fmt.Sprintf(" ETA %s", eta.Round(time.Second))
+	}
+	return s
+}
+
+// shortDigest returns the first 12 characters of the digest's hex, the
+// way docker identifies layers.
+func shortDigest(digest string) string {
+	if _, hex, ok := strings.Cut(digest, ":"); ok {
+		digest = hex
+	}
+	if len(digest) > 12 {
+		digest = digest[:12]
+	}
+	return digest
+}
+
+type progressReader struct {
+	io.ReadCloser
+	bar *ProgressBar
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.bar.advance(n)
+	return n, err
+}
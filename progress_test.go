@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatProgress(t *testing.T) {
+	tests := []struct {
+		current, total int64
+		elapsed        time.Duration
+		want           string
+	}{
+		{
+			current: 0,
+			total:   3000000,
+			want:    "[>" + strings.Repeat(" ", 39) + "] 0B/3MB",
+		},
+		{
+			current: 1500000,
+			total:   3000000,
+			elapsed: 3 * time.Second,
+			want:    "[" + strings.Repeat("=", 20) + ">" + strings.Repeat(" ", 19) + "] 1.5MB/3MB 500kB/s ETA 3s",
+		},
+		{
+			current: 3000000,
+			total:   3000000,
+			elapsed: 2 * time.Second,
+			want:    "[" + strings.Repeat("=", 40) + "] 3MB/3MB 1.5MB/s",
+		},
+	}
+	for _, tt := range tests {
+		if got := FormatProgress(tt.current, tt.total, tt.elapsed); got != tt.want {
+			t.Errorf("FormatProgress(%d, %d, %s)\n got: %q\nwant: %q", tt.current, tt.total, tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestProgressNoTTY(t *testing.T) {
+	var out strings.Builder
+	p := NewProgress(&out, false)
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	bar := p.Add(digest, 5)
+	r := bar.Reader(io.NopCloser(strings.NewReader("hello")))
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	bar.Done("Pull complete")
+	want := "abababababab: Downloading 5B\nabababababab: Pull complete\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+	if bar.current != 5 {
+		t.Errorf("expected 5 bytes to be counted, got %d", bar.current)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PruneReport lists what SystemPrune removed.
+type PruneReport struct {
+	Containers []string
+	Snapshots  []string
+	Blobs      []string
+	Manifests  bool
+	Reclaimed  int64
+}
+
+// SystemPrune removes stopped containers, container directories left behind
+// without a record, incomplete layer extractions and blobs which aren't used
+// by an image in the index. With all set, it also removes every snapshot not
+// used by a remaining container, the manifest cache and the image index,
+// which leaves no blobs in use.
+func SystemPrune(all bool) (PruneReport, error) {
+	var report PruneReport
+	root, err := DataRoot()
+	if err != nil {
+		return report, err
+	}
+	lock, err := LockStore(true)
+	if err != nil {
+		return report, err
+	}
+	defer lock.Unlock()
+	containers, err := ListContainers()
+	if err != nil {
+		return report, err
+	}
+	mounts, err := MountPoints()
+	if err != nil {
+		return report, err
+	}
+	dir, err := ContainersDir()
+	if err != nil {
+		return report, err
+	}
+	used, err := MountedLayers()
+	if err != nil {
+		return report, err
+	}
+	for _, c := range containers {
+		cdir := filepath.Join(dir, c.ID)
+		// rootless containers are mounted in their own mount namespace
+		if mounts[filepath.Join(cdir, "rootfs")] || c.Running() {
+			for _, layer := range c.Layers {
+				used[layer] = true
+			}
+			continue
+		}
+		size, err := removeAll(cdir)
+		if err != nil {
+			return report, err
+		}
+		report.Containers = append(report.Containers, c.ID)
+		report.Reclaimed += size
+	}
+	// a container's record is written before the store is unlocked, so
+	// directories without one are from runs which died during the setup
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return report, err
+	}
+	for _, e := range entries {
+		cdir := filepath.Join(dir, e.Name())
+		if _, err := os.Stat(filepath.Join(cdir, "container.json")); !errors.Is(err, fs.ErrNotExist) || mounts[filepath.Join(cdir, "rootfs")] {
+			continue
+		}
+		size, err := removeAll(cdir)
+		if err != nil {
+			return report, err
+		}
+		report.Containers = append(report.Containers, e.Name())
+		report.Reclaimed += size
+	}
+	snapshots := filepath.Join(root, "snapshots", "sha256")
+	// nothing can be extracting with the store locked, so these are all left over
+	partial, err := filepath.Glob(filepath.Join(snapshots, ".extract-*"))
+	if err != nil {
+		return report, err
+	}
+	locks, err := filepath.Glob(filepath.Join(snapshots, "*.lock"))
+	if err != nil {
+		return report, err
+	}
+	// interrupted downloads, which the next pull would have resumed
+	downloads, err := filepath.Glob(filepath.Join(root, "blobs", "sha256", "*.partial"))
+	if err != nil {
+		return report, err
+	}
+	downloadLocks, err := filepath.Glob(filepath.Join(root, "blobs", "sha256", "*.partial.lock"))
+	if err != nil {
+		return report, err
+	}
+	downloads = append(downloads, downloadLocks...)
+	partial = append(partial, locks...)
+	partial = append(partial, downloads...)
+	for _, p := range partial {
+		size, err := removeAll(p)
+		if err != nil {
+			return report, err
+		}
+		report.Reclaimed += size
+	}
+	if all {
+		if err := pruneAll(&report, root, used); err != nil {
+			return report, err
+		}
+	}
+	return report, pruneBlobs(&report, root)
+}
+
+// pruneAll removes the snapshots which aren't used, the manifest cache and the image index.
+func pruneAll(report *PruneReport, root string, used map[string]bool) error {
+	existing, err := ListSnapshots()
+	if err != nil {
+		return err
+	}
+	for _, s := range existing {
+		if used[s.Path] {
+			continue
+		}
+		if err := os.RemoveAll(s.Path); err != nil {
+			return err
+		}
+		report.Snapshots = append(report.Snapshots, s.ID)
+		report.Reclaimed += s.Size
+	}
+	// the image index goes along with the manifests, and the
+	// snapshots it points at are mostly gone now anyway.
+	for _, dir := range []string{"manifests", "images"} {
+		size, err := removeAll(filepath.Join(root, dir))
+		if err != nil {
+			return err
+		}
+		report.Reclaimed += size
+	}
+	report.Manifests = true
+	return nil
+}
+
+// pruneBlobs removes the blobs which aren't used by an image in the index.
+func pruneBlobs(report *PruneReport, root string) error {
+	used, err := usedImageData()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(root, "blobs", "sha256")
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		// skip in progress downloads
+		digest := "sha256:" + e.Name()
+		if !isHexDigest(e.Name()) || used.Blobs[digest] {
+			continue
+		}
+		size, err := removeAll(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		report.Blobs = append(report.Blobs, digest)
+		report.Reclaimed += size
+	}
+	return nil
+}
+
+// removeAll removes path and returns the space that was used by it.
+func removeAll(path string) (int64, error) {
+	size, err := DiskUsage(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return size, os.RemoveAll(path)
+}
+
+// confirm asks the user a yes/no question on stdin.
+func confirm(prompt string) bool {
+	fmt.Print(prompt + " [y/N] ")
+	var answer string
+	_, _ = fmt.Scanln(&answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
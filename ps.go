@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// WriteContainerList writes the containers as a table like docker ps,
+// newest first. Stopped containers are only included when all is set.
+func WriteContainerList(w io.Writer, containers []Container, all bool, now time.Time) error {
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Created.After(containers[j].Created)
+	})
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(tw, "CONTAINER ID\tIMAGE\tCOMMAND\tCREATED\tSTATUS\tNAMES")
+	for _, c := range containers {
+		if !all && !c.Running() {
+			continue
+		}
+		created := "Unknown"
+		if !c.Created.IsZero() {
+			created = HumanDuration(now.Sub(c.Created)) + " ago"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", c.ID[:min(12, len(c.ID))], c.Image, quoteCommand(c.Command), created, c.Status(now), c.Name)
+	}
+	return tw.Flush()
+}
+
+// quoteCommand quotes the command and truncates it to fit in the table.
+func quoteCommand(args []string) string {
+	cmd := strings.Join(args, " ")
+	if r := []rune(cmd); len(r) > 20 {
+		cmd = string(r[:19]) + "…"
+	}
+	return strconv.Quote(cmd)
+}
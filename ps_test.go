@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteContainerList(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	containers := []Container{
+		{
+			ID:      "aaaaaaaaaaaa1111",
+			Image:   "alpine:latest",
+			Command: []string{"sh", "-c", "echo a very long command"},
+			Created: now.Add(-2 * time.Hour),
+			State: &ContainerState{
+				StartedAt:  now.Add(-2 * time.Hour),
+				FinishedAt: now.Add(-90 * time.Minute),
+				ExitCode:   3,
+			},
+		},
+		{
+			ID:      "bbbbbbbbbbbb2222",
+			Name:    "brave_curie",
+			Image:   "nginx:latest",
+			Command: []string{"nginx"},
+			Created: now.Add(-5 * time.Minute),
+		},
+	}
+	var b strings.Builder
+	if err := WriteContainerList(&b, containers, true, now); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a header and 2 containers, got:\n%s", b.String())
+	}
+	if !strings.HasPrefix(lines[1], "bbbbbbbbbbbb ") || !strings.Contains(lines[1], "Created") || !strings.HasSuffix(lines[1], "brave_curie") {
+		t.Errorf("expected the newest container first: %q", lines[1])
+	}
+	if !strings.Contains(lines[2], `"sh -c echo a very l…"`) || !strings.Contains(lines[2], "Exited (3) 2 hours ago") {
+		t.Errorf("unexpected line: %q", lines[2])
+	}
+	b.Reset()
+	if err := WriteContainerList(&b, containers, false, now); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(b.String(), "\n"); n != 1 {
+		t.Errorf("expected only the header without -a, got:\n%s", b.String())
+	}
+}
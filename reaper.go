@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+// prSetChildSubreaper is PR_SET_CHILD_SUBREAPER from linux/prctl.h
+const prSetChildSubreaper = 36
+
+// runInit runs the command as a child instead of exec'ing it, so init stays
+// around as pid 1 to reap the zombies orphaned in the container and to
+// forward the signals it gets to the command. It returns the command's
+// exit status, or 128+n when it was killed by signal n.
+func runInit(path string, args, env []string) (int, error) {
+	// without a pid namespace the orphans only come to init as a subreaper
+	if _, _, errno := syscall.Syscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+		return 0, fmt.Errorf("failed to become a subreaper: %w", errno)
+	}
+	// registered before the fork so an early exit isn't missed
+	sigs := make(chan os.Signal, 16)
+	signal.Notify(sigs)
+	defer signal.Stop(sigs)
+	// the command gets the terminal to itself, so the signals typed
+	// into it don't reach it twice
+	var pgrp int32
+	tty := ioctl(0, syscall.TIOCGPGRP, unsafe.Pointer(&pgrp)) == nil
+	pid, err := syscall.ForkExec(path, args, &syscall.ProcAttr{
+		Env:   env,
+		Files: []uintptr{0, 1, 2},
+		Sys:   &syscall.SysProcAttr{Setpgid: true, Foreground: tty},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to start %s: %w", path, err)
+	}
+	for {
+		switch sig := <-sigs; sig {
+		case syscall.SIGCHLD:
+			for {
+				var status syscall.WaitStatus
+				reaped, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+				if err != nil || reaped <= 0 {
+					break
+				}
+				if reaped == pid {
+					return exitCode(status), nil
+				}
+			}
+		case syscall.SIGURG:
+			// used by the go runtime for preemption
+		default:
+			syscall.Kill(pid, sig.(syscall.Signal))
+		}
+	}
+}
+
+// exitCode converts a wait status to a shell style exit code.
+func exitCode(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestRunInit(t *testing.T) {
+	tests := []struct {
+		script string
+		want   int
+	}{
+		{"exit 3", 3},
+		// the orphaned sleep is reaped without being waited for
+		{"(sleep 0.1 &); exit 0", 0},
+		{"kill -9 $$", 137},
+	}
+	for _, tt := range tests {
+		got, err := runInit("/bin/sh", []string{"sh", "-c", tt.script}, nil)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.script, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: got exit code %d, want %d", tt.script, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+// The image types are defined by the registry package.
+type (
+	Repository         = registry.Repository
+	Reference          = registry.Reference
+	Manifest           = registry.Manifest
+	Layer              = registry.Layer
+	ImageManifest      = registry.ImageManifest
+	ImageConfig        = registry.ImageConfig
+	ImageRuntimeConfig = registry.ImageRuntimeConfig
+	Platform           = registry.Platform
+)
+
+// registryClient pulls with the stored credentials and caches manifests
+// in the data root.
+var registryClient = &registry.Client{
+	HTTPClient: httpClient,
+	Credentials: func(host string) (string, string, bool) {
+		return Credentials(credentialsServer(host))
+	},
+	Cache:       manifestCache{},
+	OnRateLimit: warnRateLimit,
+}
+
+// manifestCache is the registry.ManifestCache kept in the data root.
+type manifestCache struct{}
+
+func (manifestCache) Get(repo Repository, reference string) ([]byte, string, bool) {
+	return ReadCachedManifest(repo, reference)
+}
+
+func (manifestCache) Put(repo Repository, reference, etag string, data []byte) {
+	if err := WriteCachedManifest(repo, reference, etag, data); err != nil {
+		log.Printf("failed to cache manifest: %v", err)
+	}
+}
+
+var rateLimitWarning sync.Once
+
+// warnRateLimit logs a warning, once, if the pull
+// rate limit is close to being exhausted.
+func warnRateLimit(rl registry.RateLimit) {
+	if rl.Low() {
+		rateLimitWarning.Do(func() {
+			log.Printf("WARNING: approaching the Docker Hub pull rate limit: %s", rl)
+		})
+	}
+}
+
+// openLayer starts downloading a layer, resuming an earlier download of it
+// if one was interrupted.
+func openLayer(ctx context.Context, repo Repository, layer Layer) (io.ReadCloser, error) {
+	return openBlobDownload(ctx, repo, layer)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ResolvConf is the resolver configuration of a container.
+type ResolvConf struct {
+	Nameservers []string
+	Search      []string
+	Options     []string
+}
+
+// ParseResolvConf reads the nameserver, search and options lines of a resolv.conf.
+func ParseResolvConf(data []byte) ResolvConf {
+	var rc ResolvConf
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			rc.Nameservers = append(rc.Nameservers, fields[1])
+		case "search", "domain":
+			// the last search or domain line wins
+			rc.Search = fields[1:]
+		case "options":
+			rc.Options = append(rc.Options, fields[1:]...)
+		}
+	}
+	return rc
+}
+
+// Override replaces each section of the configuration which is set in o,
+// the same way docker merges --dns flags over the host's resolv.conf.
+func (rc ResolvConf) Override(o ResolvConf) (ResolvConf, error) {
+	for _, ns := range o.Nameservers {
+		if _, err := netip.ParseAddr(ns); err != nil {
+			return rc, fmt.Errorf("invalid nameserver: %q", ns)
+		}
+	}
+	if len(o.Nameservers) > 0 {
+		rc.Nameservers = o.Nameservers
+	}
+	// a search domain of "." clears the search list
+	if len(o.Search) == 1 && o.Search[0] == "." {
+		rc.Search = nil
+	} else if len(o.Search) > 0 {
+		rc.Search = o.Search
+	}
+	if len(o.Options) > 0 {
+		rc.Options = o.Options
+	}
+	return rc, nil
+}
+
+// Bytes formats the configuration as a resolv.conf.
+func (rc ResolvConf) Bytes() []byte {
+	var b bytes.Buffer
+	if len(rc.Search) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(rc.Search, " "))
+	}
+	for _, ns := range rc.Nameservers {
+		fmt.Fprintf(&b, "nameserver %s\n", ns)
+	}
+	if len(rc.Options) > 0 {
+		fmt.Fprintf(&b, "options %s\n", strings.Join(rc.Options, " "))
+	}
+	return b.Bytes()
+}
+
+// defaultNameservers are used, like docker does, by containers with their own
+// network when the host only has loopback nameservers.
+var defaultNameservers = []string{"8.8.8.8", "8.8.4.4"}
+
+// systemdResolvConf lists the upstream nameservers of systemd-resolved, whose
+// stub resolver on 127.0.0.53 is all the host's /etc/resolv.conf points at.
+const systemdResolvConf = "/run/systemd/resolve/resolv.conf"
+
+// withoutLoopback drops the nameservers on loopback addresses, which aren't
+// the host's inside a network namespace of its own.
+func (rc ResolvConf) withoutLoopback() ResolvConf {
+	var nameservers []string
+	for _, ns := range rc.Nameservers {
+		if addr, err := netip.ParseAddr(ns); err == nil && addr.IsLoopback() {
+			continue
+		}
+		nameservers = append(nameservers, ns)
+	}
+	rc.Nameservers = nameservers
+	return rc
+}
+
+// isolatedResolvConf returns the host's resolver configuration for a container
+// with its own network. The host's loopback nameservers can't be reached from
+// it, so they're replaced by systemd-resolved's upstream ones, or by public
+// resolvers when there aren't any.
+func isolatedResolvConf(host []byte) ResolvConf {
+	rc := ParseResolvConf(host)
+	if len(rc.Nameservers) > 0 && len(rc.withoutLoopback().Nameservers) == 0 {
+		if upstream, err := os.ReadFile(systemdResolvConf); err == nil {
+			rc = ParseResolvConf(upstream)
+		}
+	}
+	rc = rc.withoutLoopback()
+	if len(rc.Nameservers) == 0 {
+		rc.Nameservers = defaultNameservers
+	}
+	return rc
+}
+
+// InstallResolvConf writes the host's resolv.conf, with the overrides
+// applied, to the rootfs. Containers on the host's network use it as is,
+// ones with a network of their own get it without loopback nameservers.
+func InstallResolvConf(rootfs string, override ResolvConf, isolated bool) error {
+	host, err := os.ReadFile("/etc/resolv.conf")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	rc := ParseResolvConf(host)
+	if isolated {
+		rc = isolatedResolvConf(host)
+	}
+	rc, err = rc.Override(override)
+	if err != nil {
+		return err
+	}
+	etc, err := rootfsEtc(rootfs)
+	if err != nil {
+		return err
+	}
+	return writeNoFollow(filepath.Join(etc, "resolv.conf"), bytes.NewReader(rc.Bytes()))
+}
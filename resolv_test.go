@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestResolvConf(t *testing.T) {
+	host := ParseResolvConf([]byte(`# generated by NetworkManager
+domain corp.example.com
+search corp.example.com example.com
+nameserver 10.0.0.2
+nameserver 10.0.0.3
+options ndots:1 timeout:2
+`))
+	tests := []struct {
+		override ResolvConf
+		expected string
+	}{
+		{
+			ResolvConf{},
+			"search corp.example.com example.com\nnameserver 10.0.0.2\nnameserver 10.0.0.3\noptions ndots:1 timeout:2\n",
+		},
+		{
+			ResolvConf{Nameservers: []string{"1.1.1.1", "2606:4700:4700::1111"}},
+			"search corp.example.com example.com\nnameserver 1.1.1.1\nnameserver 2606:4700:4700::1111\noptions ndots:1 timeout:2\n",
+		},
+		{
+			ResolvConf{Search: []string{"."}, Options: []string{"ndots:5"}},
+			"nameserver 10.0.0.2\nnameserver 10.0.0.3\noptions ndots:5\n",
+		},
+	}
+	for _, tt := range tests {
+		rc, err := host.Override(tt.override)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(rc.Bytes()); got != tt.expected {
+			t.Errorf("override %+v:\ngot:\n%s\nexpected:\n%s", tt.override, got, tt.expected)
+		}
+	}
+	if _, err := host.Override(ResolvConf{Nameservers: []string{"dns.example.com"}}); err == nil {
+		t.Error("expected a hostname nameserver to be rejected")
+	}
+}
+
+func TestIsolatedResolvConf(t *testing.T) {
+	rc := isolatedResolvConf([]byte("nameserver 127.0.0.1\nnameserver ::1\nnameserver 10.0.0.2\nsearch example.com\n"))
+	if got, expected := string(rc.Bytes()), "search example.com\nnameserver 10.0.0.2\n"; got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+	// without systemd-resolved's upstream servers, the defaults are used
+	if _, err := os.Stat(systemdResolvConf); err == nil {
+		t.Skip("the host has systemd-resolved")
+	}
+	rc = isolatedResolvConf([]byte("nameserver 127.0.0.53\noptions edns0\n"))
+	if got, expected := string(rc.Bytes()), "nameserver 8.8.8.8\nnameserver 8.8.4.4\noptions edns0\n"; got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+// retryTransport retries registry requests which failed in a way that's
+// likely to be temporary, like a dropped connection, a 5xx from an
+// overloaded registry, or a 429 from a rate limiter. Retries back off
+// exponentially with jitter, or wait as long as Retry-After asks for.
+type retryTransport struct {
+	next http.RoundTripper
+	// Attempts is the total number of tries, including the first one.
+	Attempts int
+	// Backoff is the delay before the first retry, it doubles after each one.
+	Backoff time.Duration
+}
+
+// maxBackoff caps the delay between retries. Requests which are asked
+// to wait longer than this with Retry-After aren't retried at all.
+const maxBackoff = time.Minute
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		res, err := t.next.RoundTrip(req)
+		if attempt >= t.Attempts || !retryable(req, res, err) {
+			return res, err
+		}
+		delay := t.backoff(attempt)
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		} else {
+			if d, ok := registry.ParseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+				// like docker hub's pull limit, which resets hours later
+				if d > maxBackoff {
+					return res, nil
+				}
+				delay = d
+			}
+			reason = res.Status
+			// drain a little so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+			res.Body.Close()
+		}
+		log.Printf("%s %s: %s, retrying in %s", req.Method, req.URL.Redacted(), reason, delay.Round(time.Millisecond))
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+		if req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// backoff returns the delay before the retry following the attempt,
+// randomized so that parallel downloads don't all retry at once.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	d := min(t.Backoff<<(attempt-1), maxBackoff)
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryable reports whether the request can be sent again after failing.
+func retryable(req *http.Request, res *http.Response, err error) bool {
+	// the body has already been consumed unless it can be recreated
+	if req.Body != nil && req.GetBody == nil {
+		return false
+	}
+	if err != nil {
+		// a certificate which doesn't verify won't start verifying
+		var certErr *tls.CertificateVerificationError
+		return req.Context().Err() == nil && !errors.As(err, &certErr)
+	}
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &retryTransport{next: srv.Client().Transport, Attempts: 3, Backoff: time.Millisecond}}
+	res, err := client.Post(srv.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || requests != 3 {
+		t.Errorf("got %s after %d requests", res.Status, requests)
+	}
+}
+
+func TestRetryTransportGivesUp(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &retryTransport{next: srv.Client().Transport, Attempts: 2, Backoff: time.Millisecond}}
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadGateway || requests != 2 {
+		t.Errorf("got %s after %d requests", res.Status, requests)
+	}
+	// client errors aren't retried
+	requests = 0
+	srv.Config.Handler = http.NotFoundHandler()
+	if res, err = client.Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("got %s", res.Status)
+	}
+}
+
+func TestRetryTransportLongRetryAfter(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &retryTransport{next: srv.Client().Transport, Attempts: 3, Backoff: time.Millisecond}}
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusTooManyRequests || requests != 1 {
+		t.Errorf("got %s after %d requests", res.Status, requests)
+	}
+}
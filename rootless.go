@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// rootlessEnv is set when shittydocker has been re-executed in a user
+// namespace for an unprivileged user. It's rootlessSetup until the id
+// mappings have been written.
+const (
+	rootlessEnv   = "_SHITTYDOCKER_ROOTLESS"
+	rootlessSetup = "setup"
+)
+
+// Rootless reports whether shittydocker is running in the user namespace
+// created by EnterRootless.
+func Rootless() bool {
+	return os.Getenv(rootlessEnv) != ""
+}
+
+// IDMap maps a range of ids in the user namespace to ids on the host.
+type IDMap struct {
+	ContainerID int
+	HostID      int
+	Size        int
+}
+
+// ReadSubIDs returns the subordinate id ranges delegated to a user in
+// /etc/subuid or /etc/subgid, where they can be listed by name or by id.
+// A missing file means the user has none.
+func ReadSubIDs(path, name string, id int) ([]IDMap, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ranges []IDMap
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 || (fields[0] != name && fields[0] != strconv.Itoa(id)) {
+			continue
+		}
+		start, err1 := strconv.Atoi(fields[1])
+		size, err2 := strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil || start < 0 || size <= 0 {
+			return nil, fmt.Errorf("%s: invalid entry: %q", path, line)
+		}
+		ranges = append(ranges, IDMap{HostID: start, Size: size})
+	}
+	return ranges, sc.Err()
+}
+
+// RootlessIDMaps maps root in the user namespace to the user's own id
+// and gives the subordinate ids the ids after it, so files owned by
+// uid 1 in an image are owned by the first subordinate id on the host.
+func RootlessIDMaps(id int, sub []IDMap) []IDMap {
+	maps := []IDMap{{ContainerID: 0, HostID: id, Size: 1}}
+	next := 1
+	for _, r := range sub {
+		maps = append(maps, IDMap{ContainerID: next, HostID: r.HostID, Size: r.Size})
+		next += r.Size
+	}
+	return maps
+}
+
+// EnterRootless re-executes shittydocker in a new user and mount namespace in
+// which the unprivileged user is root, so images can be extracted with their
+// ownership, overlays mounted, and containers created without sudo. It only
+// returns in the re-executed process, the original one exits along with it.
+func EnterRootless() {
+	switch os.Getenv(rootlessEnv) {
+	case "":
+	case rootlessSetup:
+		// wait for the mappings, then exec again since capabilities in the
+		// namespace are only granted by an exec after root has been mapped.
+		pipe := os.NewFile(syncFD, "sync")
+		var b [1]byte
+		_, err := pipe.Read(b[:])
+		pipe.Close()
+		if err != nil {
+			os.Exit(1)
+		}
+		log.Fatal(syscall.Exec("/proc/self/exe", os.Args, rootlessEnviron("1")))
+	default:
+		return
+	}
+	u, err := user.Current()
+	if err != nil {
+		log.Fatal(err)
+	}
+	subuids, err := ReadSubIDs("/etc/subuid", u.Username, os.Getuid())
+	if err != nil {
+		log.Fatal(err)
+	}
+	subgids, err := ReadSubIDs("/etc/subgid", u.Username, os.Getuid())
+	if err != nil {
+		log.Fatal(err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cmd := &exec.Cmd{
+		Path:       "/proc/self/exe",
+		Args:       os.Args,
+		Env:        rootlessEnviron(rootlessSetup),
+		Stdin:      os.Stdin,
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+		ExtraFiles: []*os.File{r},
+		SysProcAttr: &syscall.SysProcAttr{
+			Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+		},
+	}
+	if err := cmd.Start(); err != nil {
+		log.Fatalf("failed to create a user namespace, run as root or enable unprivileged user namespaces: %v", err)
+	}
+	r.Close()
+	pid := cmd.Process.Pid
+	if err := writeIDMaps(pid, "uid", RootlessIDMaps(os.Getuid(), subuids)); err != nil {
+		cmd.Process.Kill()
+		log.Fatal(err)
+	}
+	if err := writeIDMaps(pid, "gid", RootlessIDMaps(os.Getgid(), subgids)); err != nil {
+		cmd.Process.Kill()
+		log.Fatal(err)
+	}
+	if len(subuids) == 0 || len(subgids) == 0 {
+		log.Printf("WARNING: %s has no subordinate ids in /etc/subuid or /etc/subgid, files in images will all be owned by root", u.Username)
+	}
+	w.Write([]byte{0})
+	w.Close()
+	// the terminal's ^C already reaches the whole process group
+	signal.Ignore(syscall.SIGINT, syscall.SIGQUIT)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range sigs {
+			cmd.Process.Signal(sig)
+		}
+	}()
+	if err := cmd.Wait(); err != nil && cmd.ProcessState == nil {
+		log.Fatal(err)
+	}
+	code, _ := ExitStatus(cmd.ProcessState.Sys().(syscall.WaitStatus))
+	os.Exit(code)
+}
+
+// rootlessEnviron returns the environment with rootlessEnv set to value.
+func rootlessEnviron(value string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, rootlessEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	return append(env, rootlessEnv+"="+value)
+}
+
+// writeIDMaps sets up the uid or gid mappings of the process. Mapping more
+// than the user's own id needs the setuid newuidmap and newgidmap helpers,
+// which check the ranges against /etc/subuid and /etc/subgid.
+func writeIDMaps(pid int, kind string, maps []IDMap) error {
+	if len(maps) == 1 {
+		if kind == "gid" {
+			// unprivileged gid mappings require setgroups to be disabled
+			if err := os.WriteFile(fmt.Sprintf("/proc/%d/setgroups", pid), []byte("deny"), 0644); err != nil {
+				return err
+			}
+		}
+		m := maps[0]
+		data := fmt.Sprintf("%d %d %d\n", m.ContainerID, m.HostID, m.Size)
+		if err := os.WriteFile(fmt.Sprintf("/proc/%d/%s_map", pid, kind), []byte(data), 0644); err != nil {
+			return fmt.Errorf("failed to write %s map: %w", kind, err)
+		}
+		return nil
+	}
+	helper := "new" + kind + "map"
+	args := []string{strconv.Itoa(pid)}
+	for _, m := range maps {
+		args = append(args, strconv.Itoa(m.ContainerID), strconv.Itoa(m.HostID), strconv.Itoa(m.Size))
+	}
+	// NOTE: shelling out since only the setuid helpers can map subordinate ids
+	out, err := exec.Command(helper, args...).CombinedOutput()
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("%s isn't installed, it's usually in the uidmap package", helper)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", helper, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
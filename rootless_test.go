@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadSubIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subuid")
+	data := "# comment\nalice:100000:65536\nbob:165536:65536\n1000:231072:1000\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ranges, err := ReadSubIDs(path, "alice", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []IDMap{{HostID: 100000, Size: 65536}, {HostID: 231072, Size: 1000}}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("got %v, want %v", ranges, want)
+	}
+	if ranges, err := ReadSubIDs(filepath.Join(t.TempDir(), "missing"), "alice", 1000); err != nil || ranges != nil {
+		t.Errorf("expected no ranges for a missing file, got %v, %v", ranges, err)
+	}
+}
+
+func TestRootlessIDMaps(t *testing.T) {
+	maps := RootlessIDMaps(1000, []IDMap{{HostID: 100000, Size: 65536}, {HostID: 231072, Size: 1000}})
+	want := []IDMap{
+		{ContainerID: 0, HostID: 1000, Size: 1},
+		{ContainerID: 1, HostID: 100000, Size: 65536},
+		{ContainerID: 65537, HostID: 231072, Size: 1000},
+	}
+	if !reflect.DeepEqual(maps, want) {
+		t.Errorf("got %v, want %v", maps, want)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+// fetchSchema1Snapshots pulls an image with a legacy schema 1 manifest. Those
+// don't have the diff ids snapshots are keyed by, so each layer is downloaded
+// to the blob store to work out its diff id before it's extracted. The image
+// is recorded with a schema 2 manifest and config converted from it, like
+// docker does, so it's used like any other image afterwards.
+func fetchSchema1Snapshots(ctx context.Context, ref Reference, data []byte) ([]string, error) {
+	img, err := registry.ParseSchema1(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ref, err)
+	}
+	log.Printf("%s has a schema 1 manifest, converting it", ref)
+	dirs := make([]string, len(img.Layers))
+	diffIDs := make([]string, len(img.Layers))
+	sizes := make([]int, len(img.Layers))
+	for i, layer := range img.Layers {
+		dirs[i], diffIDs[i], sizes[i], err = fetchSchema1Layer(ctx, ref.Repository, layer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch layer %s: %w", layer.Digest, err)
+		}
+	}
+	manifest, config, err := img.Convert(diffIDs, sizes)
+	if err != nil {
+		return nil, err
+	}
+	if err := storeBlob(fmt.Sprintf("sha256:%x", sha256.Sum256(config)), config); err != nil {
+		return nil, err
+	}
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+	if err := storeBlob(digest, manifest); err != nil {
+		return nil, err
+	}
+	if err := WriteImageRecord(ref, ImageRecord{Manifest: digest, PulledAt: time.Now(), Platform: pullPlatform.String()}); err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
+
+// fetchSchema1Layer downloads the layer to the blob store and extracts it
+// into its snapshot, returning the snapshot dir, the diff id and the size of
+// the layer. The blob is removed again unless it was in the store already.
+func fetchSchema1Layer(ctx context.Context, repo Repository, layer Layer) (string, string, int, error) {
+	p, err := BlobPath(layer.Digest)
+	if err != nil {
+		return "", "", 0, err
+	}
+	if _, err := os.Stat(p); err != nil {
+		defer os.Remove(p)
+	}
+	if _, err := FetchBlob(ctx, repo, layer.Digest); err != nil {
+		return "", "", 0, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return "", "", 0, err
+	}
+	diffID, err := layerDiffID(p)
+	if err != nil {
+		return "", "", 0, err
+	}
+	dir, err := SnapshotDir(diffID)
+	if err != nil {
+		return "", "", 0, err
+	}
+	err = ensureSnapshot(dir, func() error {
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return createSnapshot(dir, f, diffID)
+	})
+	return dir, diffID, int(info.Size()), err
+}
+
+// layerDiffID returns the digest of the uncompressed layer in the file.
+func layerDiffID(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	layer, err := decompressLayer(bufio.NewReader(f))
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, layer); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+func TestFetchSchema1Snapshots(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	tw.WriteHeader(&tar.Header{Name: "hello", Mode: 0644, Size: 5, Typeflag: tar.TypeReg})
+	tw.Write([]byte("hello"))
+	tw.Close()
+	zw.Close()
+	layer := buf.Bytes()
+	layerDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+	manifest := fmt.Sprintf(`{
+		"schemaVersion": 1,
+		"fsLayers": [{"blobSum": %q}],
+		"history": [{"v1Compatibility": "{\"os\":\"linux\",\"config\":{\"Cmd\":[\"sh\"]}}"}]
+	}`, layerDigest)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+		case "/v2/library/test/manifests/latest":
+			w.Header().Set("Content-Type", registry.MediaTypeDockerManifestV1)
+			fmt.Fprint(w, manifest)
+		case "/v2/library/test/manifests/" + fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(manifest))):
+			fmt.Fprint(w, manifest)
+		case "/v2/library/test/blobs/" + layerDigest:
+			w.Write(layer)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	old := registryClient
+	registryClient = &registry.Client{BaseURL: srv.URL}
+	defer func() { registryClient = old }()
+	ref := Reference{Repository: Repository{Registry: "example.com", Library: "library", Image: "test"}, Tag: "latest"}
+	dirs, err := FetchImageSnapshots(context.Background(), ref, PullAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 {
+		t.Fatalf("unexpected snapshots: %v", dirs)
+	}
+	if data, err := os.ReadFile(filepath.Join(dirs[0], "hello")); err != nil || string(data) != "hello" {
+		t.Fatalf("the layer wasn't extracted: %q, %v", data, err)
+	}
+	p, err := BlobPath(layerDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(p); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the layer blob to be removed, got %v", err)
+	}
+	// the converted manifest is what's recorded locally
+	local, err := FetchImageSnapshots(context.Background(), ref, PullNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(local) != 1 || local[0] != dirs[0] {
+		t.Fatalf("unexpected local snapshots: %v", local)
+	}
+}
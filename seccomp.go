@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// SeccompProfile is a seccomp profile in docker's JSON format.
+type SeccompProfile struct {
+	DefaultAction   string           `json:"defaultAction"`
+	DefaultErrnoRet *uint32          `json:"defaultErrnoRet,omitempty"`
+	Syscalls        []SeccompSyscall `json:"syscalls"`
+}
+
+// SeccompSyscall is a rule which applies an action to the syscalls
+// when all of the argument conditions match.
+type SeccompSyscall struct {
+	// Name is from older profiles which had a rule per syscall.
+	Name     string        `json:"name,omitempty"`
+	Names    []string      `json:"names,omitempty"`
+	Action   string        `json:"action"`
+	ErrnoRet *uint32       `json:"errnoRet,omitempty"`
+	Args     []SeccompArg  `json:"args,omitempty"`
+	Includes SeccompFilter `json:"includes"`
+	Excludes SeccompFilter `json:"excludes"`
+}
+
+// SeccompArg compares a syscall argument against Value, or for
+// SCMP_CMP_MASKED_EQ masks it with Value and compares it against ValueTwo.
+type SeccompArg struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo,omitempty"`
+	Op       string `json:"op"`
+}
+
+// SeccompFilter decides whether a rule applies to the container. Arches
+// are GOARCH names and Caps are the capabilities the container has.
+type SeccompFilter struct {
+	Arches    []string `json:"arches,omitempty"`
+	Caps      []string `json:"caps,omitempty"`
+	MinKernel string   `json:"minKernel,omitempty"`
+}
+
+// ReadSeccompProfile reads a profile from a JSON file.
+func ReadSeccompProfile(path string) (SeccompProfile, error) {
+	var p SeccompProfile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("invalid seccomp profile %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// errSeccompUnsupported is returned when there's no syscall table for the architecture.
+var errSeccompUnsupported = fmt.Errorf("seccomp isn't supported on %s", runtime.GOARCH)
+
+// Compile turns the profile into a BPF program for a container with the
+// capabilities. Syscalls which don't exist on this architecture are
+// skipped, since profiles list them for every architecture they support.
+// Syscalls made through another ABI, like x32 on amd64, get the default
+// action.
+func (p SeccompProfile) Compile(caps []string) ([]byte, error) {
+	if syscallNumbers == nil {
+		return nil, errSeccompUnsupported
+	}
+	defaultAction, err := seccompAction(p.DefaultAction, p.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+	prog := []sockFilter{
+		bpfLoad(seccompDataArch),
+		bpfJump(syscall.BPF_JEQ, auditArch, 1, 0),
+		bpfRet(defaultAction),
+		bpfLoad(seccompDataNr),
+		bpfJump(syscall.BPF_JGE, x32SyscallBit, 0, 1),
+		bpfRet(defaultAction),
+	}
+	for _, rule := range p.Syscalls {
+		applies, err := rule.applies(caps)
+		if err != nil {
+			return nil, err
+		}
+		if !applies {
+			continue
+		}
+		action, err := seccompAction(rule.Action, rule.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+		names := rule.Names
+		if rule.Name != "" {
+			names = append(names, rule.Name)
+		}
+		for _, name := range names {
+			nr, ok := syscallNumbers[name]
+			if !ok {
+				continue
+			}
+			block, err := compileSeccompRule(nr, action, rule.Args)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			prog = append(prog, block...)
+		}
+	}
+	prog = append(prog, bpfRet(defaultAction))
+	// BPF_MAXINSNS from linux/bpf_common.h
+	if len(prog) > 4096 {
+		return nil, fmt.Errorf("seccomp profile has too many rules")
+	}
+	data := make([]byte, 0, len(prog)*8)
+	for _, insn := range prog {
+		data = binary.NativeEndian.AppendUint16(data, insn.Code)
+		data = append(data, insn.Jt, insn.Jf)
+		data = binary.NativeEndian.AppendUint32(data, insn.K)
+	}
+	return data, nil
+}
+
+// applies reports whether the rule's includes and excludes
+// select it for a container with the capabilities.
+func (r SeccompSyscall) applies(caps []string) (bool, error) {
+	inc, exc := r.Includes, r.Excludes
+	if len(inc.Arches) > 0 && !slices.Contains(inc.Arches, runtime.GOARCH) {
+		return false, nil
+	}
+	if slices.Contains(exc.Arches, runtime.GOARCH) {
+		return false, nil
+	}
+	for _, c := range inc.Caps {
+		if !slices.Contains(caps, c) {
+			return false, nil
+		}
+	}
+	for _, c := range exc.Caps {
+		if slices.Contains(caps, c) {
+			return false, nil
+		}
+	}
+	if inc.MinKernel != "" {
+		ok, err := kernelAtLeast(inc.MinKernel)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// The SECCOMP_RET_* actions from linux/seccomp.h
+const (
+	seccompRetKillProcess = 0x80000000
+	seccompRetKillThread  = 0x00000000
+	seccompRetTrap        = 0x00030000
+	seccompRetErrno       = 0x00050000
+	seccompRetTrace       = 0x7ff00000
+	seccompRetLog         = 0x7ffc0000
+	seccompRetAllow       = 0x7fff0000
+)
+
+// seccompAction returns the filter's return value for a profile action.
+func seccompAction(action string, errnoRet *uint32) (uint32, error) {
+	switch action {
+	case "SCMP_ACT_ALLOW":
+		return seccompRetAllow, nil
+	case "SCMP_ACT_ERRNO":
+		errno := uint32(syscall.EPERM)
+		if errnoRet != nil {
+			errno = *errnoRet
+		}
+		return seccompRetErrno | errno&0xffff, nil
+	case "SCMP_ACT_KILL", "SCMP_ACT_KILL_THREAD":
+		return seccompRetKillThread, nil
+	case "SCMP_ACT_KILL_PROCESS":
+		return seccompRetKillProcess, nil
+	case "SCMP_ACT_TRAP":
+		return seccompRetTrap, nil
+	case "SCMP_ACT_TRACE":
+		// without a tracer attached the syscall fails with ENOSYS
+		return seccompRetTrace, nil
+	case "SCMP_ACT_LOG":
+		return seccompRetLog, nil
+	}
+	return 0, fmt.Errorf("unsupported seccomp action %q", action)
+}
+
+// Offsets of the fields of struct seccomp_data.
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArgs = 16
+)
+
+// x32SyscallBit is __X32_SYSCALL_BIT, which no native syscall number has.
+const x32SyscallBit = 0x40000000
+
+// sockFilter is struct sock_filter from linux/filter.h
+type sockFilter struct {
+	Code   uint16
+	Jt, Jf uint8
+	K      uint32
+}
+
+func bpfLoad(offset uint32) sockFilter {
+	return sockFilter{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, K: offset}
+}
+
+func bpfJump(op uint16, k uint32, jt, jf uint8) sockFilter {
+	return sockFilter{Code: syscall.BPF_JMP | op | syscall.BPF_K, Jt: jt, Jf: jf, K: k}
+}
+
+func bpfRet(k uint32) sockFilter {
+	return sockFilter{Code: syscall.BPF_RET | syscall.BPF_K, K: k}
+}
+
+// bpfFail is a jump target which stands for the failure of a condition,
+// it's replaced once the rule's block is complete.
+const bpfFail = 0xff
+
+// compileSeccompRule returns the instructions for a rule on a single
+// syscall, which expect the syscall number to be loaded and leave it
+// loaded when the rule doesn't match.
+func compileSeccompRule(nr, action uint32, args []SeccompArg) ([]sockFilter, error) {
+	if len(args) == 0 {
+		return []sockFilter{
+			bpfJump(syscall.BPF_JEQ, nr, 0, 1),
+			bpfRet(action),
+		}, nil
+	}
+	var conds []sockFilter
+	for _, arg := range args {
+		cond, err := compileSeccompArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond...)
+	}
+	// the conditions clobber the syscall number, so a failed one jumps
+	// to reloading it at the end of the block
+	fail := len(conds) + 1
+	if fail > 254 {
+		return nil, fmt.Errorf("too many argument conditions")
+	}
+	for i := range conds {
+		if conds[i].Jt == bpfFail {
+			conds[i].Jt = uint8(fail - i - 1)
+		}
+		if conds[i].Jf == bpfFail {
+			conds[i].Jf = uint8(fail - i - 1)
+		}
+	}
+	block := []sockFilter{bpfJump(syscall.BPF_JEQ, nr, 0, uint8(fail+1))}
+	block = append(block, conds...)
+	return append(block, bpfRet(action), bpfLoad(seccompDataNr)), nil
+}
+
+// compileSeccompArg returns the instructions which check an argument,
+// continuing after them when it matches and jumping to bpfFail when
+// it doesn't. The 64 bit arguments are compared one half at a time,
+// starting with the high one.
+func compileSeccompArg(arg SeccompArg) ([]sockFilter, error) {
+	if arg.Index > 5 {
+		return nil, fmt.Errorf("invalid argument index %d", arg.Index)
+	}
+	lo := uint32(seccompDataArgs + 8*arg.Index)
+	hi := lo + 4
+	if binary.NativeEndian.Uint16([]byte{0, 1}) == 1 {
+		lo, hi = hi, lo
+	}
+	vlo, vhi := uint32(arg.Value), uint32(arg.Value>>32)
+	switch arg.Op {
+	case "SCMP_CMP_EQ":
+		return []sockFilter{
+			bpfLoad(hi),
+			bpfJump(syscall.BPF_JEQ, vhi, 0, bpfFail),
+			bpfLoad(lo),
+			bpfJump(syscall.BPF_JEQ, vlo, 0, bpfFail),
+		}, nil
+	case "SCMP_CMP_NE":
+		return []sockFilter{
+			bpfLoad(hi),
+			bpfJump(syscall.BPF_JEQ, vhi, 0, 2),
+			bpfLoad(lo),
+			bpfJump(syscall.BPF_JEQ, vlo, bpfFail, 0),
+		}, nil
+	case "SCMP_CMP_MASKED_EQ":
+		and := syscall.BPF_ALU | syscall.BPF_AND | syscall.BPF_K
+		return []sockFilter{
+			bpfLoad(hi),
+			{Code: uint16(and), K: vhi},
+			bpfJump(syscall.BPF_JEQ, uint32(arg.ValueTwo>>32), 0, bpfFail),
+			bpfLoad(lo),
+			{Code: uint16(and), K: vlo},
+			bpfJump(syscall.BPF_JEQ, uint32(arg.ValueTwo), 0, bpfFail),
+		}, nil
+	case "SCMP_CMP_GT", "SCMP_CMP_GE":
+		op := uint16(syscall.BPF_JGT)
+		if arg.Op == "SCMP_CMP_GE" {
+			op = syscall.BPF_JGE
+		}
+		return []sockFilter{
+			bpfLoad(hi),
+			bpfJump(syscall.BPF_JGT, vhi, 3, 0),
+			bpfJump(syscall.BPF_JEQ, vhi, 0, bpfFail),
+			bpfLoad(lo),
+			bpfJump(op, vlo, 0, bpfFail),
+		}, nil
+	case "SCMP_CMP_LT", "SCMP_CMP_LE":
+		// the opposite of GE and GT
+		op := uint16(syscall.BPF_JGE)
+		if arg.Op == "SCMP_CMP_LE" {
+			op = syscall.BPF_JGT
+		}
+		return []sockFilter{
+			bpfLoad(hi),
+			bpfJump(syscall.BPF_JGT, vhi, bpfFail, 0),
+			bpfJump(syscall.BPF_JEQ, vhi, 0, 2),
+			bpfLoad(lo),
+			bpfJump(op, vlo, bpfFail, 0),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported seccomp operator %q", arg.Op)
+}
+
+// kernelAtLeast reports whether the running kernel is at least
+// the major.minor version.
+func kernelAtLeast(version string) (bool, error) {
+	want, err := parseKernelVersion(version)
+	if err != nil {
+		return false, fmt.Errorf("invalid minKernel %q", version)
+	}
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return false, err
+	}
+	var release []byte
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+		release = append(release, byte(c))
+	}
+	have, err := parseKernelVersion(string(release))
+	if err != nil {
+		return false, fmt.Errorf("unknown kernel version %q", release)
+	}
+	return have[0] > want[0] || have[0] == want[0] && have[1] >= want[1], nil
+}
+
+// parseKernelVersion parses the major and minor numbers of a kernel
+// release like 6.1.0-18-amd64.
+func parseKernelVersion(s string) ([2]int, error) {
+	var v [2]int
+	major, rest, _ := strings.Cut(s, ".")
+	minor, _, _ := strings.Cut(rest, ".")
+	minor, _, _ = strings.Cut(minor, "-")
+	var err error
+	if v[0], err = strconv.Atoi(major); err != nil {
+		return v, err
+	}
+	if v[1], err = strconv.Atoi(minor); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// seccompModeFilter is SECCOMP_MODE_FILTER from linux/seccomp.h
+const seccompModeFilter = 2
+
+// sockFprog is struct sock_fprog from linux/filter.h
+type sockFprog struct {
+	Len    uint16
+	Filter unsafe.Pointer
+}
+
+// installSeccomp applies a filter made by Compile to the calling thread,
+// which passes it on to everything it execs.
+func installSeccomp(filter []byte) error {
+	if len(filter) == 0 || len(filter)%8 != 0 {
+		return errors.New("invalid seccomp filter")
+	}
+	prog := sockFprog{Len: uint16(len(filter) / 8), Filter: unsafe.Pointer(&filter[0])}
+	_, _, errno := syscall.Syscall(syscall.SYS_PRCTL, syscall.PR_SET_SECCOMP, seccompModeFilter, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("failed to install seccomp filter: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !amd64 && !arm64
+
+package main
+
+// auditArch is zero on architectures without a syscall table, which
+// don't support seccomp.
+const auditArch = 0
+
+var syscallNumbers map[string]uint32
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+)
+
+// runSeccomp runs a compiled filter against a syscall like the kernel does.
+func runSeccomp(t *testing.T, filter []byte, nr uint32, args ...uint64) uint32 {
+	t.Helper()
+	data := make([]byte, 64)
+	binary.NativeEndian.PutUint32(data[seccompDataNr:], nr)
+	binary.NativeEndian.PutUint32(data[seccompDataArch:], auditArch)
+	for i, arg := range args {
+		binary.NativeEndian.PutUint64(data[seccompDataArgs+8*i:], arg)
+	}
+	var a uint32
+	for pc := 0; pc*8 < len(filter); pc++ {
+		code := binary.NativeEndian.Uint16(filter[pc*8:])
+		jt, jf := filter[pc*8+2], filter[pc*8+3]
+		k := binary.NativeEndian.Uint32(filter[pc*8+4:])
+		jump := func(ok bool) {
+			if ok {
+				pc += int(jt)
+			} else {
+				pc += int(jf)
+			}
+		}
+		switch code {
+		case syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS:
+			a = binary.NativeEndian.Uint32(data[k:])
+		case syscall.BPF_ALU | syscall.BPF_AND | syscall.BPF_K:
+			a &= k
+		case syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K:
+			jump(a == k)
+		case syscall.BPF_JMP | syscall.BPF_JGT | syscall.BPF_K:
+			jump(a > k)
+		case syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K:
+			jump(a >= k)
+		case syscall.BPF_RET | syscall.BPF_K:
+			return k
+		default:
+			t.Fatalf("unexpected instruction %#x", code)
+		}
+	}
+	t.Fatal("filter didn't return")
+	return 0
+}
+
+func TestSeccompCompileArgs(t *testing.T) {
+	if syscallNumbers == nil {
+		t.Skip(errSeccompUnsupported)
+	}
+	const big = 1<<32 + 10
+	tests := []struct {
+		op    string
+		value uint64
+		match []uint64
+		miss  []uint64
+	}{
+		{"SCMP_CMP_EQ", big, []uint64{big}, []uint64{10, 1 << 32, big + 1}},
+		{"SCMP_CMP_NE", big, []uint64{10, 1 << 32}, []uint64{big}},
+		{"SCMP_CMP_GT", big, []uint64{big + 1, 2 << 32}, []uint64{big, 11, 1 << 32}},
+		{"SCMP_CMP_GE", big, []uint64{big, 2 << 32}, []uint64{big - 1, 11}},
+		{"SCMP_CMP_LT", big, []uint64{big - 1, 11}, []uint64{big, 2 << 32}},
+		{"SCMP_CMP_LE", big, []uint64{big, 11}, []uint64{big + 1, 2 << 32}},
+	}
+	nr := syscallNumbers["getpid"]
+	for _, tt := range tests {
+		profile := SeccompProfile{
+			DefaultAction: "SCMP_ACT_ALLOW",
+			Syscalls: []SeccompSyscall{{
+				Names:  []string{"getpid"},
+				Action: "SCMP_ACT_ERRNO",
+				Args:   []SeccompArg{{Index: 2, Value: tt.value, Op: tt.op}},
+			}},
+		}
+		filter, err := profile.Compile(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range tt.match {
+			if ret := runSeccomp(t, filter, nr, 0, 0, v); ret != seccompRetErrno|uint32(syscall.EPERM) {
+				t.Errorf("%s %#x: expected %#x to match, got %#x", tt.op, tt.value, v, ret)
+			}
+		}
+		for _, v := range tt.miss {
+			if ret := runSeccomp(t, filter, nr, 0, 0, v); ret != seccompRetAllow {
+				t.Errorf("%s %#x: expected %#x not to match, got %#x", tt.op, tt.value, v, ret)
+			}
+		}
+	}
+}
+
+func TestDefaultSeccompProfile(t *testing.T) {
+	if syscallNumbers == nil {
+		t.Skip(errSeccompUnsupported)
+	}
+	filter, err := DefaultSeccompProfile().Compile(defaultCapabilities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eperm := seccompRetErrno | uint32(syscall.EPERM)
+	tests := []struct {
+		name string
+		args []uint64
+		want uint32
+	}{
+		{"read", nil, seccompRetAllow},
+		{"unshare", []uint64{syscall.CLONE_NEWNS}, eperm},
+		{"mount", nil, eperm},
+		{"chroot", nil, seccompRetAllow},
+		{"clone", []uint64{syscall.CLONE_VM | syscall.CLONE_THREAD}, seccompRetAllow},
+		{"clone", []uint64{syscall.CLONE_NEWNET}, eperm},
+		{"clone3", nil, seccompRetErrno | uint32(syscall.ENOSYS)},
+		{"socket", []uint64{syscall.AF_INET}, seccompRetAllow},
+		{"socket", []uint64{40}, eperm},
+		{"personality", []uint64{0xffffffff}, seccompRetAllow},
+		{"personality", []uint64{0x0040000}, eperm},
+	}
+	for _, tt := range tests {
+		if ret := runSeccomp(t, filter, syscallNumbers[tt.name], tt.args...); ret != tt.want {
+			t.Errorf("%s(%#x) = %#x, want %#x", tt.name, tt.args, ret, tt.want)
+		}
+	}
+	if ret := runSeccomp(t, filter, x32SyscallBit|syscallNumbers["read"]); ret != eperm {
+		t.Errorf("expected x32 syscalls to be denied, got %#x", ret)
+	}
+	filter, err = DefaultSeccompProfile().Compile(append(defaultCapabilities, "CAP_SYS_ADMIN"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret := runSeccomp(t, filter, syscallNumbers["mount"]); ret != seccompRetAllow {
+		t.Errorf("expected CAP_SYS_ADMIN to allow mount, got %#x", ret)
+	}
+}
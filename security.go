@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SecurityOptions are the settings made with -security-opt.
+type SecurityOptions struct {
+	// Seccomp is the path of a seccomp profile, unconfined to run without
+	// seccomp or empty for the default profile.
+	Seccomp string
+	// NoNewPrivileges is on unless it's turned off with no-new-privileges=false.
+	NoNewPrivileges bool
+	// UnconfinedPaths leaves the sensitive paths in /proc and /sys
+	// alone, which is systempaths=unconfined.
+	UnconfinedPaths bool
+}
+
+// ParseSecurityOpts parses -security-opt values like seccomp=profile.json.
+func ParseSecurityOpts(opts []string) (SecurityOptions, error) {
+	s := SecurityOptions{NoNewPrivileges: true}
+	for _, opt := range opts {
+		key, value, ok := strings.Cut(opt, "=")
+		switch {
+		case key == "seccomp" && ok && value != "":
+			s.Seccomp = value
+		case key == "no-new-privileges" && (!ok || value == "true" || value == "false"):
+			s.NoNewPrivileges = !ok || value == "true"
+		case key == "systempaths" && value == "unconfined":
+			s.UnconfinedPaths = true
+		default:
+			return s, fmt.Errorf("invalid security option %q", opt)
+		}
+	}
+	return s, nil
+}
+
+// SeccompFilter returns the compiled seccomp filter for a container with the
+// capabilities, or nil when it runs unconfined. The default profile is left
+// out on architectures which don't support seccomp, rather than failing.
+func (s SecurityOptions) SeccompFilter(caps []string) ([]byte, error) {
+	switch s.Seccomp {
+	case "unconfined":
+		return nil, nil
+	case "":
+		filter, err := DefaultSeccompProfile().Compile(caps)
+		if errors.Is(err, errSeccompUnsupported) {
+			return nil, nil
+		}
+		return filter, err
+	}
+	profile, err := ReadSeccompProfile(s.Seccomp)
+	if err != nil {
+		return nil, err
+	}
+	return profile.Compile(caps)
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestParseSecurityOpts(t *testing.T) {
+	s, err := ParseSecurityOpts(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.NoNewPrivileges || s.UnconfinedPaths || s.Seccomp != "" {
+		t.Errorf("unexpected defaults: %+v", s)
+	}
+	s, err = ParseSecurityOpts([]string{"seccomp=unconfined", "no-new-privileges=false", "systempaths=unconfined"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.NoNewPrivileges || !s.UnconfinedPaths || s.Seccomp != "unconfined" {
+		t.Errorf("unexpected options: %+v", s)
+	}
+	if s, err := ParseSecurityOpts([]string{"no-new-privileges=false", "no-new-privileges"}); err != nil || !s.NoNewPrivileges {
+		t.Errorf("expected a bare no-new-privileges to turn it on: %+v %v", s, err)
+	}
+	for _, opt := range []string{"seccomp", "seccomp=", "no-new-privileges=maybe", "systempaths=confined", "apparmor=unconfined"} {
+		if _, err := ParseSecurityOpts([]string{opt}); err == nil {
+			t.Errorf("expected %q to be rejected", opt)
+		}
+	}
+}
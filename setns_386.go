@@ -0,0 +1,4 @@
+package main
+
+// sysSetns is missing from the syscall package on 386.
+const sysSetns = 346
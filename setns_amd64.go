@@ -0,0 +1,4 @@
+package main
+
+// sysSetns is missing from the syscall package on amd64.
+const sysSetns = 308
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// lseek whence values from linux/fs.h
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// sparseEntry is a region of a file.
+type sparseEntry struct {
+	Offset, Length int64
+}
+
+// sparseData returns the regions of f which contain data, or nil when the
+// file doesn't have any holes. The kernel reports the regions with SEEK_DATA
+// and SEEK_HOLE, so filesystems which don't support them look dense.
+func sparseData(f *os.File, size int64) ([]sparseEntry, error) {
+	var data []sparseEntry
+	for offset := int64(0); offset < size; {
+		start, err := f.Seek(offset, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			break // the rest of the file is a hole
+		}
+		if err != nil {
+			return nil, err
+		}
+		end, err := f.Seek(start, seekHole)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, sparseEntry{Offset: start, Length: end - start})
+		offset = end
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if len(data) == 1 && data[0] == (sparseEntry{0, size}) {
+		return nil, nil
+	}
+	// a trailing zero length entry marks where the file ends
+	if n := len(data); n == 0 || data[n-1].Offset+data[n-1].Length < size {
+		data = append(data, sparseEntry{Offset: size})
+	}
+	return data, nil
+}
+
+// holeBlock is the size of the zero runs copySparse leaves as holes.
+const holeBlock = 4096
+
+// copySparse writes r to f, seeking past blocks of zeros instead of writing
+// them so they end up as holes. archive/tar expands sparse entries into
+// their zeros when they're read, and the api to skip them isn't exported.
+func copySparse(f *os.File, r io.Reader, size int64) error {
+	buf := make([]byte, 32*1024)
+	zero := make([]byte, holeBlock)
+	for {
+		n, err := io.ReadFull(r, buf)
+		for p := buf[:n]; len(p) > 0; {
+			block := p[:min(len(p), holeBlock)]
+			p = p[len(block):]
+			if bytes.Equal(block, zero[:len(block)]) {
+				if _, err := f.Seek(int64(len(block)), io.SeekCurrent); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := f.Write(block); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// a trailing hole is only there once the file is extended over it
+	return f.Truncate(size)
+}
+
+// writeSparse writes f as a PAX 1.0 sparse file, which only stores the
+// data regions. archive/tar can read these but refuses to write them, so the
+// extended header is written directly to w and the rest goes through tw.
+func writeSparse(w io.Writer, tw *tar.Writer, hdr *tar.Header, f *os.File, data []sparseEntry) error {
+	var sparseMap bytes.Buffer
+	fmt.Fprintf(&sparseMap, "%d\n", len(data))
+	var size int64
+	for _, d := range data {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", d.Offset, d.Length)
+		size += d.Length
+	}
+	sparseMap.Write(make([]byte, blockPadding(int64(sparseMap.Len()))))
+	records := map[string]string{
+		"GNU.sparse.major":    "1",
+		"GNU.sparse.minor":    "0",
+		"GNU.sparse.name":     hdr.Name,
+		"GNU.sparse.realsize": strconv.FormatInt(hdr.Size, 10),
+	}
+	for k, v := range hdr.PAXRecords {
+		records[k] = v
+	}
+	// the extended header has the real name, this one is what
+	// tars without sparse support extract the raw data to.
+	dir, file := path.Split(hdr.Name)
+	sparse := *hdr
+	sparse.Name = path.Join(dir, "GNUSparseFile.0", file)
+	if len(sparse.Name) > 100 {
+		sparse.Name = path.Join("GNUSparseFile.0", file)
+	}
+	sparse.Size = int64(sparseMap.Len()) + size
+	sparse.PAXRecords = nil
+	// GNU tar gets confused by a GNU format header after the pax one,
+	// so stick to plain ustar and write the file normally if it doesn't fit.
+	sparse.Format = tar.FormatUSTAR
+	sparse.ModTime = hdr.ModTime.Truncate(time.Second)
+	sparse.AccessTime = time.Time{}
+	sparse.ChangeTime = time.Time{}
+	if len(file) > 100 || sparse.Size >= 1<<33 || sparse.Uid >= 1<<21 || sparse.Gid >= 1<<21 || len(sparse.Uname) > 32 || len(sparse.Gname) > 32 {
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, f)
+		return err
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if err := writePAXHeader(w, records); err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&sparse); err != nil {
+		return err
+	}
+	if _, err := tw.Write(sparseMap.Bytes()); err != nil {
+		return err
+	}
+	for _, d := range data {
+		if _, err := io.Copy(tw, io.NewSectionReader(f, d.Offset, d.Length)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePAXHeader writes a pax extended header block for the next entry.
+func writePAXHeader(w io.Writer, records map[string]string) error {
+	var keys []string
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var body bytes.Buffer
+	for _, k := range keys {
+		body.WriteString(paxRecord(k, records[k]))
+	}
+	block := make([]byte, 512)
+	copy(block[0:100], "PaxHeader")
+	copy(block[100:108], "0000644\x00")
+	copy(block[108:116], "0000000\x00")
+	copy(block[116:124], "0000000\x00")
+	copy(block[124:136], fmt.Sprintf("%011o\x00", body.Len()))
+	copy(block[136:148], "00000000000\x00")
+	block[156] = tar.TypeXHeader
+	copy(block[257:263], "ustar\x00")
+	copy(block[263:265], "00")
+	// the checksum is computed with the checksum field set to spaces
+	copy(block[148:156], "        ")
+	var sum int64
+	for _, b := range block {
+		sum += int64(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	body.Write(make([]byte, blockPadding(int64(body.Len()))))
+	if _, err := w.Write(block); err != nil {
+		return err
+	}
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+// paxRecord formats a "<length> <key>=<value>\n" record where the
+// length includes itself.
+func paxRecord(k, v string) string {
+	record := " " + k + "=" + v + "\n"
+	n := len(record) + len(strconv.Itoa(len(record)))
+	if len(strconv.Itoa(n)) > len(strconv.Itoa(len(record))) {
+		n++
+	}
+	return strconv.Itoa(n) + record
+}
+
+// blockPadding returns the number of bytes needed to pad n to a tar block.
+func blockPadding(n int64) int64 {
+	return -n & 511
+}
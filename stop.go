@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// signals are the names accepted by ParseSignal.
+var signals = map[string]syscall.Signal{
+	"HUP":    syscall.SIGHUP,
+	"INT":    syscall.SIGINT,
+	"QUIT":   syscall.SIGQUIT,
+	"ILL":    syscall.SIGILL,
+	"TRAP":   syscall.SIGTRAP,
+	"ABRT":   syscall.SIGABRT,
+	"BUS":    syscall.SIGBUS,
+	"FPE":    syscall.SIGFPE,
+	"KILL":   syscall.SIGKILL,
+	"USR1":   syscall.SIGUSR1,
+	"SEGV":   syscall.SIGSEGV,
+	"USR2":   syscall.SIGUSR2,
+	"PIPE":   syscall.SIGPIPE,
+	"ALRM":   syscall.SIGALRM,
+	"TERM":   syscall.SIGTERM,
+	"CHLD":   syscall.SIGCHLD,
+	"CONT":   syscall.SIGCONT,
+	"STOP":   syscall.SIGSTOP,
+	"TSTP":   syscall.SIGTSTP,
+	"TTIN":   syscall.SIGTTIN,
+	"TTOU":   syscall.SIGTTOU,
+	"URG":    syscall.SIGURG,
+	"XCPU":   syscall.SIGXCPU,
+	"XFSZ":   syscall.SIGXFSZ,
+	"VTALRM": syscall.SIGVTALRM,
+	"PROF":   syscall.SIGPROF,
+	"WINCH":  syscall.SIGWINCH,
+	"IO":     syscall.SIGIO,
+	"PWR":    syscall.SIGPWR,
+	"SYS":    syscall.SIGSYS,
+}
+
+// ParseSignal parses a signal given by name, with or without the SIG
+// prefix, or by number.
+func ParseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || n > 64 {
+			return 0, fmt.Errorf("invalid signal: %s", s)
+		}
+		return syscall.Signal(n), nil
+	}
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(s), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("invalid signal: %s", s)
+	}
+	return sig, nil
+}
+
+// KillContainer sends the signal to the container's init process. Only
+// SIGKILL and the signals init has a handler for are delivered, since it's
+// the init of a pid namespace. The signal is only sent while the pid still
+// belongs to the container's process.
+func KillContainer(c Container, sig syscall.Signal) error {
+	if !c.Running() {
+		return fmt.Errorf("container %s is not running", c.ID)
+	}
+	if err := signalProcess(c.State.Pid, c.State.StartTime, sig); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("failed to signal %s: %w", c.ID, err)
+	}
+	return nil
+}
+
+// stopPollInterval is how often StopContainer checks whether the container exited.
+const stopPollInterval = 100 * time.Millisecond
+
+// StopContainer sends SIGTERM to the container and SIGKILL if it's still
+// running after the timeout. It returns once the process is gone, along
+// with the last signal sent.
+func StopContainer(c Container, timeout time.Duration) (syscall.Signal, error) {
+	if err := KillContainer(c, syscall.SIGTERM); err != nil {
+		return 0, err
+	}
+	if waitExited(c, timeout) {
+		return syscall.SIGTERM, nil
+	}
+	log.Printf("%s didn't stop after %s, killing it", c.ID[:12], timeout)
+	if err := KillContainer(c, syscall.SIGKILL); err != nil {
+		return 0, err
+	}
+	if !waitExited(c, 10*time.Second) {
+		return 0, fmt.Errorf("container %s is still running", c.ID)
+	}
+	return syscall.SIGKILL, nil
+}
+
+// waitExited waits up to timeout for the container's process to exit.
+func waitExited(c Container, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for c.Running() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(stopPollInterval)
+	}
+	return true
+}
+
+// supervisorGrace is how long the shittydocker process running a container
+// gets to record its exit before it's assumed to be gone.
+const supervisorGrace = 2 * time.Second
+
+// CleanupContainer finishes up after a container whose process has exited.
+// That's normally done by the shittydocker process which ran it. If that
+// process was killed too, the rootfs is unmounted and the exit is recorded
+// here with exitCode, since the real one can't be known anymore.
+func CleanupContainer(id string, exitCode int) error {
+	deadline := time.Now().Add(supervisorGrace)
+	for {
+		c, dir, err := FindContainer(id)
+		if err != nil {
+			return err
+		}
+		if c.State == nil || !c.State.FinishedAt.IsZero() {
+			return nil
+		}
+		if time.Now().Before(deadline) {
+			time.Sleep(stopPollInterval)
+			continue
+		}
+		mounts, err := MountPoints()
+		if err != nil {
+			return err
+		}
+		rootfs := filepath.Join(dir, "rootfs")
+		for _, target := range []string{filepath.Join(rootfs, "dev", "shm"), rootfs} {
+			if !mounts[target] {
+				continue
+			}
+			if err := Unmount(target); err != nil {
+				return fmt.Errorf("failed to unmount %s: %w", target, err)
+			}
+		}
+		c.State.FinishedAt = time.Now()
+		c.State.ExitCode = exitCode
+		return WriteContainer(dir, c)
+	}
+}
+
+// WaitContainer waits for the container to exit and returns how it ended.
+// Like CleanupContainer, the shittydocker process running it gets
+// supervisorGrace after the container's process is gone to record the exit.
+func WaitContainer(id string) (ContainerState, error) {
+	var gone time.Time
+	for {
+		c, _, err := FindContainer(id)
+		if err != nil {
+			return ContainerState{}, err
+		}
+		switch {
+		case c.State != nil && !c.State.FinishedAt.IsZero():
+			return *c.State, nil
+		case c.State == nil || c.Running():
+			gone = time.Time{}
+		case gone.IsZero():
+			gone = time.Now()
+		case time.Since(gone) > supervisorGrace:
+			return ContainerState{}, fmt.Errorf("container %s exited without recording how", c.ID)
+		}
+		time.Sleep(stopPollInterval)
+	}
+}
+
+// RemoveContainer deletes a stopped container's directory, including its
+// rootfs and logs. Running containers are refused unless force is set, in
+// which case they're killed first.
+func RemoveContainer(id string, force bool) error {
+	c, dir, err := FindContainer(id)
+	if err != nil {
+		return err
+	}
+	if c.Running() {
+		if !force {
+			return fmt.Errorf("container %s is running, stop it first or use -f", c.ID[:12])
+		}
+		if err := KillContainer(c, syscall.SIGKILL); err != nil {
+			return err
+		}
+		if !waitExited(c, 10*time.Second) {
+			return fmt.Errorf("container %s is still running", c.ID)
+		}
+		if err := CleanupContainer(c.ID, 128+int(syscall.SIGKILL)); err != nil {
+			return err
+		}
+	}
+	// the shittydocker process running it unmounts the rootfs right after
+	// recording the exit, and removing it before then would delete the
+	// files through the mounts.
+	rootfs := filepath.Join(dir, "rootfs")
+	deadline := time.Now().Add(supervisorGrace)
+	for {
+		mounts, err := MountPoints()
+		if err != nil {
+			return err
+		}
+		if !mounts[rootfs] && !mounts[filepath.Join(rootfs, "dev", "shm")] {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("container %s is still mounted", c.ID[:12])
+		}
+		time.Sleep(stopPollInterval)
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		in   string
+		want syscall.Signal
+		err  bool
+	}{
+		{in: "SIGTERM", want: syscall.SIGTERM},
+		{in: "term", want: syscall.SIGTERM},
+		{in: "KILL", want: syscall.SIGKILL},
+		{in: "sigusr1", want: syscall.SIGUSR1},
+		{in: "9", want: syscall.SIGKILL},
+		{in: "0", err: true},
+		{in: "65", err: true},
+		{in: "SIGNOPE", err: true},
+		{in: "", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSignal(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseSignal(%q): expected an error", tt.in)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSignal(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestWaitContainer(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir, err := ContainersDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, "abc123")
+	if err := os.MkdirAll(p, 0755); err != nil {
+		t.Fatal(err)
+	}
+	start, err := ProcessStartTime(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Container{ID: "abc123", State: &ContainerState{Pid: os.Getpid(), StartTime: start, StartedAt: time.Now()}}
+	if err := WriteContainer(p, c); err != nil {
+		t.Fatal(err)
+	}
+	// the exit is recorded while it's waiting
+	go func() {
+		time.Sleep(2 * stopPollInterval)
+		c.State.FinishedAt = time.Now()
+		c.State.ExitCode = 137
+		c.State.OOMKilled = true
+		WriteContainer(p, c)
+	}()
+	state, err := WaitContainer("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ExitCode != 137 || !state.OOMKilled {
+		t.Errorf("unexpected state: %+v", state)
+	}
+}
+
+func TestRemoveContainer(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir, err := ContainersDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, err := ProcessStartTime(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []Container{
+		{ID: "abc123abc123abc1", Name: "stopped", State: &ContainerState{Pid: 1, FinishedAt: time.Now()}},
+		{ID: "def456def456def4", Name: "running", State: &ContainerState{Pid: os.Getpid(), StartTime: start, StartedAt: time.Now()}},
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, c.ID, "rootfs"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteContainer(filepath.Join(dir, c.ID), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := RemoveContainer("stopped", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "abc123abc123abc1")); !os.IsNotExist(err) {
+		t.Errorf("expected the container directory to be gone: %v", err)
+	}
+	if err := RemoveContainer("running", false); err == nil {
+		t.Error("expected a running container to be refused")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "def456def456def4")); err != nil {
+		t.Errorf("the running container was removed: %v", err)
+	}
+}
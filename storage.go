@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/icholy/shittydocker/pkg/registry"
+)
+
+// SnapshotDir returns the directory a layer is extracted to. Snapshots are
+// keyed by the layer's diffID and are shared by every container using the layer.
+func SnapshotDir(diffID string) (string, error) {
+	algo, hex, ok := strings.Cut(diffID, ":")
+	if !ok || algo != "sha256" || len(hex) != 64 {
+		return "", fmt.Errorf("invalid diff id: %q", diffID)
+	}
+	root, err := DataRoot()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "snapshots", algo, hex), nil
+}
+
+// pullPlatform is the platform images are pulled for, set with -platform.
+var pullPlatform = registry.HostPlatform()
+
+// FetchImageSnapshots makes sure every layer of the image has been extracted
+// into a snapshot and returns the snapshot directories, lowest layer first.
+// Layers which already have a snapshot aren't downloaded again, and unless
+// the policy is PullAlways, images in the local index don't use the network.
+func FetchImageSnapshots(ctx context.Context, ref Reference, pull PullPolicy) ([]string, error) {
+	if pull != PullAlways {
+		dirs, err := LocalImageSnapshots(ref)
+		if err == nil {
+			return dirs, nil
+		}
+		if pull == PullNever || !errors.Is(err, errNotLocal) {
+			return nil, fmt.Errorf("%s: %w", ref, err)
+		}
+	}
+	repo := ref.Repository
+	manifest, err := registryClient.ResolveManifest(ctx, ref, pullPlatform)
+	if errors.Is(err, registry.ErrCredentialsRejected) {
+		return nil, fmt.Errorf("%w, run shittydocker login again", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := registryClient.Manifest(ctx, repo, manifest.Digest, registry.ManifestAccept)
+	if err != nil {
+		return nil, err
+	}
+	if registry.IsSchema1(manifest.MediaType) {
+		return fetchSchema1Snapshots(ctx, ref, data)
+	}
+	m, err := registry.ParseImageManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ref, err)
+	}
+	if err := storeBlob(manifest.Digest, data); err != nil {
+		return nil, err
+	}
+	configData, err := registryClient.Blob(ctx, repo, m.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	var config ImageConfig
+	if err := json.Unmarshal(configData, &config); err != nil {
+		return nil, err
+	}
+	if err := storeBlob(m.Config.Digest, configData); err != nil {
+		return nil, err
+	}
+	dirs, err := imageSnapshotDirs(m, config)
+	if err != nil {
+		return nil, err
+	}
+	// each layer is extracted into its own snapshot, so there's no ordering
+	// between them and they can all be downloaded and extracted at once.
+	errs := make([]error, len(m.Layers))
+	sem := make(chan struct{}, maxConcurrentDownloads)
+	seen := map[string]bool{}
+	var wg sync.WaitGroup
+	for i, layer := range m.Layers {
+		// images can contain the same layer more than once
+		if seen[dirs[i]] {
+			continue
+		}
+		seen[dirs[i]] = true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[i] = fetchSnapshot(ctx, repo, layer, config.RootFS.DiffIDs[i], dirs[i])
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	// only recorded once the snapshots exist, so the index never points at a partial image
+	if err := WriteImageRecord(ref, ImageRecord{Manifest: manifest.Digest, PulledAt: time.Now(), Platform: pullPlatform.String()}); err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
+
+// maxConcurrentDownloads is the number of layers pulled at the same time.
+const maxConcurrentDownloads = 3
+
+// fetchSnapshot downloads the layer into the snapshot dir unless it already exists.
+// The layer is extracted as it streams in rather than after the download finishes.
+func fetchSnapshot(ctx context.Context, repo Repository, layer Layer, diffID, dir string) error {
+	return ensureSnapshot(dir, func() error {
+		body, err := openLayer(ctx, repo, layer)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		var bar *ProgressBar
+		if pullProgress != nil {
+			bar = pullProgress.Add(layer.Digest, int64(layer.Size))
+			body = bar.Reader(body)
+		}
+		if err := createSnapshot(dir, body, diffID); err != nil {
+			if bar != nil {
+				bar.Done("Failed")
+			}
+			return fmt.Errorf("failed to extract layer %s: %w", layer.Digest, err)
+		}
+		if bar != nil {
+			bar.Done("Pull complete")
+		}
+		return nil
+	})
+}
+
+// ensureSnapshot calls create to make the snapshot dir unless it already exists.
+func ensureSnapshot(dir string, create func() error) error {
+	// the snapshot's mtime records when it was last used, for gc
+	now := time.Now()
+	if err := os.Chtimes(dir, now, now); err == nil {
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	// another process might be extracting the same layer, in which
+	// case wait for it to finish and use its snapshot.
+	lock, err := LockFile(dir+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	return create()
+}
+
+// createSnapshot extracts the layer next to dir and then renames it into place
+// so that a failed extraction never leaves a partial snapshot behind. The
+// snapshot is shared by every image which has a layer with the diff id, so
+// the layer is only renamed into place when it really has it.
+func createSnapshot(dir string, layer io.Reader, diffID string) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".extract-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+	if err := ExtractLayer(layer, tmp, diffID); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// ipcSysctls are the kernel.* sysctls which belong to the ipc namespace.
+var ipcSysctls = map[string]bool{
+	"kernel.msgmax":          true,
+	"kernel.msgmnb":          true,
+	"kernel.msgmni":          true,
+	"kernel.sem":             true,
+	"kernel.shmall":          true,
+	"kernel.shmmax":          true,
+	"kernel.shmmni":          true,
+	"kernel.shm_rmid_forced": true,
+}
+
+// SysctlNamespace returns the clone flag of the namespace the sysctl
+// belongs to. It returns false if the sysctl isn't namespaced.
+func SysctlNamespace(key string) (uintptr, bool) {
+	switch {
+	case ipcSysctls[key], strings.HasPrefix(key, "fs.mqueue."):
+		return syscall.CLONE_NEWIPC, true
+	case strings.HasPrefix(key, "net."):
+		return syscall.CLONE_NEWNET, true
+	case key == "kernel.domainname":
+		return syscall.CLONE_NEWUTS, true
+	default:
+		return 0, false
+	}
+}
+
+// ParseSysctl parses a key=value sysctl and makes sure setting it
+// can't affect the host, given the namespaces the container is created with.
+func ParseSysctl(s string, cloneflags uintptr) (string, string, error) {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("invalid sysctl %q: expected key=value", s)
+	}
+	key = strings.ReplaceAll(key, "/", ".")
+	ns, ok := SysctlNamespace(key)
+	if !ok {
+		return "", "", fmt.Errorf("sysctl %q is not namespaced", key)
+	}
+	if cloneflags&ns == 0 {
+		return "", "", fmt.Errorf("sysctl %q requires the container to have its own %s namespace", key, namespaceName(ns))
+	}
+	return key, value, nil
+}
+
+func namespaceName(flag uintptr) string {
+	switch flag {
+	case syscall.CLONE_NEWIPC:
+		return "ipc"
+	case syscall.CLONE_NEWNET:
+		return "network"
+	case syscall.CLONE_NEWUTS:
+		return "uts"
+	case syscall.CLONE_NEWPID:
+		return "pid"
+	case syscall.CLONE_NEWNS:
+		return "mount"
+	default:
+		return fmt.Sprintf("%#x", flag)
+	}
+}
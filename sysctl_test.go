@@ -0,0 +1,37 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSysctl(t *testing.T) {
+	flags := uintptr(syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC)
+	key, value, err := ParseSysctl("kernel.msgmax=65536", flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "kernel.msgmax" || value != "65536" {
+		t.Fatalf("got %s=%s", key, value)
+	}
+	key, _, err = ParseSysctl("fs/mqueue/msg_max=20", flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "fs.mqueue.msg_max" {
+		t.Fatalf("got %s", key)
+	}
+	for _, s := range []string{
+		"kernel.msgmax",
+		"vm.swappiness=10",
+		"kernel.hostname=foo",
+		"net.core.somaxconn=1024",
+	} {
+		if _, _, err := ParseSysctl(s, flags); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+	if _, _, err := ParseSysctl("net.core.somaxconn=1024", flags|syscall.CLONE_NEWNET); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ExportOptions control the metadata written to exported images.
+type ExportOptions struct {
+	// Reproducible leaves out metadata which depends on the host or on
+	// when the files were last touched, so the same tree always produces
+	// the same archive and digest. The numeric uid and gid are kept on
+	// purpose: they're part of the tree, like a service's files being owned
+	// by its user, and the image wouldn't work without them. Device numbers
+	// are only written for device nodes, which need them as well.
+	Reproducible bool
+	// ModTime clamps the modification times of the files when set,
+	// like SOURCE_DATE_EPOCH does.
+	ModTime time.Time
+	// Whiteouts writes the overlayfs whiteouts in a layer snapshot as the
+	// .wh. files layer tarballs use, rather than as device nodes.
+	Whiteouts bool
+}
+
+// WriteTar writes the directory tree at root to w as a tar stream.
+// Entries are written in lexical order. Files with several links are
+// written once and then as hardlinks to the first name, which is what
+// keeps images like busybox small.
+func WriteTar(w io.Writer, root string, opts ExportOptions) error {
+	tw := tar.NewWriter(w)
+	links := map[fileID]string{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if opts.Reproducible {
+			// the names come from the host's passwd/group files
+			hdr.Uname, hdr.Gname = "", ""
+			hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+		}
+		if !opts.ModTime.IsZero() && hdr.ModTime.After(opts.ModTime) {
+			hdr.ModTime = opts.ModTime
+		}
+		if opts.Whiteouts && isWhiteout(info) {
+			i := strings.LastIndex(hdr.Name, "/") + 1
+			return tw.WriteHeader(&tar.Header{Name: hdr.Name[:i] + whiteoutPrefix + hdr.Name[i:], Typeflag: tar.TypeReg, Mode: 0644, ModTime: hdr.ModTime})
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			xattrs, err := readXattrs(path)
+			if err != nil {
+				return err
+			}
+			for name, value := range xattrs {
+				if opts.Whiteouts && (name == "trusted.overlay.opaque" || name == "user.overlay.opaque") {
+					continue
+				}
+				if hdr.PAXRecords == nil {
+					hdr.PAXRecords = map[string]string{}
+				}
+				hdr.PAXRecords["SCHILY.xattr."+name] = value
+			}
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && info.Mode().IsRegular() && st.Nlink > 1 {
+			id := fileID{dev: uint64(st.Dev), ino: st.Ino}
+			if first, ok := links[id]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+				return tw.WriteHeader(hdr)
+			}
+			links[id] = hdr.Name
+		}
+		if opts.Whiteouts && info.IsDir() && isOpaque(path) {
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			return tw.WriteHeader(&tar.Header{Name: hdr.Name + opaqueWhiteout, Typeflag: tar.TypeReg, Mode: 0644, ModTime: hdr.ModTime})
+		}
+		if !info.Mode().IsRegular() {
+			return tw.WriteHeader(hdr)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Blocks*512 < st.Size {
+			data, err := sparseData(f, st.Size)
+			if err != nil {
+				return err
+			}
+			if data != nil {
+				return writeSparse(w, tw, hdr, f, data)
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// fileID identifies an inode.
+type fileID struct {
+	dev, ino uint64
+}
+
+// readXattrs returns the extended attributes of the file at path.
+func readXattrs(path string) (map[string]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err == syscall.ENOTSUP || size == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list xattrs of %s: %w", path, err)
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(path, buf); err != nil {
+		return nil, fmt.Errorf("failed to list xattrs of %s: %w", path, err)
+	}
+	xattrs := map[string]string{}
+	for _, name := range strings.Split(strings.TrimSuffix(string(buf[:size]), "\x00"), "\x00") {
+		size, err := syscall.Getxattr(path, name, nil)
+		if err == syscall.ENODATA {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read xattr %s of %s: %w", name, path, err)
+		}
+		value := make([]byte, size)
+		if size, err = syscall.Getxattr(path, name, value); err != nil {
+			return nil, fmt.Errorf("failed to read xattr %s of %s: %w", name, path, err)
+		}
+		xattrs[name] = string(value[:size])
+	}
+	return xattrs, nil
+}
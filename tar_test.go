@@ -0,0 +1,195 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// readTar returns the headers and file contents in the tar stream.
+func readTar(t *testing.T, r io.Reader) ([]*tar.Header, map[string]string) {
+	t.Helper()
+	var headers []*tar.Header
+	contents := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return headers, contents
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers = append(headers, hdr)
+		contents[hdr.Name] = string(data)
+	}
+}
+
+func TestWriteTar(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "etc", "hostname"), []byte("box\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("etc/hostname", filepath.Join(root, "hostname")); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteTar(&buf, root, ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	headers, contents := readTar(t, &buf)
+	var names []string
+	for _, hdr := range headers {
+		names = append(names, hdr.Name)
+		if hdr.Name == "hostname" && (hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "etc/hostname") {
+			t.Errorf("bad symlink header: %+v", hdr)
+		}
+	}
+	if got := len(names); got != 3 || names[0] != "etc/" || names[1] != "etc/hostname" || names[2] != "hostname" {
+		t.Fatalf("unexpected entries: %v", names)
+	}
+	if contents["etc/hostname"] != "box\n" {
+		t.Errorf("unexpected contents: %q", contents["etc/hostname"])
+	}
+}
+
+func TestWriteTarHardlinks(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "busybox"), []byte("applets"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ls", "sh"} {
+		if err := os.Link(filepath.Join(root, "busybox"), filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := WriteTar(&buf, root, ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	headers, contents := readTar(t, &buf)
+	if len(headers) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(headers))
+	}
+	// the first name in walk order gets the data and the rest link to it
+	if hdr := headers[0]; hdr.Name != "busybox" || hdr.Typeflag != tar.TypeReg || contents["busybox"] != "applets" {
+		t.Errorf("bad file header: %+v", hdr)
+	}
+	for _, hdr := range headers[1:] {
+		if hdr.Typeflag != tar.TypeLink || hdr.Linkname != "busybox" || hdr.Size != 0 {
+			t.Errorf("bad hardlink header: %+v", hdr)
+		}
+	}
+}
+
+func TestWriteTarXattrs(t *testing.T) {
+	root := t.TempDir()
+	name := filepath.Join(root, "ping")
+	if err := os.WriteFile(name, []byte("ping"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(name, "user.test", []byte("value"), 0); err != nil {
+		t.Skipf("xattrs not supported: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteTar(&buf, root, ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	headers, _ := readTar(t, &buf)
+	if len(headers) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(headers))
+	}
+	if got := headers[0].PAXRecords["SCHILY.xattr.user.test"]; got != "value" {
+		t.Errorf("expected xattr to be preserved, got %q", got)
+	}
+}
+
+func TestWriteTarSparse(t *testing.T) {
+	root := t.TempDir()
+	name := filepath.Join(root, "disk.img")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 64 << 20
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("head"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("middle"), 32<<20); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteTar(&buf, root, ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() > 1<<20 {
+		t.Errorf("expected the holes to be left out, archive is %d bytes", buf.Len())
+	}
+	headers, contents := readTar(t, &buf)
+	if len(headers) != 1 || headers[0].Name != "disk.img" || headers[0].Size != size {
+		t.Fatalf("unexpected entries: %+v", headers)
+	}
+	expected, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents["disk.img"] != string(expected) {
+		t.Error("sparse file contents don't match")
+	}
+}
+
+func TestWriteTarReproducible(t *testing.T) {
+	epoch := time.Unix(1700000000, 0)
+	archive := func() []byte {
+		root := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, "etc", "hostname"), []byte("box\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if os.Getuid() == 0 {
+			if err := os.Lchown(filepath.Join(root, "etc", "hostname"), 1000, 1000); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var buf bytes.Buffer
+		if err := WriteTar(&buf, root, ExportOptions{Reproducible: true, ModTime: epoch}); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	a := archive()
+	time.Sleep(10 * time.Millisecond)
+	b := archive()
+	if !bytes.Equal(a, b) {
+		t.Fatal("archives of the same tree are different")
+	}
+	headers, _ := readTar(t, bytes.NewReader(a))
+	for _, hdr := range headers {
+		if !hdr.ModTime.Equal(epoch) || hdr.Uname != "" || hdr.Gname != "" {
+			t.Errorf("metadata wasn't normalized: %+v", hdr)
+		}
+		if os.Getuid() == 0 && hdr.Name == "etc/hostname" && (hdr.Uid != 1000 || hdr.Gid != 1000) {
+			t.Errorf("ownership wasn't kept: %d:%d", hdr.Uid, hdr.Gid)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// TmpfsMount is a writable scratch directory in the container which is
+// thrown away along with it.
+type TmpfsMount struct {
+	Destination string   `json:"destination"`
+	Options     []string `json:"options"`
+}
+
+// tmpfsMountFlags are the options which are mount flags rather than tmpfs
+// options, and whether they set or clear the flag.
+var tmpfsMountFlags = map[string]struct {
+	flag uintptr
+	set  bool
+}{
+	"ro":     {syscall.MS_RDONLY, true},
+	"rw":     {syscall.MS_RDONLY, false},
+	"noexec": {syscall.MS_NOEXEC, true},
+	"exec":   {syscall.MS_NOEXEC, false},
+	"nosuid": {syscall.MS_NOSUID, true},
+	"suid":   {syscall.MS_NOSUID, false},
+	"nodev":  {syscall.MS_NODEV, true},
+	"dev":    {syscall.MS_NODEV, false},
+}
+
+// ParseTmpfs parses a -tmpfs value like /tmp or /run:size=64m,mode=755.
+// Like in docker, the mount is noexec, nosuid and nodev unless the
+// options say otherwise.
+func ParseTmpfs(s string) (TmpfsMount, error) {
+	dest, opts, _ := strings.Cut(s, ":")
+	if !filepath.IsAbs(dest) {
+		return TmpfsMount{}, fmt.Errorf("invalid tmpfs %q: the path must be absolute", s)
+	}
+	t := TmpfsMount{
+		Destination: filepath.Clean(dest),
+		Options:     []string{"noexec", "nosuid", "nodev"},
+	}
+	if opts == "" {
+		return t, nil
+	}
+	for _, opt := range strings.Split(opts, ",") {
+		if _, ok := tmpfsMountFlags[opt]; ok {
+			t.Options = append(t.Options, opt)
+			continue
+		}
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "size":
+			size, err := ParseBytes(value)
+			if err != nil {
+				return TmpfsMount{}, fmt.Errorf("invalid tmpfs %q: %w", s, err)
+			}
+			opt = "size=" + strconv.FormatInt(size, 10)
+		case "mode":
+			if _, err := strconv.ParseUint(value, 8, 32); err != nil {
+				return TmpfsMount{}, fmt.Errorf("invalid tmpfs %q: mode must be octal", s)
+			}
+		case "uid", "gid", "nr_inodes":
+			if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+				return TmpfsMount{}, fmt.Errorf("invalid tmpfs %q: %s must be a number", s, key)
+			}
+		default:
+			return TmpfsMount{}, fmt.Errorf("invalid tmpfs %q: unknown option %q", s, opt)
+		}
+		t.Options = append(t.Options, opt)
+	}
+	return t, nil
+}
+
+// mountOptions splits the options into mount flags and the tmpfs options,
+// with later options overriding earlier ones.
+func (t TmpfsMount) mountOptions() (uintptr, string) {
+	var flags uintptr
+	var data []string
+	for _, opt := range t.Options {
+		if f, ok := tmpfsMountFlags[opt]; ok {
+			if f.set {
+				flags |= f.flag
+			} else {
+				flags &^= f.flag
+			}
+			continue
+		}
+		data = append(data, opt)
+	}
+	return flags, strings.Join(data, ",")
+}
+
+// mountTmpfs mounts t in the rootfs, creating the mount point if it's
+// missing. Symlinks are resolved inside of the rootfs like for bind mounts.
+func mountTmpfs(rootfs string, t TmpfsMount) error {
+	target, err := resolveMountPoint(rootfs, t.Destination)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	flags, data := t.mountOptions()
+	if err := syscall.Mount("tmpfs", target, "tmpfs", flags, data); err != nil {
+		return fmt.Errorf("failed to mount tmpfs on %s: %w", t.Destination, err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestParseTmpfs(t *testing.T) {
+	tests := []struct {
+		value string
+		want  TmpfsMount
+	}{
+		{"/tmp", TmpfsMount{Destination: "/tmp", Options: []string{"noexec", "nosuid", "nodev"}}},
+		{"/run/:size=64m,mode=755", TmpfsMount{Destination: "/run", Options: []string{"noexec", "nosuid", "nodev", "size=67108864", "mode=755"}}},
+		{"/cache:exec,uid=1000", TmpfsMount{Destination: "/cache", Options: []string{"noexec", "nosuid", "nodev", "exec", "uid=1000"}}},
+	}
+	for _, tt := range tests {
+		got, err := ParseTmpfs(tt.value)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.value, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+	for _, value := range []string{
+		"tmp",
+		"/tmp:size=lots",
+		"/tmp:mode=999",
+		"/tmp:uid=root",
+		"/tmp:bogus",
+	} {
+		if _, err := ParseTmpfs(value); err == nil {
+			t.Errorf("%q: expected error", value)
+		}
+	}
+}
+
+func TestTmpfsMountOptions(t *testing.T) {
+	m, err := ParseTmpfs("/tmp:exec,ro,size=1k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags, data := m.mountOptions()
+	if want := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_RDONLY); flags != want {
+		t.Errorf("got flags %#x, want %#x", flags, want)
+	}
+	if want := "size=1024"; data != want {
+		t.Errorf("got data %q, want %q", data, want)
+	}
+}
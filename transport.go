@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// httpClient is used for every request to a registry or its auth server.
+var httpClient = &http.Client{
+	Transport: &retryTransport{next: registryTransport, Attempts: 5, Backoff: 500 * time.Millisecond},
+}
+
+var registryTransport = &hostTransport{transports: map[string]*http.Transport{}}
+
+// AddRegistryCA trusts the certificates in the PEM file for all registries.
+func AddRegistryCA(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates found in %s", file)
+	}
+	registryTransport.mu.Lock()
+	defer registryTransport.mu.Unlock()
+	registryTransport.cas = append(registryTransport.cas, data)
+	clear(registryTransport.transports)
+	return nil
+}
+
+// AddInsecureRegistry lets the registry, a host with an optional port, be
+// used over https without verifying its certificate or over plain http,
+// like docker's --insecure-registry.
+func AddInsecureRegistry(host string) {
+	registryTransport.addInsecure(host)
+}
+
+// certsDirs are searched for per registry CA certificates in <dir>/<host>/*.crt,
+// the same layout docker uses.
+func certsDirs() []string {
+	dirs := []string{"/etc/docker/certs.d"}
+	if root, err := DataRoot(); err == nil {
+		dirs = append([]string{filepath.Join(root, "certs.d")}, dirs...)
+	}
+	return dirs
+}
+
+// hostTransport uses a separate transport for each host so that
+// each registry only trusts its own extra CAs.
+type hostTransport struct {
+	mu         sync.Mutex
+	cas        [][]byte
+	insecure   map[string]bool
+	transports map[string]*http.Transport
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tr, err := t.transport(req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil && req.URL.Scheme == "https" && (req.Body == nil || req.Body == http.NoBody) && t.isInsecure(req.URL.Host) {
+		// like docker, insecure registries which don't do tls at all
+		// are tried again over plain http
+		plain := req.Clone(req.Context())
+		plain.URL.Scheme = "http"
+		return tr.RoundTrip(plain)
+	}
+	return resp, err
+}
+
+func (t *hostTransport) addInsecure(host string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.insecure == nil {
+		t.insecure = map[string]bool{}
+	}
+	t.insecure[host] = true
+	delete(t.transports, host)
+}
+
+// isInsecure reports whether the host was added with addInsecure, with or
+// without its port. Registries on the loopback address always are, the
+// same as in docker.
+func (t *hostTransport) isInsecure(host string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.insecureLocked(host)
+}
+
+func (t *hostTransport) insecureLocked(host string) bool {
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	if name == "localhost" {
+		return true
+	}
+	if ip, err := netip.ParseAddr(name); err == nil && ip.IsLoopback() {
+		return true
+	}
+	return t.insecure[host] || t.insecure[name]
+}
+
+func (t *hostTransport) transport(host string) (*http.Transport, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tr, ok := t.transports[host]; ok {
+		return tr, nil
+	}
+	cas := t.cas
+	for _, dir := range certsDirs() {
+		files, _ := filepath.Glob(filepath.Join(dir, host, "*.crt"))
+		for _, f := range files {
+			data, err := os.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			cas = append(cas, data)
+		}
+	}
+	tr := newTransport()
+	if len(cas) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, data := range cas {
+			pool.AppendCertsFromPEM(data)
+		}
+		tr.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	if t.insecureLocked(host) {
+		if tr.TLSClientConfig == nil {
+			tr.TLSClientConfig = &tls.Config{}
+		}
+		tr.TLSClientConfig.InsecureSkipVerify = true
+	}
+	t.transports[host] = tr
+	return tr, nil
+}
+
+// newTransport returns a transport tuned for pulls, which make dozens of
+// requests to the same couple of hosts. The defaults already do keep-alives
+// and HTTP/2, but only keep two idle connections per host, which throws
+// connections away as soon as layers are downloaded in parallel.
+func newTransport() *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.MaxIdleConnsPerHost = 16
+	tr.IdleConnTimeout = 5 * time.Minute
+	// a whole layer download can take a lot longer than this,
+	// but the registry should answer quickly.
+	tr.ResponseHeaderTimeout = time.Minute
+	return tr
+}
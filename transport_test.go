@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHostTransportInsecure(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	selfSigned := httptest.NewUnstartedServer(handler)
+	// the failed handshake of the first attempt gets logged otherwise
+	selfSigned.Config.ErrorLog = log.New(io.Discard, "", 0)
+	selfSigned.StartTLS()
+	defer selfSigned.Close()
+	for _, srv := range []*httptest.Server{plain, selfSigned} {
+		u, _ := url.Parse(srv.URL)
+		// the test servers listen on the loopback address, which is always
+		// insecure, so they're reached under another name
+		host := strings.Replace(u.Host, "127.0.0.1", "registry.test", 1)
+		tr := &hostTransport{transports: map[string]*http.Transport{}}
+		client := &http.Client{Transport: tr}
+		get := func() error {
+			if _, err := tr.transport(host); err != nil {
+				t.Fatal(err)
+			}
+			tr.transports[host].DialContext = dialTo(u.Host)
+			resp, err := client.Get("https://" + host + "/v2/")
+			if err == nil {
+				resp.Body.Close()
+			}
+			return err
+		}
+		if err := get(); err == nil {
+			t.Errorf("%s: expected a secure registry to fail", srv.URL)
+		}
+		tr.addInsecure(host)
+		if err := get(); err != nil {
+			t.Errorf("%s: %v", srv.URL, err)
+		}
+	}
+}
+
+func TestHostTransportLoopback(t *testing.T) {
+	tr := &hostTransport{}
+	for _, host := range []string{"localhost:5000", "127.0.0.1", "[::1]:5000"} {
+		if !tr.isInsecure(host) {
+			t.Errorf("%s: expected loopback registries to be insecure", host)
+		}
+	}
+	tr.addInsecure("registry.lan")
+	if !tr.isInsecure("registry.lan:5000") || tr.isInsecure("docker.io") {
+		t.Error("unexpected insecure registries")
+	}
+}
+
+// dialTo returns a DialContext which connects to addr whatever the host is.
+func dialTo(addr string) func(ctx context.Context, network, _ string) (net.Conn, error) {
+	var d net.Dialer
+	return func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return d.DialContext(ctx, network, addr)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+// ioctl calls the ioctl syscall with a pointer argument.
+func ioctl(fd uintptr, req uint, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(req), uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// OpenPty allocates a pseudo terminal and returns both of its ends.
+func OpenPty() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var unlock int32
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to unlock pty: %w", err)
+	}
+	var n uint32
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to get pty number: %w", err)
+	}
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+// Winsize is struct winsize from sys/ioctl.h
+type Winsize struct {
+	Rows, Cols, X, Y uint16
+}
+
+// GetWinsize returns the size of the terminal.
+func GetWinsize(f *os.File) (Winsize, error) {
+	var ws Winsize
+	err := ioctl(f.Fd(), syscall.TIOCGWINSZ, unsafe.Pointer(&ws))
+	return ws, err
+}
+
+// SetWinsize resizes the terminal, which sends SIGWINCH to its foreground processes.
+func SetWinsize(f *os.File, ws Winsize) error {
+	return ioctl(f.Fd(), syscall.TIOCSWINSZ, unsafe.Pointer(&ws))
+}
+
+// MakeRaw puts the terminal into raw mode, like cfmakeraw(3), and returns
+// the previous settings so they can be restored.
+func MakeRaw(f *os.File) (*syscall.Termios, error) {
+	var old syscall.Termios
+	if err := ioctl(f.Fd(), syscall.TCGETS, unsafe.Pointer(&old)); err != nil {
+		return nil, err
+	}
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(f.Fd(), syscall.TCSETS, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
+
+// RestoreTerminal sets the terminal's settings back to the ones returned by MakeRaw.
+func RestoreTerminal(f *os.File, state *syscall.Termios) error {
+	return ioctl(f.Fd(), syscall.TCSETS, unsafe.Pointer(state))
+}
+
+// Terminal relays between a container's pty and the local terminal.
+type Terminal struct {
+	master, slave *os.File
+	interactive   bool
+	state         *syscall.Termios
+	sigs          chan os.Signal
+	done          chan struct{}
+}
+
+// AttachTerminal makes a new pty the controlling terminal and stdio of cmd.
+// Stdin is only forwarded when interactive is set. Start has to be called
+// once the command is running, and Close after it exits.
+func AttachTerminal(cmd *exec.Cmd, interactive bool) (*Terminal, error) {
+	master, slave, err := attachPty(cmd)
+	if err != nil {
+		return nil, err
+	}
+	t := &Terminal{master: master, slave: slave, interactive: interactive, done: make(chan struct{})}
+	t.resize()
+	return t, nil
+}
+
+// attachPty allocates a pty and makes it the controlling terminal and stdio of cmd.
+func attachPty(cmd *exec.Cmd) (master, slave *os.File, err error) {
+	master, slave, err = OpenPty()
+	if err != nil {
+		return nil, nil, err
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+	return master, slave, nil
+}
+
+// Start puts the local terminal in raw mode and starts relaying.
+func (t *Terminal) Start() {
+	if t.interactive && isTerminal(os.Stdin) {
+		if state, err := MakeRaw(os.Stdin); err == nil {
+			t.state = state
+		}
+	}
+	if t.interactive {
+		go io.Copy(t.master, os.Stdin)
+	}
+	go func() {
+		io.Copy(os.Stdout, t.master)
+		close(t.done)
+	}()
+	t.sigs = make(chan os.Signal, 1)
+	signal.Notify(t.sigs, syscall.SIGWINCH)
+	go func() {
+		for range t.sigs {
+			t.resize()
+		}
+	}()
+}
+
+// resize copies the size of the local terminal to the pty.
+func (t *Terminal) resize() {
+	if ws, err := GetWinsize(os.Stdin); err == nil {
+		SetWinsize(t.master, ws)
+	}
+}
+
+// Close waits for the remaining output and restores the local terminal.
+func (t *Terminal) Close() {
+	// the pty is hung up once nothing has the slave open anymore,
+	// which ends the copy after the buffered output.
+	t.slave.Close()
+	if t.sigs != nil {
+		signal.Stop(t.sigs)
+		close(t.sigs)
+		<-t.done
+	}
+	t.master.Close()
+	if t.state != nil {
+		RestoreTerminal(os.Stdin, t.state)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+func TestOpenPty(t *testing.T) {
+	master, slave, err := OpenPty()
+	if err != nil {
+		t.Skipf("no ptys: %v", err)
+	}
+	defer master.Close()
+	defer slave.Close()
+	want := Winsize{Rows: 24, Cols: 80}
+	if err := SetWinsize(master, want); err != nil {
+		t.Fatal(err)
+	}
+	if ws, err := GetWinsize(slave); err != nil || ws != want {
+		t.Errorf("got %v, %v, want %v", ws, err, want)
+	}
+	if !isTerminal(slave) {
+		t.Error("expected the slave to be a terminal")
+	}
+	if _, err := MakeRaw(slave); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(slave, "hello\n"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(master, buf); err != nil {
+		t.Fatal(err)
+	}
+	// raw mode doesn't turn the newline into \r\n
+	if string(buf) != "hello\n" {
+		t.Errorf("unexpected output: %q", buf)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// zoneinfoDir is where the host keeps its timezone database.
+const zoneinfoDir = "/usr/share/zoneinfo"
+
+// TimezoneFile returns the host's zoneinfo file and the zone's name for -tz,
+// which is either "host" for the host's own /etc/localtime or a name like
+// Europe/Berlin. The host's zone name is only known when /etc/localtime
+// is a symlink into the zoneinfo directory.
+func TimezoneFile(tz string) (string, string, error) {
+	if tz == "host" {
+		var name string
+		if target, err := filepath.EvalSymlinks("/etc/localtime"); err == nil {
+			if rel, ok := strings.CutPrefix(target, zoneinfoDir+"/"); ok {
+				name = rel
+			}
+		}
+		return "/etc/localtime", name, nil
+	}
+	if !filepath.IsLocal(tz) {
+		return "", "", fmt.Errorf("invalid timezone: %q", tz)
+	}
+	p := filepath.Join(zoneinfoDir, tz)
+	if info, err := os.Stat(p); err != nil || !info.Mode().IsRegular() {
+		return "", "", fmt.Errorf("unknown timezone: %q", tz)
+	}
+	return p, tz, nil
+}
+
+// InstallTimezone copies the zoneinfo file to /etc/localtime in the rootfs,
+// and records the name in /etc/timezone for the distros which read it.
+func InstallTimezone(rootfs, src, name string) error {
+	etc, err := rootfsEtc(rootfs)
+	if err != nil {
+		return err
+	}
+	zone, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer zone.Close()
+	if err := writeNoFollow(filepath.Join(etc, "localtime"), zone); err != nil {
+		return err
+	}
+	if name == "" {
+		return nil
+	}
+	return writeNoFollow(filepath.Join(etc, "timezone"), strings.NewReader(name+"\n"))
+}
+
+// rootfsEtc returns the rootfs's /etc directory, creating it if needed.
+// Files written there from the host must not follow an /etc symlink
+// which could point them somewhere outside the container.
+func rootfsEtc(rootfs string) (string, error) {
+	etc := filepath.Join(rootfs, "etc")
+	if err := os.Mkdir(etc, 0755); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	if info, err := os.Lstat(etc); err != nil {
+		return "", err
+	} else if !info.IsDir() {
+		return "", fmt.Errorf("/etc in the image is not a directory")
+	}
+	return etc, nil
+}
+
+// writeNoFollow replaces the file at path without following a symlink there.
+func writeNoFollow(path string, r io.Reader) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	return f.Close()
+}
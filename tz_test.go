@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstallTimezone(t *testing.T) {
+	rootfs := t.TempDir()
+	src := filepath.Join(t.TempDir(), "Berlin")
+	if err := os.WriteFile(src, []byte("TZif"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// images usually ship /etc/localtime as a symlink into their own zoneinfo
+	if err := os.MkdirAll(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/usr/share/zoneinfo/UTC", filepath.Join(rootfs, "etc", "localtime")); err != nil {
+		t.Fatal(err)
+	}
+	if err := InstallTimezone(rootfs, src, "Europe/Berlin"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(rootfs, "etc", "localtime"))
+	if err != nil || string(data) != "TZif" {
+		t.Errorf("unexpected localtime: %q, %v", data, err)
+	}
+	data, err = os.ReadFile(filepath.Join(rootfs, "etc", "timezone"))
+	if err != nil || string(data) != "Europe/Berlin\n" {
+		t.Errorf("unexpected timezone: %q, %v", data, err)
+	}
+}
+
+func TestInstallTimezoneEtcSymlink(t *testing.T) {
+	rootfs := t.TempDir()
+	if err := os.Symlink(t.TempDir(), filepath.Join(rootfs, "etc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := InstallTimezone(rootfs, "/dev/null", ""); err == nil {
+		t.Fatal("expected an /etc symlink to be rejected")
+	}
+}
+
+func TestTimezoneFile(t *testing.T) {
+	for _, tz := range []string{"../../etc/shadow", "/etc/shadow"} {
+		if _, _, err := TimezoneFile(tz); err == nil {
+			t.Errorf("expected %q to be rejected", tz)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseBytes parses a human readable size like 64m, 512k or 1.5GB.
+// Units are binary, so 1k is 1024 bytes.
+func ParseBytes(s string) (int64, error) {
+	num := strings.ToLower(strings.TrimSpace(s))
+	num = strings.TrimSuffix(num, "ib")
+	num = strings.TrimSuffix(num, "b")
+	var mult float64 = 1
+	if n := len(num); n > 0 {
+		switch num[n-1] {
+		case 'k':
+			mult = 1 << 10
+		case 'm':
+			mult = 1 << 20
+		case 'g':
+			mult = 1 << 30
+		case 't':
+			mult = 1 << 40
+		}
+		if mult != 1 {
+			num = num[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return int64(f * mult), nil
+}
+
+// HumanSize formats a size using decimal units, like 12.5MB.
+func HumanSize(n int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	f := float64(n)
+	i := 0
+	for f >= 1000 && i < len(units)-1 {
+		f /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.4g%s", f, units[i])
+}
+
+// HumanDuration formats a duration the way docker does, like 5 minutes
+// or About an hour.
+func HumanDuration(d time.Duration) string {
+	switch seconds := int(d.Seconds()); {
+	case seconds < 1:
+		return "Less than a second"
+	case seconds == 1:
+		return "1 second"
+	case seconds < 60:
+		return fmt.Sprintf("%d seconds", seconds)
+	}
+	switch minutes := int(d.Minutes()); {
+	case minutes == 1:
+		return "About a minute"
+	case minutes < 60:
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+	switch hours := int(d.Hours() + 0.5); {
+	case hours == 1:
+		return "About an hour"
+	case hours < 48:
+		return fmt.Sprintf("%d hours", hours)
+	case hours < 24*7*2:
+		return fmt.Sprintf("%d days", hours/24)
+	case hours < 24*30*2:
+		return fmt.Sprintf("%d weeks", hours/24/7)
+	case hours < 24*365*2:
+		return fmt.Sprintf("%d months", hours/24/30)
+	default:
+		return fmt.Sprintf("%d years", hours/24/365)
+	}
+}
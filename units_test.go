@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"100", 100},
+		{"100b", 100},
+		{"512k", 512 << 10},
+		{"64m", 64 << 20},
+		{"64M", 64 << 20},
+		{"1.5GB", 3 << 29},
+		{"2GiB", 2 << 30},
+		{"1t", 1 << 40},
+	}
+	for _, tt := range tests {
+		got, err := ParseBytes(tt.in)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.in, got, tt.want)
+		}
+	}
+	for _, s := range []string{"", "m", "-1", "ten"} {
+		if _, err := ParseBytes(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	tests := []struct {
+		in   int64
+		want string
+	}{
+		{0, "0B"},
+		{999, "999B"},
+		{1000, "1kB"},
+		{12_500_000, "12.5MB"},
+		{3 << 30, "3.221GB"},
+	}
+	for _, tt := range tests {
+		if got := HumanSize(tt.in); got != tt.want {
+			t.Errorf("%d: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHumanDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{500 * time.Millisecond, "Less than a second"},
+		{time.Second, "1 second"},
+		{45 * time.Second, "45 seconds"},
+		{90 * time.Second, "About a minute"},
+		{10 * time.Minute, "10 minutes"},
+		{time.Hour, "About an hour"},
+		{5 * time.Hour, "5 hours"},
+		{72 * time.Hour, "3 days"},
+		{21 * 24 * time.Hour, "3 weeks"},
+		{90 * 24 * time.Hour, "3 months"},
+		{3 * 365 * 24 * time.Hour, "3 years"},
+	}
+	for _, tt := range tests {
+		if got := HumanDuration(tt.d); got != tt.want {
+			t.Errorf("HumanDuration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
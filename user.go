@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// User is a container user resolved against the image's /etc/passwd and /etc/group.
+type User struct {
+	UID    uint32
+	GID    uint32
+	Groups []uint32
+	Home   string
+}
+
+// ResolveUser resolves a docker style user spec (name, uid, name:group, uid:gid)
+// using the passwd and group files in rootfs. Supplementary groups are only
+// looked up when the group isn't given explicitly.
+func ResolveUser(rootfs, spec string) (User, error) {
+	userArg, groupArg, _ := strings.Cut(spec, ":")
+	if userArg == "" {
+		userArg = "0"
+	}
+	passwd, err := readColonFile(filepath.Join(rootfs, "etc", "passwd"))
+	if err != nil {
+		return User{}, err
+	}
+	groups, err := readColonFile(filepath.Join(rootfs, "etc", "group"))
+	if err != nil {
+		return User{}, err
+	}
+	u := User{Home: "/"}
+	var name string
+	uid, numErr := parseID(userArg)
+	if numErr == nil {
+		u.UID = uid
+	}
+	for _, entry := range passwd {
+		if len(entry) < 6 {
+			continue
+		}
+		if entry[0] != userArg && (numErr != nil || entry[2] != userArg) {
+			continue
+		}
+		uid, err := parseID(entry[2])
+		if err != nil {
+			return User{}, fmt.Errorf("invalid passwd entry for %s: %w", entry[0], err)
+		}
+		gid, err := parseID(entry[3])
+		if err != nil {
+			return User{}, fmt.Errorf("invalid passwd entry for %s: %w", entry[0], err)
+		}
+		name = entry[0]
+		u.UID, u.GID, u.Home = uid, gid, entry[5]
+		break
+	}
+	if name == "" && numErr != nil {
+		return User{}, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userArg)
+	}
+	if groupArg != "" {
+		gid, err := parseID(groupArg)
+		if err == nil {
+			u.GID = gid
+			return u, nil
+		}
+		for _, entry := range groups {
+			if len(entry) >= 3 && entry[0] == groupArg {
+				gid, err := parseID(entry[2])
+				if err != nil {
+					return User{}, fmt.Errorf("invalid group entry for %s: %w", entry[0], err)
+				}
+				u.GID = gid
+				return u, nil
+			}
+		}
+		return User{}, fmt.Errorf("unable to find group %s: no matching entries in group file", groupArg)
+	}
+	if name == "" {
+		return u, nil
+	}
+	for _, entry := range groups {
+		if len(entry) < 4 {
+			continue
+		}
+		for _, member := range strings.Split(entry[3], ",") {
+			if member != name {
+				continue
+			}
+			gid, err := parseID(entry[2])
+			if err != nil {
+				return User{}, fmt.Errorf("invalid group entry for %s: %w", entry[0], err)
+			}
+			u.Groups = append(u.Groups, gid)
+		}
+	}
+	return u, nil
+}
+
+// readColonFile reads a colon separated file like /etc/passwd.
+// A missing file is treated as empty.
+func readColonFile(name string) ([][]string, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, strings.Split(line, ":"))
+	}
+	return entries, scanner.Err()
+}
+
+func parseID(s string) (uint32, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	return uint32(id), err
+}
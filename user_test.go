@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveUser(t *testing.T) {
+	rootfs := t.TempDir()
+	if err := os.Mkdir(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	passwd := "root:x:0:0:root:/root:/bin/sh\nnginx:x:101:101:nginx:/var/cache/nginx:/sbin/nologin\n"
+	group := "root:x:0:\nnginx:x:101:\nwww:x:33:nginx\nadm:x:4:root,nginx\n"
+	if err := os.WriteFile(filepath.Join(rootfs, "etc", "passwd"), []byte(passwd), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rootfs, "etc", "group"), []byte(group), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		spec string
+		want User
+	}{
+		{"", User{UID: 0, GID: 0, Groups: []uint32{4}, Home: "/root"}},
+		{"nginx", User{UID: 101, GID: 101, Groups: []uint32{33, 4}, Home: "/var/cache/nginx"}},
+		{"101", User{UID: 101, GID: 101, Groups: []uint32{33, 4}, Home: "/var/cache/nginx"}},
+		{"nginx:www", User{UID: 101, GID: 33, Home: "/var/cache/nginx"}},
+		{"nginx:42", User{UID: 101, GID: 42, Home: "/var/cache/nginx"}},
+		{"1000:1000", User{UID: 1000, GID: 1000, Home: "/"}},
+	}
+	for _, tt := range tests {
+		got, err := ResolveUser(rootfs, tt.spec)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.spec, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+	for _, spec := range []string{"nobody", "nginx:nogroup"} {
+		if _, err := ResolveUser(rootfs, spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
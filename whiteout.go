@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Layers delete files from the ones below them with whiteouts: an empty
+// .wh.<name> file removes <name>, and .wh..wh..opq hides everything that
+// was in its directory.
+const (
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+// overlayOpaqueXattr returns the xattr which marks a directory as opaque for
+// overlayfs. Setting trusted xattrs needs root on the host, so rootless
+// overlays are mounted with userxattr and use the user namespace instead.
+func overlayOpaqueXattr() string {
+	if Rootless() {
+		return "user.overlay.opaque"
+	}
+	return "trusted.overlay.opaque"
+}
+
+// createWhiteout turns the whiteout of path into the form overlayfs uses,
+// a 0/0 character device with the same name. It reports false if that
+// isn't permitted, in which case the .wh. file should be kept as-is since
+// fuse-overlayfs understands those as well.
+func createWhiteout(path string) (bool, error) {
+	if err := os.RemoveAll(path); err != nil {
+		return false, err
+	}
+	if err := syscall.Mknod(path, syscall.S_IFCHR, 0); err != nil {
+		if err == syscall.EPERM {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// markOpaque is createWhiteout for opaque directories, which overlayfs
+// marks with an xattr.
+func markOpaque(dir string) (bool, error) {
+	if err := syscall.Setxattr(dir, overlayOpaqueXattr(), []byte("y"), 0); err != nil {
+		if err == syscall.EPERM || err == syscall.ENOTSUP {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// isWhiteout reports whether the file is an overlayfs whiteout.
+func isWhiteout(info fs.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && info.Mode()&fs.ModeCharDevice != 0 && st.Rdev == 0
+}
+
+// isOpaque reports whether the directory is marked opaque.
+func isOpaque(dir string) bool {
+	var value [1]byte
+	n, err := syscall.Getxattr(dir, overlayOpaqueXattr(), value[:])
+	return err == nil && n == 1 && value[0] == 'y'
+}
+
+// applyLayer copies a layer snapshot on top of dir, deleting whatever its
+// whiteouts remove from the layers which were copied before it.
+func applyLayer(layer, dir string) error {
+	var whiteouts, opaque, markers []string
+	err := filepath.WalkDir(layer, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(layer, path)
+		name := d.Name()
+		switch {
+		case name == opaqueWhiteout:
+			opaque = append(opaque, filepath.Dir(rel))
+			markers = append(markers, rel)
+		case strings.HasPrefix(name, whiteoutPrefix):
+			whiteouts = append(whiteouts, filepath.Join(filepath.Dir(rel), strings.TrimPrefix(name, whiteoutPrefix)))
+			markers = append(markers, rel)
+		case d.Type()&fs.ModeCharDevice != 0:
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if isWhiteout(info) {
+				whiteouts = append(whiteouts, rel)
+				markers = append(markers, rel)
+			}
+		case d.IsDir() && path != layer && isOpaque(path):
+			opaque = append(opaque, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, rel := range opaque {
+		entries, err := os.ReadDir(filepath.Join(dir, rel))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := os.RemoveAll(filepath.Join(dir, rel, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	for _, rel := range whiteouts {
+		if err := os.RemoveAll(filepath.Join(dir, rel)); err != nil {
+			return err
+		}
+	}
+	// NOTE: cp -a keeps links, ownership and permissions intact
+	cmd := exec.Command("cp", "-a", layer+"/.", dir)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to copy layer: %w", err)
+	}
+	// the whiteouts themselves were copied along with the rest of the layer
+	for _, rel := range markers {
+		if err := os.Remove(filepath.Join(dir, rel)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	for _, rel := range opaque {
+		if err := syscall.Removexattr(filepath.Join(dir, rel), overlayOpaqueXattr()); err != nil && err != syscall.ENODATA && err != syscall.ENOTSUP {
+			return err
+		}
+	}
+	return nil
+}